@@ -10,6 +10,7 @@ import (
 	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/filters"
 	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/obfuscate"
 	"github.com/DataDog/datadog-trace-agent/quantizer"
 	"github.com/DataDog/datadog-trace-agent/sampler"
 	"github.com/DataDog/datadog-trace-agent/watchdog"
@@ -17,8 +18,10 @@ import (
 
 const (
 	processStatsInterval = time.Minute
-	languageHeaderKey    = "X-Datadog-Reported-Languages"
-	samplingPriorityKey  = "_sampling_priority_v1"
+	// assemblyExpireInterval is how often the traces waiting for their root are checked for expiry
+	assemblyExpireInterval = time.Second
+	languageHeaderKey      = "X-Datadog-Reported-Languages"
+	samplingPriorityKey    = "_sampling_priority_v1"
 )
 
 type processedTrace struct {
@@ -38,8 +41,11 @@ func (pt *processedTrace) weight() float64 {
 // Agent struct holds all the sub-routines structs and make the data flow between them
 type Agent struct {
 	Receiver       *HTTPReceiver
+	Assembler      *TraceAssembler // nil if trace assembly is disabled
 	Concentrator   *Concentrator
 	Filters        []filters.Filter
+	Quantizer      *quantizer.Quantizer
+	Obfuscator     *obfuscate.Obfuscator // nil if obfuscation is disabled
 	ScoreEngine    *Sampler
 	PriorityEngine *Sampler
 	Writer         *Writer
@@ -50,6 +56,8 @@ type Agent struct {
 
 	// Used to synchronize on a clean exit
 	exit chan struct{}
+	// Traces being added to the concentrator and samplers
+	processWG sync.WaitGroup
 
 	die func(format string, args ...interface{})
 }
@@ -63,20 +71,40 @@ func NewAgent(conf *config.AgentConfig, exit chan struct{}) *Agent {
 		conf.BucketInterval.Nanoseconds(),
 	)
 	f := filters.Setup(conf)
+	q := quantizer.NewQuantizer(conf)
+	var o *obfuscate.Obfuscator
+	if conf.ObfuscationEnabled {
+		o = obfuscate.NewObfuscator(conf)
+	}
+	var as *TraceAssembler
+	if conf.AssemblyWindow > 0 {
+		as = NewTraceAssembler(conf)
+	}
 	ss := NewScoreEngine(conf)
 	var ps *Sampler
 	if conf.PrioritySampling {
 		// Use priority sampling for distributed tracing only if conf says so
 		ps = NewPriorityEngine(conf, dynConf)
 	}
+	if conf.SamplerDebugDecisions > 0 {
+		// record the sampling decisions, to serve them on the receiver port
+		r.explainer = sampler.NewExplainer(conf.SamplerDebugDecisions, conf.SamplerDebugWindow)
+		ss.engine.SetExplainer(r.explainer)
+		if ps != nil {
+			ps.engine.SetExplainer(r.explainer)
+		}
+	}
 
 	w := NewWriter(conf)
 	w.inServices = r.services
 
 	return &Agent{
 		Receiver:       r,
+		Assembler:      as,
 		Concentrator:   c,
 		Filters:        f,
+		Quantizer:      q,
+		Obfuscator:     o,
 		ScoreEngine:    ss,
 		PriorityEngine: ps,
 		Writer:         w,
@@ -101,6 +129,26 @@ func (a *Agent) Run() {
 	// update the data served by expvar so that we don't expose a 0 sample rate
 	updatePreSampler(*a.Receiver.preSampler.Stats())
 
+	// restore the state of the samplers so that they don't oversample until
+	// their scores build up again, and save it periodically
+	var stateTick <-chan time.Time
+	if a.conf.SamplerStateFile != "" {
+		if err := loadSamplerState(a.conf.SamplerStateFile, a.conf.SamplerStateMaxAge, a.ScoreEngine, a.PriorityEngine); err != nil {
+			log.Warnf("starting with empty samplers: %v", err)
+		}
+		stateTicker := time.NewTicker(a.conf.SamplerStateInterval)
+		defer stateTicker.Stop()
+		stateTick = stateTicker.C
+	}
+
+	// check for the traces whose root did not arrive in time
+	var assemblyTick <-chan time.Time
+	if a.Assembler != nil {
+		assemblyTicker := time.NewTicker(assemblyExpireInterval)
+		defer assemblyTicker.Stop()
+		assemblyTick = assemblyTicker.C
+	}
+
 	a.Receiver.Run()
 	a.Writer.Run()
 	a.ScoreEngine.Run()
@@ -111,50 +159,137 @@ func (a *Agent) Run() {
 	for {
 		select {
 		case t := <-a.Receiver.traces:
-			a.Process(t)
+			a.assemble(t)
+		case <-assemblyTick:
+			a.expireAssembly()
 		case <-flushTicker.C:
-			p := model.AgentPayload{
-				HostName: a.conf.HostName,
-				Env:      a.conf.DefaultEnv,
-			}
-			var wg sync.WaitGroup
-			wg.Add(2)
-			go func() {
-				defer watchdog.LogOnPanic()
-				p.Stats = a.Concentrator.Flush()
-				wg.Done()
-			}()
-			go func() {
-				defer watchdog.LogOnPanic()
-				// Serializing both flushes, classic agent sampler and distributed sampler,
-				// in most cases only one will be used, so in mainstream case there should
-				// be no performance issue, only in transitionnal mode can both contain data.
-				p.Traces = a.ScoreEngine.Flush()
-				if a.PriorityEngine != nil {
-					p.Traces = append(p.Traces, a.PriorityEngine.Flush()...)
-				}
-				wg.Done()
-			}()
-
-			wg.Wait()
-			p.SetExtra(languageHeaderKey, a.Receiver.Languages())
-
-			a.Writer.inPayloads <- p
+			a.Writer.inPayloads <- a.flush(false)
 		case <-watchdogTicker.C:
 			a.watchdog()
+		case <-stateTick:
+			a.saveSamplerState()
 		case <-a.exit:
 			log.Info("exiting")
-			close(a.Receiver.exit)
-			a.Writer.Stop()
-			a.ScoreEngine.Stop()
-			if a.PriorityEngine != nil {
-				a.PriorityEngine.Stop()
-			}
+			a.stop()
 			return
 		}
 	}
 }
 
+// flush returns a payload with the complete stats buckets and the traces
+// sampled since the last flush. If force is true, stats buckets still open
+// are flushed too.
+func (a *Agent) flush(force bool) model.AgentPayload {
+	p := model.AgentPayload{
+		HostName: a.conf.HostName,
+		Env:      a.conf.DefaultEnv,
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer watchdog.LogOnPanic()
+		if force {
+			p.Stats = a.Concentrator.ForceFlush()
+		} else {
+			p.Stats = a.Concentrator.Flush()
+		}
+		wg.Done()
+	}()
+	go func() {
+		defer watchdog.LogOnPanic()
+		// Serializing both flushes, classic agent sampler and distributed sampler,
+		// in most cases only one will be used, so in mainstream case there should
+		// be no performance issue, only in transitionnal mode can both contain data.
+		p.Traces = a.ScoreEngine.Flush()
+		if a.PriorityEngine != nil {
+			p.Traces = append(p.Traces, a.PriorityEngine.Flush()...)
+		}
+		wg.Done()
+	}()
+
+	wg.Wait()
+	p.SetExtra(languageHeaderKey, a.Receiver.Languages())
+	return p
+}
+
+// stop shuts the agent down without losing data: the receiver stops
+// accepting requests, the traces it already received are processed, then
+// all stats buckets and sampled traces are flushed and the Writer is given
+// until WriterShutdownTimeout to deliver them.
+func (a *Agent) stop() {
+	stopped := make(chan struct{})
+	go func() {
+		defer watchdog.LogOnPanic()
+		if err := a.Receiver.Stop(); err != nil {
+			log.Errorf("error stopping the receiver: %v", err)
+		}
+		close(stopped)
+	}()
+
+	// keep processing traces while the requests being handled complete,
+	// then whatever is left in the channel
+	nbTraces := 0
+	draining := true
+	for draining {
+		select {
+		case t := <-a.Receiver.traces:
+			a.assemble(t)
+			nbTraces++
+		case <-stopped:
+			draining = false
+		}
+	}
+	for len(a.Receiver.traces) > 0 {
+		a.assemble(<-a.Receiver.traces)
+		nbTraces++
+	}
+	if a.Assembler != nil {
+		// the roots of the traces still buffered won't arrive anymore
+		for _, t := range a.Assembler.Flush() {
+			a.Process(t)
+		}
+	}
+	a.processWG.Wait()
+	log.Infof("processed %d traces received before exiting", nbTraces)
+
+	a.Writer.inPayloads <- a.flush(true)
+	a.Writer.Stop()
+
+	if a.conf.SamplerStateFile != "" {
+		a.saveSamplerState()
+	}
+	a.ScoreEngine.Stop()
+	if a.PriorityEngine != nil {
+		a.PriorityEngine.Stop()
+	}
+}
+
+// saveSamplerState saves the state of the samplers to the configured file
+func (a *Agent) saveSamplerState() {
+	if err := saveSamplerState(a.conf.SamplerStateFile, a.ScoreEngine, a.PriorityEngine); err != nil {
+		log.Errorf("error saving the sampler state: %v", err)
+	}
+}
+
+// assemble processes a trace received, or buffers it until it is complete
+// if it is a chunk of a trace and trace assembly is enabled.
+func (a *Agent) assemble(t model.Trace) {
+	if a.Assembler == nil {
+		a.Process(t)
+		return
+	}
+	for _, t := range a.Assembler.Add(t) {
+		a.Process(t)
+	}
+}
+
+// expireAssembly processes the traces whose root did not arrive within the assembly window
+func (a *Agent) expireAssembly() {
+	for _, t := range a.Assembler.Expire() {
+		a.Process(t)
+	}
+}
+
 // Process is the default work unit that receives a trace, transforms it and
 // passes it downstream.
 func (a *Agent) Process(t model.Trace) {
@@ -191,7 +326,12 @@ func (a *Agent) Process(t model.Trace) {
 	}
 	atomic.AddInt64(priorityPtr, 1)
 
-	if root.End() < model.Now()-2*a.conf.BucketInterval.Nanoseconds() {
+	maxDelay := 2 * a.conf.BucketInterval
+	if a.Assembler != nil {
+		// traces whose root never arrives are processed once the assembly window is over
+		maxDelay += a.conf.AssemblyWindow + assemblyExpireInterval
+	}
+	if root.End() < model.Now()-maxDelay.Nanoseconds() {
 		log.Errorf("skipping trace with root too far in past, root:%v", *root)
 
 		atomic.AddInt64(&ts.TracesDropped, 1)
@@ -221,7 +361,12 @@ func (a *Agent) Process(t model.Trace) {
 	model.SetSublayersOnSpan(root, sublayers)
 
 	for i := range t {
-		t[i] = quantizer.Quantize(t[i])
+		t[i] = a.Quantizer.Quantize(t[i])
+		// after quantization, which may set sql.query, and before
+		// truncation, which could cut through sensitive data
+		if a.Obfuscator != nil {
+			a.Obfuscator.Obfuscate(&t[i])
+		}
 		t[i].Truncate()
 	}
 
@@ -239,13 +384,16 @@ func (a *Agent) Process(t model.Trace) {
 	// as they access the Metrics map, which is not thread safe.
 	t.ComputeWeight(*root)
 	t.ComputeTopLevel()
+	a.processWG.Add(2)
 	go func() {
 		defer watchdog.LogOnPanic()
+		defer a.processWG.Done()
 		a.Concentrator.Add(pt)
 
 	}()
 	go func() {
 		defer watchdog.LogOnPanic()
+		defer a.processWG.Done()
 		s.Add(pt)
 	}()
 }
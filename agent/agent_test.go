@@ -77,6 +77,47 @@ func TestWatchdog(t *testing.T) {
 	buf[len(buf)-1] = 2
 }
 
+func TestAgentStop(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 1)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	agent := NewAgent(conf, make(chan struct{}))
+	agent.Writer.Run()
+
+	// traces received, but not processed yet, when the agent exits
+	for i := 0; i < 5; i++ {
+		agent.Receiver.traces <- model.Trace{model.Span{
+			TraceID:  uint64(i + 1),
+			SpanID:   1,
+			Service:  "svc",
+			Name:     "name",
+			Resource: "resource",
+			Start:    model.Now() - 1e6,
+			Duration: 1e6,
+			Metrics:  map[string]float64{samplingPriorityKey: 2},
+		}}
+	}
+	agent.stop()
+
+	select {
+	case received := <-data:
+		var p model.AgentPayload
+		assert.Nil(model.DecodeAgentPayload([]byte(received.body), &p))
+		// traces were sampled and their stats bucket flushed, though
+		// still open
+		assert.Len(p.Traces, 5)
+		assert.Len(p.Stats, 1)
+	case <-time.After(time.Second):
+		t.Fatal("no payload flushed on exit")
+	}
+}
+
 // Test to make sure that the joined effort of the quantizer and truncator, in that order, produce the
 // desired string
 func TestFormatTrace(t *testing.T) {
@@ -128,6 +169,18 @@ func BenchmarkAgentTraceProcessingWithWorstCaseFiltering(b *testing.B) {
 	runTraceProcessingBenchmark(b, c)
 }
 
+func BenchmarkAgentTraceProcessingWithObfuscation(b *testing.B) {
+	c := config.NewDefaultAgentConfig()
+	c.APIKey = "test"
+	c.ObfuscationEnabled = true
+	c.ObfuscationRemoveKeys = []string{"http.request.headers.cookie"}
+	c.ObfuscationRules = []config.ObfuscationRule{
+		{Name: "emails", Key: "*", Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`, Replacement: "?"},
+	}
+
+	runTraceProcessingBenchmark(b, c)
+}
+
 func runTraceProcessingBenchmark(b *testing.B, c *config.AgentConfig) {
 	exit := make(chan struct{})
 	agent := NewAgent(c, exit)
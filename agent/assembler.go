@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+// pendingTrace is a trace whose root was not received yet
+type pendingTrace struct {
+	traceID uint64
+	spans   model.Trace
+	first   time.Time // when its first chunk was received
+}
+
+// TraceAssembler buffers the chunks of the traces flushed in several parts by
+// tracers, so that each trace is processed once, complete. A trace is
+// complete when its root, the span without a parent, is received. Traces are
+// processed incomplete if their root is not received within the window, or
+// to keep at most maxSpans spans buffered.
+//
+// A chunk cannot tell a parent from another host from a parent not flushed
+// yet, so the traces continuing traces from other hosts, which have no span
+// without a parent, are only processed once their window expires.
+//
+// It is not safe for concurrent use, the agent only uses it from its main loop.
+type TraceAssembler struct {
+	window   time.Duration
+	maxSpans int
+
+	pending map[uint64]*list.Element
+	order   *list.List // of *pendingTrace, oldest first
+	spans   int        // the number of spans buffered
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewTraceAssembler returns a TraceAssembler configured by conf
+func NewTraceAssembler(conf *config.AgentConfig) *TraceAssembler {
+	return &TraceAssembler{
+		window:   conf.AssemblyWindow,
+		maxSpans: conf.AssemblyMaxSpans,
+		pending:  make(map[uint64]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// hasRoot tells if the chunk holds the root of its trace
+func hasRoot(t model.Trace) bool {
+	for i := range t {
+		if t[i].ParentID == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a chunk of trace and returns the traces ready to be processed:
+// the trace of the chunk if it is complete, and the traces evicted to make
+// room for it.
+func (a *TraceAssembler) Add(t model.Trace) []model.Trace {
+	if len(t) == 0 {
+		return nil
+	}
+	traceID := t[0].TraceID
+	e, ok := a.pending[traceID]
+
+	if hasRoot(t) {
+		if !ok {
+			// the most common case, the trace was sent at once
+			return []model.Trace{t}
+		}
+		pt := a.remove(e)
+		statsd.Client.Count("datadog.trace_agent.assembler.traces", 1, []string{"reason:complete"}, 1)
+		return []model.Trace{append(pt.spans, t...)}
+	}
+
+	if len(t) > a.maxSpans {
+		// the chunk alone is over the limit, no need to evict other traces for it
+		statsd.Client.Count("datadog.trace_agent.assembler.traces", 1, []string{"reason:too_large"}, 1)
+		if ok {
+			// along with the chunks already buffered, not to process the trace in two parts
+			return []model.Trace{append(a.remove(e).spans, t...)}
+		}
+		return []model.Trace{t}
+	}
+
+	var evicted []model.Trace
+	for a.spans+len(t) > a.maxSpans {
+		oldest := a.order.Front()
+		if oldest == e {
+			// evict the trace of the chunk last, it is about to be extended
+			oldest = oldest.Next()
+		}
+		if oldest == nil {
+			// the trace of the chunk is the only one buffered
+			evicted = append(evicted, append(a.remove(e).spans, t...))
+			statsd.Client.Count("datadog.trace_agent.assembler.traces", 1, []string{"reason:evicted"}, 1)
+			return evicted
+		}
+		evicted = append(evicted, a.remove(oldest).spans)
+		statsd.Client.Count("datadog.trace_agent.assembler.traces", 1, []string{"reason:evicted"}, 1)
+	}
+	if len(evicted) > 0 {
+		log.Debugf("evicted %d incomplete traces, over the limit of %d buffered spans", len(evicted), a.maxSpans)
+	}
+
+	if ok {
+		pt := e.Value.(*pendingTrace)
+		pt.spans = append(pt.spans, t...)
+	} else {
+		a.pending[traceID] = a.order.PushBack(&pendingTrace{traceID: traceID, spans: t, first: a.now()})
+	}
+	a.spans += len(t)
+
+	return evicted
+}
+
+// remove removes a buffered trace and returns it
+func (a *TraceAssembler) remove(e *list.Element) *pendingTrace {
+	pt := a.order.Remove(e).(*pendingTrace)
+	delete(a.pending, pt.traceID)
+	a.spans -= len(pt.spans)
+	return pt
+}
+
+// Expire returns the traces whose root was not received within the window,
+// and reports the state of the assembler.
+func (a *TraceAssembler) Expire() []model.Trace {
+	var expired []model.Trace
+	deadline := a.now().Add(-a.window)
+	for e := a.order.Front(); e != nil && !e.Value.(*pendingTrace).first.After(deadline); e = a.order.Front() {
+		expired = append(expired, a.remove(e).spans)
+	}
+	if len(expired) > 0 {
+		statsd.Client.Count("datadog.trace_agent.assembler.traces", int64(len(expired)), []string{"reason:expired"}, 1)
+	}
+
+	statsd.Client.Gauge("datadog.trace_agent.assembler.pending_traces", float64(len(a.pending)), nil, 1)
+	statsd.Client.Gauge("datadog.trace_agent.assembler.pending_spans", float64(a.spans), nil, 1)
+
+	return expired
+}
+
+// Flush returns all the buffered traces, complete or not
+func (a *TraceAssembler) Flush() []model.Trace {
+	var traces []model.Trace
+	for e := a.order.Front(); e != nil; e = a.order.Front() {
+		traces = append(traces, a.remove(e).spans)
+	}
+	return traces
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAssembler(maxSpans int) (*TraceAssembler, *time.Time) {
+	conf := config.NewDefaultAgentConfig()
+	conf.AssemblyWindow = 10 * time.Second
+	conf.AssemblyMaxSpans = maxSpans
+
+	now := time.Now()
+	a := NewTraceAssembler(conf)
+	a.now = func() time.Time { return now }
+	return a, &now
+}
+
+// testChunk returns a chunk of the trace traceID, made of spans with the
+// given span IDs, 1 being the root of the trace
+func testChunk(traceID uint64, spanIDs ...uint64) model.Trace {
+	var t model.Trace
+	for _, id := range spanIDs {
+		var parentID uint64
+		if id != 1 {
+			parentID = 1
+		}
+		t = append(t, model.Span{TraceID: traceID, SpanID: id, ParentID: parentID, Service: "web"})
+	}
+	return t
+}
+
+func TestTraceAssemblerComplete(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAssembler(100)
+
+	// complete traces are not buffered
+	traces := a.Add(testChunk(1, 1, 2))
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 2)
+
+	// chunks are buffered until the root arrives
+	assert.Len(a.Add(testChunk(2, 2, 3)), 0)
+	assert.Len(a.Add(testChunk(2, 4)), 0)
+	assert.Equal(3, a.spans)
+	traces = a.Add(testChunk(2, 1))
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 4)
+	assert.Equal(uint64(1), traces[0].GetRoot().SpanID)
+
+	assert.Len(a.pending, 0)
+	assert.Equal(0, a.order.Len())
+	assert.Equal(0, a.spans)
+}
+
+func TestTraceAssemblerExpire(t *testing.T) {
+	assert := assert.New(t)
+	a, now := newTestAssembler(100)
+
+	a.Add(testChunk(1, 2))
+	*now = now.Add(5 * time.Second)
+	a.Add(testChunk(2, 2))
+	a.Add(testChunk(1, 3))
+
+	assert.Len(a.Expire(), 0)
+	*now = now.Add(5 * time.Second)
+	traces := a.Expire()
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 2)
+	assert.Equal(uint64(1), traces[0][0].TraceID)
+
+	*now = now.Add(5 * time.Second)
+	traces = a.Expire()
+	assert.Len(traces, 1)
+	assert.Equal(uint64(2), traces[0][0].TraceID)
+	assert.Equal(0, a.spans)
+}
+
+func TestTraceAssemblerEvict(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAssembler(4)
+
+	a.Add(testChunk(1, 2, 3))
+	a.Add(testChunk(2, 2))
+
+	// the oldest traces are evicted to make room
+	traces := a.Add(testChunk(3, 2, 3))
+	assert.Len(traces, 1)
+	assert.Equal(uint64(1), traces[0][0].TraceID)
+	assert.Equal(3, a.spans)
+
+	// but not the trace of the chunk
+	traces = a.Add(testChunk(3, 4, 5))
+	assert.Len(traces, 1)
+	assert.Equal(uint64(2), traces[0][0].TraceID)
+	assert.Equal(4, a.spans)
+
+	// unless it is the only one left
+	traces = a.Add(testChunk(3, 6))
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 5)
+	assert.Equal(0, a.spans)
+
+	// chunks over the limit are not buffered
+	traces = a.Add(testChunk(4, 2, 3, 4, 5, 6))
+	assert.Len(traces, 1)
+	assert.Equal(0, a.spans)
+
+	// and processed along with the chunks of their trace already buffered
+	a.Add(testChunk(5, 2))
+	a.Add(testChunk(6, 2))
+	traces = a.Add(testChunk(5, 3, 4, 5, 6, 7))
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 6)
+	assert.Equal(uint64(5), traces[0][0].TraceID)
+	assert.Equal(1, a.spans)
+	assert.Len(a.pending, 1)
+}
+
+func TestTraceAssemblerFlush(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAssembler(100)
+
+	a.Add(testChunk(1, 2))
+	a.Add(testChunk(2, 2))
+	assert.Len(a.Flush(), 2)
+	assert.Len(a.Flush(), 0)
+}
+
+func TestAgentAssembly(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	conf.AssemblyWindow = time.Minute
+	agent := NewAgent(conf, make(chan struct{}))
+
+	now := model.Now()
+	chunk := testChunk(1, 2)
+	chunk[0].Start = now - 100
+	chunk[0].Duration = 50
+	agent.assemble(chunk)
+	root := testChunk(1, 1)
+	root[0].Start = now - 200
+	root[0].Duration = 200
+	agent.assemble(root)
+	agent.processWG.Wait()
+
+	// the trace was sampled once, complete
+	traces := agent.ScoreEngine.Flush()
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 2)
+}
+
+func TestAgentAssemblyExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	// longer than twice the bucket interval
+	conf.AssemblyWindow = time.Minute
+	agent := NewAgent(conf, make(chan struct{}))
+	now := time.Now()
+	agent.Assembler.now = func() time.Time { return now }
+
+	// a chunk of a long-running trace, whose root never arrives
+	chunk := testChunk(1, 2)
+	chunk[0].Start = model.Now() - int64(50*time.Second)
+	chunk[0].Duration = 100
+	agent.assemble(chunk)
+
+	now = now.Add(conf.AssemblyWindow)
+	agent.expireAssembly()
+	agent.processWG.Wait()
+
+	ts := agent.Receiver.stats.getTagStats(Tags{})
+	assert.Equal(int64(0), ts.TracesDropped)
+	assert.Len(agent.ScoreEngine.Flush(), 1)
+}
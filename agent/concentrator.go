@@ -58,20 +58,30 @@ func (c *Concentrator) Add(t processedTrace) {
 
 // Flush deletes and returns complete statistic buckets
 func (c *Concentrator) Flush() []model.StatsBucket {
+	return c.flush(false)
+}
+
+// ForceFlush deletes and returns all statistic buckets, including the ones
+// still open. It is meant to be called on exit.
+func (c *Concentrator) ForceFlush() []model.StatsBucket {
+	return c.flush(true)
+}
+
+func (c *Concentrator) flush(force bool) []model.StatsBucket {
 	var sb []model.StatsBucket
 	now := model.Now()
 
 	c.mu.Lock()
 	for ts, srb := range c.buckets {
-		bucket := srb.Export()
-
 		// always keep one bucket opened
 		// this is a trade-off: we accept slightly late traces (clock skew and stuff)
 		// but we delay flushing by at most 2 buckets
-		if ts > now-2*c.bsize {
+		if !force && ts > now-2*c.bsize {
 			continue
 		}
 
+		bucket := srb.Export()
+
 		log.Debugf("flushing bucket %d", ts)
 		for _, d := range bucket.Distributions {
 			statsd.Client.Histogram("datadog.trace_agent.distribution.len", float64(d.Summary.N), nil, 1)
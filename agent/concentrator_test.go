@@ -140,3 +140,26 @@ func TestConcentratorStatsCounts(t *testing.T) {
 		assert.Equal(val, int64(count.Value), "Wrong value for count %s", key)
 	}
 }
+
+func TestConcentratorForceFlush(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, testBucketInterval)
+
+	testTrace := processedTrace{
+		Env: "none",
+		Trace: model.Trace{
+			testSpan(c, 1, 24, 3, "A1", "resource1", 0),
+			// still open
+			testSpan(c, 2, 24, 1, "A1", "resource1", 0),
+			testSpan(c, 3, 24, 0, "A1", "resource1", 0),
+		},
+	}
+	testTrace.Trace.ComputeWeight(*testTrace.Trace.GetRoot())
+	testTrace.Trace.ComputeTopLevel()
+	c.Add(testTrace)
+
+	assert.Len(c.Flush(), 1)
+	// on exit, open buckets are flushed too
+	assert.Len(c.ForceFlush(), 2)
+	assert.Len(c.ForceFlush(), 0)
+}
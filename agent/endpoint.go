@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -21,8 +22,9 @@ var timeout = 10 * time.Second
 // apiError stores the error triggered we can't send data to the endpoint.
 // It implements the error interface.
 type apiError struct {
-	err      error
-	endpoint *APIEndpoint
+	err        error
+	endpoint   *APIEndpoint
+	retryAfter time.Duration // how long the endpoint asked us to wait, if it did
 }
 
 func newAPIError(err error, endpoint *APIEndpoint) *apiError {
@@ -45,6 +47,13 @@ type AgentEndpoint interface {
 	WriteServices(s model.ServicesMetadata)
 }
 
+// encodedWriter is implemented by the endpoints which can send a payload
+// already encoded, so that payloads sent to several endpoints are encoded once
+type encodedWriter interface {
+	// WriteEncoded sends p, data being its encoding
+	WriteEncoded(p model.AgentPayload, data []byte) (int, error)
+}
+
 // APIEndpoint implements AgentEndpoint to send data to a
 // an endpoint and API key.
 type APIEndpoint struct {
@@ -93,14 +102,18 @@ func (ae *APIEndpoint) SetProxy(settings *config.ProxySettings) {
 
 // Write will send the serialized payload to the API endpoint.
 func (ae *APIEndpoint) Write(p model.AgentPayload) (int, error) {
-	startFlush := time.Now()
-
 	// Serialize the payload to send it to the API
 	data, err := model.EncodeAgentPayload(&p)
 	if err != nil {
 		log.Errorf("encoding issue: %v", err)
 		return 0, err
 	}
+	return ae.WriteEncoded(p, data)
+}
+
+// WriteEncoded sends the payload p, already serialized to data, to the API endpoint.
+func (ae *APIEndpoint) WriteEncoded(p model.AgentPayload, data []byte) (int, error) {
+	startFlush := time.Now()
 
 	payloadSize := len(data)
 	statsd.Client.Count("datadog.trace_agent.writer.payload_bytes", int64(payloadSize), nil, 1)
@@ -143,9 +156,13 @@ func (ae *APIEndpoint) Write(p model.AgentPayload) (int, error) {
 		log.Error(err)
 		atomic.AddInt64(&ae.stats.TracesPayloadError, 1)
 
-		// Only retry for 5xx (server) errors
-		if resp.StatusCode/100 == 5 {
-			return payloadSize, newAPIError(err, ae)
+		// Only retry for 5xx (server) errors and rate limiting
+		if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+			aerr := newAPIError(err, ae)
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				aerr.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			}
+			return payloadSize, aerr
 		}
 
 		// Does not retry for other errors
@@ -160,6 +177,24 @@ func (ae *APIEndpoint) Write(p model.AgentPayload) (int, error) {
 	return payloadSize, nil
 }
 
+// parseRetryAfter parses the value of a Retry-After header, either a number
+// of seconds or an HTTP date. It returns 0 if there is no valid delay.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
 // WriteServices writes services to the services endpoint
 // This function very loosely logs and returns if any error happens.
 // See comment above.
@@ -210,6 +245,7 @@ func (ae *APIEndpoint) WriteServices(s model.ServicesMetadata) {
 // logStats periodically submits stats about the endpoint to statsd
 func (ae *APIEndpoint) logStats() {
 	var accStats endpointStats
+	tags := []string{"endpoint:" + ae.url}
 
 	for range time.Tick(time.Minute) {
 		// Load counters and reset them for the next flush
@@ -222,16 +258,16 @@ func (ae *APIEndpoint) logStats() {
 		accStats.ServicesPayloadError = atomic.SwapInt64(&ae.stats.ServicesPayloadError, 0)
 		accStats.ServicesBytes = atomic.SwapInt64(&ae.stats.ServicesBytes, 0)
 
-		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload", int64(accStats.TracesPayload), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload_error", int64(accStats.TracesPayloadError), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.traces_bytes", int64(accStats.TracesBytes), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.traces_count", int64(accStats.TracesCount), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.traces_stats", int64(accStats.TracesStats), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.services_payload", int64(accStats.ServicesPayload), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.services_payload_error", int64(accStats.ServicesPayloadError), nil, 1)
-		statsd.Client.Count("datadog.trace_agent.endpoint.services_bytes", int64(accStats.ServicesBytes), nil, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload", int64(accStats.TracesPayload), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.traces_payload_error", int64(accStats.TracesPayloadError), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.traces_bytes", int64(accStats.TracesBytes), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.traces_count", int64(accStats.TracesCount), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.traces_stats", int64(accStats.TracesStats), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.services_payload", int64(accStats.ServicesPayload), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.services_payload_error", int64(accStats.ServicesPayloadError), tags, 1)
+		statsd.Client.Count("datadog.trace_agent.endpoint.services_bytes", int64(accStats.ServicesBytes), tags, 1)
 
-		updateEndpointStats(accStats)
+		updateEndpointStats(ae, accStats)
 	}
 }
 
@@ -264,6 +300,18 @@ type endpointStats struct {
 	ServicesBytes int64
 }
 
+// add adds the counters of o to es
+func (es *endpointStats) add(o endpointStats) {
+	es.TracesPayload += o.TracesPayload
+	es.TracesPayloadError += o.TracesPayloadError
+	es.TracesBytes += o.TracesBytes
+	es.TracesCount += o.TracesCount
+	es.TracesStats += o.TracesStats
+	es.ServicesPayload += o.ServicesPayload
+	es.ServicesPayloadError += o.ServicesPayloadError
+	es.ServicesBytes += o.ServicesBytes
+}
+
 // NullEndpoint implements AgentEndpoint, it just logs data
 // and drops everything into /dev/null
 type NullEndpoint struct{}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+const (
+	// FileFormatNDJSON writes one JSON record per line
+	FileFormatNDJSON = "ndjson"
+	// FileFormatWire writes records as they would be sent to the API,
+	// see writeWireRecord
+	FileFormatWire = "wire"
+
+	// fileRecordPayload and fileRecordServices are the types of records
+	fileRecordPayload  = "payload"
+	fileRecordServices = "services"
+
+	// fileNamePrefix prefixes the names of files written by a FileEndpoint
+	fileNamePrefix = "trace-agent-"
+	// fileActiveExt is appended to the name of the file being written, it
+	// is removed on rotation so that complete files can be shipped safely
+	fileActiveExt = ".active"
+)
+
+// fileRecord is a payload or services update written by a FileEndpoint. In
+// the wire format, Payload and Services are not part of the record header,
+// they follow it encoded as they would be sent to the API.
+type fileRecord struct {
+	Type     string                    `json:"type"`
+	Time     int64                     `json:"time"`              // nanosecond epoch of the write
+	Version  model.AgentPayloadVersion `json:"version,omitempty"` // the wire encoding of the record
+	Extras   map[string]string         `json:"extras,omitempty"`
+	Payload  *model.AgentPayload       `json:"payload,omitempty"`
+	Services model.ServicesMetadata    `json:"services,omitempty"`
+}
+
+// FileEndpoint implements AgentEndpoint to write payloads and services
+// updates to local files, for hosts which cannot reach the API. Files are
+// rotated once they reach a size or an age, and only the most recent ones
+// are kept. They can be sent to the API later with the -replay option.
+type FileEndpoint struct {
+	dir            string
+	format         string
+	rotateSize     int64         // rotate after this many bytes, 0 to disable
+	rotateInterval time.Duration // rotate files older than this, 0 to disable
+	maxFiles       int           // the number of rotated files to keep, 0 for all
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	name    string    // the name of the file once rotated
+	size    int64     // bytes written to the current file
+	created time.Time // when the current file was created
+	seq     uint64    // disambiguates files created within the same nanosecond
+}
+
+// NewFileEndpoint returns a FileEndpoint writing to the directory and with
+// the rotation policy given in conf.
+func NewFileEndpoint(conf *config.AgentConfig) (*FileEndpoint, error) {
+	switch conf.FileOutputFormat {
+	case FileFormatNDJSON, FileFormatWire:
+	default:
+		return nil, fmt.Errorf("unknown file output format %q", conf.FileOutputFormat)
+	}
+	if err := os.MkdirAll(conf.FileOutputDir, 0700); err != nil {
+		return nil, err
+	}
+
+	fe := &FileEndpoint{
+		dir:            conf.FileOutputDir,
+		format:         conf.FileOutputFormat,
+		rotateSize:     int64(conf.FileOutputRotateSize),
+		rotateInterval: conf.FileOutputRotateInterval,
+		maxFiles:       conf.FileOutputMaxFiles,
+	}
+
+	// files left active by a previous run hold complete records, save for
+	// the last one if we crashed, make them available
+	infos, err := ioutil.ReadDir(fe.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range infos {
+		if name := fi.Name(); strings.HasPrefix(name, fileNamePrefix) && strings.HasSuffix(name, fileActiveExt) {
+			os.Rename(filepath.Join(fe.dir, name), filepath.Join(fe.dir, strings.TrimSuffix(name, fileActiveExt)))
+		}
+	}
+	fe.removeOldFiles()
+
+	return fe, nil
+}
+
+// Write writes the payload to the current file.
+func (fe *FileEndpoint) Write(p model.AgentPayload) (int, error) {
+	r := fileRecord{Type: fileRecordPayload, Extras: p.Extras(), Payload: &p}
+	size, err := fe.writeRecord(&r)
+	if err != nil {
+		log.Errorf("cannot write payload to %s: %v", fe.dir, err)
+		return size, err
+	}
+	log.Debugf("wrote payload to %s, size:%d", fe.dir, size)
+	return size, nil
+}
+
+// WriteServices writes the services update to the current file.
+func (fe *FileEndpoint) WriteServices(s model.ServicesMetadata) {
+	r := fileRecord{Type: fileRecordServices, Services: s}
+	if _, err := fe.writeRecord(&r); err != nil {
+		log.Errorf("cannot write services to %s: %v", fe.dir, err)
+		return
+	}
+	log.Debugf("wrote %d services to %s", len(s), fe.dir)
+}
+
+// Close flushes and rotates the current file.
+func (fe *FileEndpoint) Close() error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.rotate()
+}
+
+// writeRecord writes r to the current file, rotating it if needed, and
+// returns the number of bytes written.
+func (fe *FileEndpoint) writeRecord(r *fileRecord) (int, error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	now := time.Now()
+	if fe.file != nil && fe.rotateInterval > 0 && now.Sub(fe.created) >= fe.rotateInterval {
+		if err := fe.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if fe.file == nil {
+		if err := fe.open(now); err != nil {
+			return 0, err
+		}
+	}
+
+	r.Time = now.UnixNano()
+	var n int
+	var err error
+	if fe.format == FileFormatWire {
+		n, err = writeWireRecord(fe.buf, r)
+	} else {
+		n, err = writeNDJSONRecord(fe.buf, r)
+	}
+	if err == nil {
+		// make records available to readers as soon as they are written
+		err = fe.buf.Flush()
+	}
+	fe.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if fe.rotateSize > 0 && fe.size >= fe.rotateSize {
+		return n, fe.rotate()
+	}
+	return n, nil
+}
+
+// open creates a new current file
+func (fe *FileEndpoint) open(now time.Time) error {
+	fe.seq++
+	fe.name = fmt.Sprintf("%s%020d-%06d.%s", fileNamePrefix, now.UnixNano(), fe.seq%1e6, fe.format)
+	f, err := os.OpenFile(filepath.Join(fe.dir, fe.name+fileActiveExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	fe.file = f
+	fe.buf = bufio.NewWriter(f)
+	fe.size = 0
+	fe.created = now
+	return nil
+}
+
+// rotate closes the current file, makes it available under its final name
+// and removes the files we should not keep anymore.
+func (fe *FileEndpoint) rotate() error {
+	if fe.file == nil {
+		return nil
+	}
+
+	err := fe.buf.Flush()
+	if cerr := fe.file.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Rename(fe.file.Name(), filepath.Join(fe.dir, fe.name)); err == nil {
+		err = rerr
+	}
+	fe.file = nil
+	fe.buf = nil
+
+	fe.removeOldFiles()
+	return err
+}
+
+// removeOldFiles removes the oldest rotated files, replayed or not, so that at
+// most maxFiles are kept.
+func (fe *FileEndpoint) removeOldFiles() {
+	if fe.maxFiles <= 0 {
+		return
+	}
+	files, err := listRotatedFiles(fe.dir)
+	if err != nil {
+		log.Errorf("cannot list files in %s: %v", fe.dir, err)
+		return
+	}
+	for len(files) > fe.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			log.Errorf("cannot remove old file: %v", err)
+		}
+		progress := strings.TrimSuffix(files[0], fileReplayedExt) + fileProgressExt
+		if err := os.Remove(progress); err != nil && !os.IsNotExist(err) {
+			log.Errorf("cannot remove old file: %v", err)
+		}
+		files = files[1:]
+	}
+}
+
+// listOutputFiles returns the paths of the rotated files in dir, oldest
+// first. Files already replayed are left out.
+func listOutputFiles(dir string) ([]string, error) {
+	rotated, err := listRotatedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range rotated {
+		if !strings.HasSuffix(file, fileReplayedExt) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// listRotatedFiles returns the paths of the rotated files in dir, replayed
+// or not, oldest first.
+func listRotatedFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, fileNamePrefix) || strings.HasSuffix(name, fileActiveExt) ||
+			strings.Contains(name, fileProgressExt) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	// names start with a fixed-width timestamp
+	sort.Strings(files)
+	return files, nil
+}
+
+// writeNDJSONRecord writes r as a single line of JSON
+func writeNDJSONRecord(w io.Writer, r *fileRecord) (int, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(append(data, '\n'))
+}
+
+// writeWireRecord writes r in the wire format: the big-endian uint32 length
+// of the JSON record header, the header, then the uint32 length of the body
+// and the body, which is the payload or services encoded as they would be
+// sent to the API.
+func writeWireRecord(w io.Writer, r *fileRecord) (int, error) {
+	var body []byte
+	var err error
+	switch r.Type {
+	case fileRecordPayload:
+		body, err = model.EncodeAgentPayload(r.Payload)
+	case fileRecordServices:
+		body, err = model.EncodeServicesPayload(r.Services)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	header := *r
+	header.Version = model.GlobalAgentPayloadVersion
+	header.Payload = nil
+	header.Services = nil
+	data, err := json.Marshal(&header)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 0, 8+len(data)+len(body))
+	buf = appendUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	buf = appendUint32(buf, uint32(len(body)))
+	buf = append(buf, body...)
+	return w.Write(buf)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+// maxFileChunkSize bounds the size of the lines, record headers and bodies read
+const maxFileChunkSize = 256 * 1024 * 1024
+
+// errTruncatedRecord is returned when a file ends in the middle of a record,
+// which happens if the agent crashed while writing it.
+var errTruncatedRecord = errors.New("truncated record")
+
+// fileRecordReader reads the records of a file written by a FileEndpoint
+type fileRecordReader struct {
+	r       *bufio.Reader
+	wire    bool
+	maxSize int // the maximum size of a line or chunk, past it the file is deemed corrupt
+}
+
+func newFileRecordReader(r io.Reader, format string) *fileRecordReader {
+	return &fileRecordReader{r: bufio.NewReader(r), wire: format == FileFormatWire, maxSize: maxFileChunkSize}
+}
+
+// Next returns the next record, or io.EOF once all records were read.
+func (fr *fileRecordReader) Next() (*fileRecord, error) {
+	if fr.wire {
+		return fr.nextWire()
+	}
+
+	line, err := fr.readLine()
+	if err == io.EOF {
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, errTruncatedRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r fileRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (fr *fileRecordReader) nextWire() (*fileRecord, error) {
+	header, err := fr.readChunk()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := fr.readChunk()
+	if err == io.EOF {
+		return nil, errTruncatedRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r fileRecord
+	if err := json.Unmarshal(header, &r); err != nil {
+		return nil, err
+	}
+
+	switch r.Type {
+	case fileRecordPayload:
+		r.Payload = &model.AgentPayload{}
+		err = model.DecodeAgentPayloadVersion(r.Version, body, r.Payload)
+	case fileRecordServices:
+		err = json.Unmarshal(body, &r.Services)
+	default:
+		err = fmt.Errorf("unknown record type %q", r.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// readLine reads a line, up to maxSize bytes
+func (fr *fileRecordReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		part, err := fr.r.ReadSlice('\n')
+		if len(line)+len(part) > fr.maxSize {
+			return nil, fmt.Errorf("corrupt record, line over %d bytes", fr.maxSize)
+		}
+		line = append(line, part...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// readChunk reads a uint32 length followed by as many bytes
+func (fr *fileRecordReader) readChunk() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(fr.r, size[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTruncatedRecord
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if uint64(n) > uint64(fr.maxSize) {
+		return nil, fmt.Errorf("corrupt record, chunk of %d bytes", n)
+	}
+	// the buffer grows as data is read, so that a corrupt length does not
+	// allocate more than what the file holds
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data, fr.r, int64(n)); err != nil {
+		if err == io.EOF {
+			return nil, errTruncatedRecord
+		}
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
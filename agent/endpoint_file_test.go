@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFileEndpoint(t *testing.T, format string) (*FileEndpoint, *config.AgentConfig, func()) {
+	dir, err := ioutil.TempDir("", "trace-agent-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.NewDefaultAgentConfig()
+	conf.FileOutputDir = dir
+	conf.FileOutputFormat = format
+
+	fe, err := NewFileEndpoint(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fe, conf, func() { os.RemoveAll(dir) }
+}
+
+// readTestRecords reads back all the records of the rotated files of fe
+func readTestRecords(t *testing.T, fe *FileEndpoint) []*fileRecord {
+	files, err := listOutputFiles(fe.dir)
+	assert.Nil(t, err)
+
+	var records []*fileRecord
+	for _, file := range files {
+		f, err := os.Open(file)
+		assert.Nil(t, err)
+		reader := newFileRecordReader(f, fe.format)
+		for {
+			r, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			if err != nil {
+				break
+			}
+			records = append(records, r)
+		}
+		f.Close()
+	}
+	return records
+}
+
+func TestFileEndpointFormats(t *testing.T) {
+	for _, format := range []string{FileFormatNDJSON, FileFormatWire} {
+		assert := assert.New(t)
+		fe, _, cleanup := newTestFileEndpoint(t, format)
+		defer cleanup()
+
+		payload := newTestPayload("test")
+		payload.SetExtra("X-Datadog-Reported-Languages", "go")
+		_, err := fe.Write(payload)
+		assert.Nil(err, format)
+		fe.WriteServices(model.ServicesMetadata{"mcnulty": {"app_type": "web"}})
+		assert.Nil(fe.Close(), format)
+
+		records := readTestRecords(t, fe)
+		assert.Len(records, 2, format)
+
+		assert.Equal(fileRecordPayload, records[0].Type, format)
+		assert.Equal("test", records[0].Payload.Env, format)
+		assert.Len(records[0].Payload.Traces, 1, format)
+		assert.Equal(payload.Traces[0][0].SpanID, records[0].Payload.Traces[0][0].SpanID, format)
+		assert.Equal(payload.Traces[0][0].Meta, records[0].Payload.Traces[0][0].Meta, format)
+		assert.Equal(payload.Stats, records[0].Payload.Stats, format)
+		assert.Equal(map[string]string{"X-Datadog-Reported-Languages": "go"}, records[0].Extras, format)
+
+		assert.Equal(fileRecordServices, records[1].Type, format)
+		assert.Equal(model.ServicesMetadata{"mcnulty": {"app_type": "web"}}, records[1].Services, format)
+	}
+}
+
+func TestFileEndpointRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	fe, _, cleanup := newTestFileEndpoint(t, FileFormatNDJSON)
+	defer cleanup()
+	fe.rotateSize = 1 // one record per file
+	fe.maxFiles = 3
+
+	for i := 0; i < 5; i++ {
+		_, err := fe.Write(newTestPayload("test"))
+		assert.Nil(err)
+	}
+
+	// only the 3 most recent files are kept
+	files, err := listOutputFiles(fe.dir)
+	assert.Nil(err)
+	assert.Len(files, 3)
+
+	// rotation on age
+	fe.rotateSize = 0
+	fe.maxFiles = 0
+	fe.rotateInterval = time.Hour
+	fe.Write(newTestPayload("test"))
+	fe.Write(newTestPayload("test"))
+	files, _ = listOutputFiles(fe.dir)
+	assert.Len(files, 3) // the current file is not listed
+
+	fe.created = fe.created.Add(-2 * time.Hour)
+	fe.Write(newTestPayload("test"))
+	files, _ = listOutputFiles(fe.dir)
+	assert.Len(files, 4)
+	assert.Len(readTestRecords(t, fe), 5)
+}
+
+func TestFileEndpointRemoveReplayed(t *testing.T) {
+	assert := assert.New(t)
+
+	fe, _, cleanup := newTestFileEndpoint(t, FileFormatNDJSON)
+	defer cleanup()
+	fe.rotateSize = 1 // one record per file
+	fe.maxFiles = 2
+
+	fe.Write(newTestPayload("test"))
+	fe.Write(newTestPayload("test"))
+	files, err := listOutputFiles(fe.dir)
+	assert.Nil(err)
+	assert.Len(files, 2)
+
+	// one file was replayed, the other one partially
+	replayed := files[0] + fileReplayedExt
+	assert.Nil(os.Rename(files[0], replayed))
+	progress := files[1] + fileProgressExt
+	assert.Nil(ioutil.WriteFile(progress, []byte("{}"), 0600))
+
+	// replayed files count towards the limit and go first, with their progress
+	fe.Write(newTestPayload("test"))
+	fe.Write(newTestPayload("test"))
+	rotated, err := listRotatedFiles(fe.dir)
+	assert.Nil(err)
+	assert.Len(rotated, 2)
+	for _, path := range []string{replayed, files[1], progress} {
+		_, err := os.Stat(path)
+		assert.True(os.IsNotExist(err), path)
+	}
+}
+
+func TestFileEndpointActiveFile(t *testing.T) {
+	assert := assert.New(t)
+
+	fe, conf, cleanup := newTestFileEndpoint(t, FileFormatWire)
+	defer cleanup()
+
+	fe.Write(newTestPayload("test"))
+	// simulate a crash in the middle of a record
+	fe.file.Write([]byte{0, 0, 1})
+	fe.file.Close()
+
+	// the file is picked up by the next run
+	fe, err := NewFileEndpoint(conf)
+	assert.Nil(err)
+	files, _ := listOutputFiles(fe.dir)
+	assert.Len(files, 1)
+
+	f, err := os.Open(files[0])
+	assert.Nil(err)
+	defer f.Close()
+	reader := newFileRecordReader(f, FileFormatWire)
+	r, err := reader.Next()
+	assert.Nil(err)
+	assert.Equal("test", r.Payload.Env)
+	_, err = reader.Next()
+	assert.Equal(errTruncatedRecord, err)
+}
+
+func TestReplayFiles(t *testing.T) {
+	assert := assert.New(t)
+	defer func(v model.AgentPayloadVersion) { model.GlobalAgentPayloadVersion = v }(model.GlobalAgentPayloadVersion)
+
+	fe, conf, cleanup := newTestFileEndpoint(t, FileFormatWire)
+	defer cleanup()
+
+	// payloads are replayed with the version they were written with
+	model.GlobalAgentPayloadVersion = model.AgentPayloadV02
+	payload := newTestPayload("test")
+	payload.SetExtra("X-Datadog-Reported-Languages", "go")
+	fe.Write(payload)
+	fe.WriteServices(model.ServicesMetadata{"mcnulty": {"app_type": "web"}})
+	fe.Close()
+	model.GlobalAgentPayloadVersion = model.AgentPayloadV01
+
+	data := make(chan dataFromAPI, 2)
+	server := newTestServer(t, data)
+	defer server.Close()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+
+	assert.Nil(replayFiles(fe.dir, conf))
+	assert.Equal(model.AgentPayloadV01, model.GlobalAgentPayloadVersion)
+
+	received := <-data
+	assert.Equal("/api/v0.2/collector", received.urlPath)
+	assert.Equal("go", received.header.Get("X-Datadog-Reported-Languages"))
+	var p model.AgentPayload
+	assert.Nil(model.DecodeAgentPayloadVersion(model.AgentPayloadV02, []byte(received.body), &p))
+	assert.Equal("test", p.Env)
+
+	received = <-data
+	assert.Equal("/api/v0.2/services", received.urlPath)
+	assert.Equal(`{"mcnulty":{"app_type":"web"}}`, received.body)
+
+	// replayed files are not replayed again
+	files, err := listOutputFiles(fe.dir)
+	assert.Nil(err)
+	assert.Len(files, 0)
+	assert.Nil(replayFiles(fe.dir, conf))
+	assert.Len(data, 0)
+
+	// a missing path is an error
+	assert.NotNil(replayFiles(filepath.Join(fe.dir, "missing"), conf))
+}
+
+func TestReplayFilesResume(t *testing.T) {
+	assert := assert.New(t)
+
+	fe, conf, cleanup := newTestFileEndpoint(t, FileFormatWire)
+	defer cleanup()
+	for _, env := range []string{"a", "b", "c"} {
+		fe.Write(newTestPayload(env))
+	}
+	fe.Close()
+	files, err := listOutputFiles(fe.dir)
+	assert.Nil(err)
+	assert.Len(files, 1)
+
+	// the first endpoint always succeeds, the second one fails after a payload
+	var received [2]int64
+	var failing int32 = 1
+	newServer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if i == 1 && atomic.LoadInt32(&failing) == 1 && atomic.LoadInt64(&received[i]) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			atomic.AddInt64(&received[i], 1)
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	ok, flaky := newServer(0), newServer(1)
+	defer ok.Close()
+	defer flaky.Close()
+	conf.APIEndpoints = []string{ok.URL, flaky.URL}
+	conf.APIKey = "key"
+
+	// the first endpoint receives everything, the failure is reported
+	assert.NotNil(replayFiles(fe.dir, conf))
+	assert.Equal(int64(3), atomic.LoadInt64(&received[0]))
+	assert.Equal(int64(1), atomic.LoadInt64(&received[1]))
+	_, err = os.Stat(files[0] + fileProgressExt)
+	assert.Nil(err)
+
+	// the next replay resumes where each endpoint stopped
+	atomic.StoreInt32(&failing, 0)
+	assert.Nil(replayFiles(fe.dir, conf))
+	assert.Equal(int64(3), atomic.LoadInt64(&received[0]))
+	assert.Equal(int64(3), atomic.LoadInt64(&received[1]))
+
+	_, err = os.Stat(files[0] + fileReplayedExt)
+	assert.Nil(err)
+	_, err = os.Stat(files[0] + fileProgressExt)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestFileRecordReaderCorrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	// a huge length is rejected
+	reader := newFileRecordReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, '{', '}'}), FileFormatWire)
+	_, err := reader.Next()
+	assert.NotNil(err)
+	assert.NotEqual(errTruncatedRecord, err)
+
+	// a length past the end of the file is a truncated record
+	reader = newFileRecordReader(bytes.NewReader([]byte{0, 0x10, 0, 0, '{', '}'}), FileFormatWire)
+	_, err = reader.Next()
+	assert.Equal(errTruncatedRecord, err)
+
+	// a line past the maximum size is rejected
+	line := append(bytes.Repeat([]byte{' '}, 10000), "{}\n"...)
+	reader = newFileRecordReader(bytes.NewReader(line), FileFormatNDJSON)
+	reader.maxSize = 10001
+	_, err = reader.Next()
+	assert.NotNil(err)
+	assert.NotEqual(errTruncatedRecord, err)
+	// even without an end of line
+	reader = newFileRecordReader(bytes.NewReader(line[:len(line)-1]), FileFormatNDJSON)
+	reader.maxSize = 10001
+	_, err = reader.Next()
+	assert.NotNil(err)
+	assert.NotEqual(errTruncatedRecord, err)
+
+	// lines longer than the read buffer are read whole
+	reader = newFileRecordReader(bytes.NewReader(line), FileFormatNDJSON)
+	r, err := reader.Next()
+	assert.Nil(err)
+	assert.NotNil(r)
+}
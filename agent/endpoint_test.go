@@ -1,12 +1,63 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DataDog/datadog-trace-agent/fixtures"
 	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
 )
 
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2018, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	assert.Equal(time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("-3", now))
+	assert.Equal(120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(90*time.Second, parseRetryAfter("Tue, 02 Jan 2018 15:05:35 GMT", now))
+	assert.Equal(time.Duration(0), parseRetryAfter("Tue, 02 Jan 2018 15:00:00 GMT", now))
+}
+
+func TestAPIEndpointRetryableErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		status     int
+		retryAfter string
+		retryable  bool
+		delay      time.Duration
+	}{
+		{http.StatusInternalServerError, "", true, 0},
+		{http.StatusServiceUnavailable, "7", true, 7 * time.Second},
+		{http.StatusTooManyRequests, "3", true, 3 * time.Second},
+		{http.StatusTooManyRequests, "", true, 0},
+		{http.StatusBadGateway, "7", true, 0},
+		{http.StatusBadRequest, "7", false, 0},
+		{http.StatusRequestEntityTooLarge, "", false, 0},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.retryAfter != "" {
+				w.Header().Set("Retry-After", tc.retryAfter)
+			}
+			w.WriteHeader(tc.status)
+		}))
+
+		_, err := NewAPIEndpoint(server.URL, "key").Write(newTestPayload("test"))
+		assert.NotNil(err)
+		aerr, ok := err.(*apiError)
+		assert.Equal(tc.retryable, ok, "status %d", tc.status)
+		if ok {
+			assert.Equal(tc.delay, aerr.retryAfter, "status %d", tc.status)
+		}
+		server.Close()
+	}
+}
+
 func newBenchPayload(traces, spans, stats int) model.AgentPayload {
 	payload := model.AgentPayload{
 		HostName: "test.host",
@@ -41,3 +92,10 @@ func BenchmarkEncodeAgentPayload(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkEncodeAgentPayloadV02(b *testing.B) {
+	defer func(v model.AgentPayloadVersion) { model.GlobalAgentPayloadVersion = v }(model.GlobalAgentPayloadVersion)
+	model.GlobalAgentPayloadVersion = model.AgentPayloadV02
+
+	BenchmarkEncodeAgentPayload(b)
+}
@@ -21,7 +21,7 @@ import (
 var (
 	infoMu                  sync.RWMutex
 	infoReceiverStats       []tagStats    // only for the last minute
-	infoEndpointStats       endpointStats // only for the last minute
+	infoEndpointStats       map[*APIEndpoint]endpointStats // only for the last minute
 	infoWatchdogInfo        watchdog.Info
 	infoSamplerInfo         samplerInfo
 	infoPrioritySamplerInfo samplerInfo
@@ -44,8 +44,10 @@ const (
   Mem alloc: {{.Status.MemStats.Alloc}} bytes
 
   Hostname: {{.Status.Config.HostName}}
-  Receiver: {{.Status.Config.ReceiverHost}}:{{.Status.Config.ReceiverPort}}
-  API Endpoint: {{.Status.Config.APIEndpoint}}{{ range $i, $ts := .Status.Receiver }}
+  Receiver: {{.Status.Config.ReceiverHost}}:{{.Status.Config.ReceiverPort}}{{if .Status.Config.ReceiverSocket}}
+  Receiver socket: {{.Status.Config.ReceiverSocket}}{{end}}
+  API Endpoint: {{.Status.Config.APIEndpoint}}{{ range $i, $e := .Status.Config.APIEndpoints }}{{if $i}}
+  API Endpoint: {{ $e }}{{end}}{{end}}{{ range $i, $ts := .Status.Receiver }}
 
   --- Receiver stats (1 min) ---
 
@@ -61,7 +63,9 @@ const (
 
   ------------------------------{{end}}
 {{ range $key, $value := .Status.RateByService }}
-  Sample rate for '{{ $key }}': {{percent $value}} %{{ end }}{{if lt .Status.PreSampler.Rate 1.0}}
+  Sample rate for '{{ $key }}': {{percent $value}} %{{ end }}{{ range .Status.PrioritySampler.State.Budgets }}
+  Priority sampling budget for '{{ .Key }}': {{printf "%.1f" .TPS}}/{{printf "%.1f" .MaxTPS}} traces/s ({{percent .Utilization}} %){{ end }}{{ range .Status.Sampler.State.Budgets }}
+  Sampling budget for '{{ .Key }}': {{printf "%.1f" .TPS}}/{{printf "%.1f" .MaxTPS}} traces/s ({{percent .Utilization}} %){{ end }}{{if lt .Status.PreSampler.Rate 1.0}}
 
   WARNING: Pre-sampling traces: {{percent .Status.PreSampler.Rate}} %
 {{end}}{{if .Status.PreSampler.Error}}  WARNING: Pre-sampler: {{.Status.PreSampler.Error}}
@@ -72,7 +76,15 @@ const (
   Stats sent (1 min): {{.Status.Endpoint.TracesStats}}
 {{if gt .Status.Endpoint.TracesPayloadError 0}}  WARNING: Traces API errors (1 min): {{.Status.Endpoint.TracesPayloadError}}/{{.Status.Endpoint.TracesPayload}}
 {{end}}{{if gt .Status.Endpoint.ServicesPayloadError 0}}  WARNING: Services API errors (1 min): {{.Status.Endpoint.ServicesPayloadError}}/{{.Status.Endpoint.ServicesPayload}}
-{{end}}
+{{end}}{{if gt (len .Status.Endpoints) 1}}{{ range $url, $es := .Status.Endpoints }}
+  --- Endpoint {{ $url }} (1 min) ---
+
+    Bytes sent: {{add $es.TracesBytes $es.ServicesBytes}}
+    Traces sent: {{$es.TracesCount}}
+    Stats sent: {{$es.TracesStats}}
+{{if gt $es.TracesPayloadError 0}}    WARNING: Traces API errors: {{$es.TracesPayloadError}}/{{$es.TracesPayload}}
+{{end}}{{if gt $es.ServicesPayloadError 0}}    WARNING: Services API errors: {{$es.ServicesPayloadError}}/{{$es.ServicesPayload}}
+{{end}}{{end}}{{end}}
 `
 	infoNotRunningTmplSrc = `{{.Banner}}
 {{.Program}}
@@ -115,16 +127,38 @@ func publishReceiverStats() interface{} {
 	return infoReceiverStats
 }
 
-func updateEndpointStats(es endpointStats) {
+func updateEndpointStats(ae *APIEndpoint, es endpointStats) {
 	infoMu.Lock()
 	defer infoMu.Unlock()
-	infoEndpointStats = es
+	if infoEndpointStats == nil {
+		infoEndpointStats = make(map[*APIEndpoint]endpointStats)
+	}
+	infoEndpointStats[ae] = es
 }
 
+// publishEndpointStats publishes the stats of all the endpoints summed up
 func publishEndpointStats() interface{} {
 	infoMu.RLock()
 	defer infoMu.RUnlock()
-	return infoEndpointStats
+	var total endpointStats
+	for _, es := range infoEndpointStats {
+		total.add(es)
+	}
+	return total
+}
+
+// publishEndpointsStats publishes the stats of each endpoint URL. Endpoints
+// sharing the same URL with different API keys are summed up.
+func publishEndpointsStats() interface{} {
+	infoMu.RLock()
+	defer infoMu.RUnlock()
+	byURL := make(map[string]endpointStats, len(infoEndpointStats))
+	for ae, es := range infoEndpointStats {
+		total := byURL[ae.url]
+		total.add(es)
+		byURL[ae.url] = total
+	}
+	return byURL
 }
 
 func updateSamplerInfo(ss samplerInfo) {
@@ -230,6 +264,7 @@ func initInfo(conf *config.AgentConfig) error {
 		expvar.Publish("version", expvar.Func(publishVersion))
 		expvar.Publish("receiver", expvar.Func(publishReceiverStats))
 		expvar.Publish("endpoint", expvar.Func(publishEndpointStats))
+		expvar.Publish("endpoints", expvar.Func(publishEndpointsStats))
 		expvar.Publish("sampler", expvar.Func(publishSamplerInfo))
 		expvar.Publish("prioritysampler", expvar.Func(publishPrioritySamplerInfo))
 		expvar.Publish("ratebyservice", expvar.Func(publishRateByService))
@@ -280,13 +315,16 @@ type StatusInfo struct {
 	MemStats struct {
 		Alloc uint64
 	} `json:"memstats"`
-	Version       infoVersion             `json:"version"`
-	Receiver      []tagStats              `json:"receiver"`
-	RateByService map[string]float64      `json:"ratebyservice"`
-	Endpoint      endpointStats           `json:"endpoint"`
-	Watchdog      watchdog.Info           `json:"watchdog"`
-	PreSampler    sampler.PreSamplerStats `json:"presampler"`
-	Config        config.AgentConfig      `json:"config"`
+	Version         infoVersion              `json:"version"`
+	Receiver        []tagStats               `json:"receiver"`
+	RateByService   map[string]float64       `json:"ratebyservice"`
+	Sampler         samplerInfo              `json:"sampler"`
+	PrioritySampler samplerInfo              `json:"prioritysampler"`
+	Endpoint        endpointStats            `json:"endpoint"`
+	Endpoints       map[string]endpointStats `json:"endpoints"`
+	Watchdog        watchdog.Info            `json:"watchdog"`
+	PreSampler      sampler.PreSamplerStats  `json:"presampler"`
+	Config          config.AgentConfig       `json:"config"`
 }
 
 func getProgramBanner(version string) (string, string) {
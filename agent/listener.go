@@ -9,22 +9,29 @@ import (
 	log "github.com/cihub/seelog"
 )
 
-// StoppableListener wraps a regular TCPListener with an exit channel so we can exit cleanly from the Serve() loop of our HTTP server
+// deadlineListener is a net.Listener on which Accept deadlines can be set,
+// such as *net.TCPListener and *net.UnixListener.
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+// StoppableListener wraps a regular TCP or Unix listener with an exit channel so we can exit cleanly from the Serve() loop of our HTTP server
 type StoppableListener struct {
 	exit      chan struct{}
 	connLease int32 // How many connections are available for this listener before rate-limiting kicks in
-	*net.TCPListener
+	deadlineListener
 }
 
 // NewStoppableListener returns a new wrapped listener, which is non-initialized
 func NewStoppableListener(l net.Listener, exit chan struct{}, conns int) (*StoppableListener, error) {
-	tcpL, ok := l.(*net.TCPListener)
+	dl, ok := l.(deadlineListener)
 
 	if !ok {
 		return nil, errors.New("cannot wrap listener")
 	}
 
-	sl := &StoppableListener{exit: exit, connLease: int32(conns), TCPListener: tcpL}
+	sl := &StoppableListener{exit: exit, connLease: int32(conns), deadlineListener: dl}
 
 	return sl, nil
 }
@@ -61,13 +68,13 @@ func (sl *StoppableListener) Accept() (net.Conn, error) {
 		//Wait up to 1 second for Reads and Writes to the new connection
 		sl.SetDeadline(time.Now().Add(time.Second))
 
-		newConn, err := sl.TCPListener.Accept()
+		newConn, err := sl.deadlineListener.Accept()
 
 		//Check for the channel being closed
 		select {
 		case <-sl.exit:
 			log.Debug("stopping listener")
-			sl.TCPListener.Close()
+			sl.deadlineListener.Close()
 			return nil, errors.New("listener stopped")
 		default:
 			//If the channel is still open, continue as normal
@@ -16,6 +16,7 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
 	"github.com/DataDog/datadog-trace-agent/statsd"
 	"github.com/DataDog/datadog-trace-agent/watchdog"
 )
@@ -56,6 +57,7 @@ var opts struct {
 	logLevel     string
 	version      bool
 	info         bool
+	replay       string
 	cpuprofile   string
 	memprofile   string
 }
@@ -155,6 +157,7 @@ func runAgent(exit chan struct{}) {
 	if err != nil {
 		die("%v", err)
 	}
+	model.GlobalAgentPayloadVersion = agentConf.APIPayloadVersion
 
 	err = initInfo(agentConf) // for expvar & -info option
 	if err != nil {
@@ -169,6 +172,13 @@ func runAgent(exit chan struct{}) {
 		return
 	}
 
+	if opts.replay != "" {
+		if err := replayFiles(opts.replay, agentConf); err != nil {
+			die("%v", err)
+		}
+		return
+	}
+
 	// Exit if tracing is not enabled
 	if !agentConf.Enabled {
 		log.Info(agentDisabledMessage)
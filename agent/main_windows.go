@@ -38,6 +38,7 @@ func init() {
 	flag.StringVar(&opts.configFile, "config", "c:\\programdata\\datadog\\trace-agent.ini", "Trace agent ini config file.")
 	flag.BoolVar(&opts.version, "version", false, "Show version information and exit")
 	flag.BoolVar(&opts.info, "info", false, "Show info about running trace agent process and exit")
+	flag.StringVar(&opts.replay, "replay", "", "Send the payloads written to `file` or directory by the file output to the API and exit")
 
 	// profiling arguments
 	flag.StringVar(&opts.cpuprofile, "cpuprofile", "", "Write cpu profile to file")
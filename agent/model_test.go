@@ -8,6 +8,7 @@ import (
 
 	"github.com/DataDog/datadog-trace-agent/fixtures"
 	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -30,3 +31,39 @@ func BenchmarkHandleSpanRandom(b *testing.B) {
 		}
 	}
 }
+
+func TestAgentPayloadRoundTrip(t *testing.T) {
+	defer func(v model.AgentPayloadVersion) { model.GlobalAgentPayloadVersion = v }(model.GlobalAgentPayloadVersion)
+
+	payload := model.AgentPayload{
+		HostName: "test.host",
+		Env:      "test",
+		Traces:   []model.Trace{fixtures.RandomTrace(10, 8), fixtures.RandomTrace(10, 8)},
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket(), fixtures.RandomStatsBucket(20)},
+	}
+
+	for _, v := range []model.AgentPayloadVersion{model.AgentPayloadV01, model.AgentPayloadV02} {
+		model.GlobalAgentPayloadVersion = v
+
+		data, err := model.EncodeAgentPayload(&payload)
+		assert.Nil(t, err, string(v))
+
+		var decoded model.AgentPayload
+		assert.Nil(t, model.DecodeAgentPayload(data, &decoded), string(v))
+		// msgpack does not tell empty maps from nil ones
+		for _, trace := range decoded.Traces {
+			for i := range trace {
+				if trace[i].Meta == nil {
+					trace[i].Meta = map[string]string{}
+				}
+				if trace[i].Metrics == nil {
+					trace[i].Metrics = map[string]float64{}
+				}
+			}
+		}
+		assert.Equal(t, payload.HostName, decoded.HostName, string(v))
+		assert.Equal(t, payload.Env, decoded.Env, string(v))
+		assert.Equal(t, payload.Traces, decoded.Traces, string(v))
+		assert.Equal(t, payload.Stats, decoded.Stats, string(v))
+	}
+}
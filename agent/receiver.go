@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"sort"
 	"strings"
 	"sync/atomic"
@@ -59,8 +61,10 @@ type HTTPReceiver struct {
 
 	stats      *receiverStats
 	preSampler *sampler.PreSampler
+	explainer  *sampler.Explainer // records the sampling decisions, nil if disabled
 
-	exit chan struct{}
+	exit    chan struct{}
+	servers []*http.Server // one per listener, to shut them down on exit
 
 	maxRequestBodyLength int64
 	debug                bool
@@ -99,6 +103,16 @@ func (r *HTTPReceiver) Run() {
 	http.HandleFunc("/v0.4/traces", r.httpHandleWithVersion(v04, r.handleTraces))
 	http.HandleFunc("/v0.4/services", r.httpHandleWithVersion(v04, r.handleServices))
 
+	// OpenTelemetry OTLP/HTTP exporters
+	http.HandleFunc(otlpTracesPath, r.httpHandle(r.handleOTLPTraces))
+
+	// Zipkin reporters
+	http.HandleFunc(zipkinV1SpansPath, r.httpHandle(r.handleZipkinSpans("v1")))
+	http.HandleFunc(zipkinV2SpansPath, r.httpHandle(r.handleZipkinSpans("v2")))
+
+	// Sampling decisions
+	http.HandleFunc(samplerDebugPath, r.handleSamplerDebug)
+
 	// expvar implicitely publishes "/debug/vars" on the same port
 
 	addr := fmt.Sprintf("%s:%d", r.conf.ReceiverHost, r.conf.ReceiverPort)
@@ -106,6 +120,12 @@ func (r *HTTPReceiver) Run() {
 		die("%v", err)
 	}
 
+	if r.conf.ReceiverSocket != "" {
+		if err := r.ListenUnix(r.conf.ReceiverSocket, r.conf.ReceiverSocketPerm); err != nil {
+			die("%v", err)
+		}
+	}
+
 	go func() {
 		r.preSampler.Run()
 	}()
@@ -123,23 +143,58 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 		return fmt.Errorf("cannot listen on %s: %v", addr, err)
 	}
 
+	log.Infof("listening for traces at http://%s%s", addr, logExtra)
+
+	return r.serve(listener)
+}
+
+// ListenUnix creates a new HTTP server listening on a unix domain socket
+// at the provided path. A stale socket left over by a previous run is
+// removed first, and the socket file permissions are set to perm.
+func (r *HTTPReceiver) ListenUnix(path string, perm os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil {
+		// only ever remove sockets, we don't want to wipe a regular
+		// file because of a configuration mistake
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("cannot remove stale socket %s: %v", path, err)
+		}
+		log.Debugf("removed stale socket %s", path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", path, err)
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		listener.Close()
+		return fmt.Errorf("cannot set permissions on %s: %v", path, err)
+	}
+
+	log.Infof("listening for traces at unix://%s", path)
+
+	return r.serve(listener)
+}
+
+// serve starts an HTTP server on the given listener, wrapped in a
+// StoppableListener to apply connection limits and handle exit.
+func (r *HTTPReceiver) serve(listener net.Listener) error {
 	stoppableListener, err := NewStoppableListener(listener, r.exit,
 		r.conf.ConnectionLimit)
 	if err != nil {
 		return fmt.Errorf("cannot create stoppable listener: %v", err)
 	}
 
-	timeout := 5 * time.Second
-	if r.conf.ReceiverTimeout > 0 {
-		timeout = time.Duration(r.conf.ReceiverTimeout) * time.Second
-	}
+	timeout := r.requestTimeout()
 
-	server := http.Server{
-		ReadTimeout:  time.Second * time.Duration(timeout),
-		WriteTimeout: time.Second * time.Duration(timeout),
+	server := &http.Server{
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
 	}
-
-	log.Infof("listening for traces at http://%s%s", addr, logExtra)
+	r.servers = append(r.servers, server)
 
 	go func() {
 		defer watchdog.LogOnPanic()
@@ -153,6 +208,31 @@ func (r *HTTPReceiver) Listen(addr, logExtra string) error {
 	return nil
 }
 
+// requestTimeout returns the maximum duration of a request
+func (r *HTTPReceiver) requestTimeout() time.Duration {
+	if r.conf.ReceiverTimeout > 0 {
+		return time.Duration(r.conf.ReceiverTimeout) * time.Second
+	}
+	return 5 * time.Second
+}
+
+// Stop stops accepting new requests and waits for the ones being handled to
+// complete, so that no received trace is lost.
+func (r *HTTPReceiver) Stop() error {
+	close(r.exit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.requestTimeout())
+	defer cancel()
+
+	var err error
+	for _, server := range r.servers {
+		if serr := server.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
 func (r *HTTPReceiver) httpHandle(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		req.Body = model.NewLimitedReader(req.Body, r.maxRequestBodyLength)
@@ -222,6 +302,12 @@ func (r *HTTPReceiver) handleTraces(v APIVersion, w http.ResponseWriter, req *ht
 		atomic.AddInt64(&ts.TracesBytes, int64(bytesRead))
 	}
 
+	r.processTraces(ts, traces)
+}
+
+// processTraces normalizes the given traces and queues them for processing,
+// updating the given tagStats along the way.
+func (r *HTTPReceiver) processTraces(ts *tagStats, traces model.Traces) {
 	// normalize data
 	for i := range traces {
 		spans := len(traces[i])
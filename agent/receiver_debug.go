@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/sampler"
+)
+
+// samplerDebugPath is the path serving the recent sampling decisions
+const samplerDebugPath = "/debug/sampler"
+
+// samplerDebugSignatures sums up the recent decisions by signature
+type samplerDebugSignatures struct {
+	WindowSeconds float64                 `json:"window_seconds"`
+	Signatures    []sampler.SignatureInfo `json:"signatures"`
+}
+
+// samplerDebugTrace holds the recent decisions taken for a trace
+type samplerDebugTrace struct {
+	TraceID   uint64             `json:"trace_id"`
+	Decisions []sampler.Decision `json:"decisions"`
+}
+
+// handleSamplerDebug serves the sums of the recent sampling decisions by
+// signature, or, given a trace_id parameter, the decisions taken for that
+// trace and the reasons for them.
+func (r *HTTPReceiver) handleSamplerDebug(w http.ResponseWriter, req *http.Request) {
+	if r.explainer == nil {
+		http.Error(w, "sampler debugging is disabled, see debug_decisions in [trace.sampler]", http.StatusNotFound)
+		return
+	}
+
+	var response interface{}
+	if id := req.URL.Query().Get("trace_id"); id != "" {
+		traceID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid trace_id %q", id), http.StatusBadRequest)
+			return
+		}
+		decisions := r.explainer.Lookup(traceID)
+		if len(decisions) == 0 {
+			http.Error(w, fmt.Sprintf("no recent decision for trace %d", traceID), http.StatusNotFound)
+			return
+		}
+		response = samplerDebugTrace{TraceID: traceID, Decisions: decisions}
+	} else {
+		response = samplerDebugSignatures{
+			WindowSeconds: r.conf.SamplerDebugWindow.Seconds(),
+			Signatures:    r.explainer.Signatures(),
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("cannot encode the sampler decisions: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiverSamplerDebug(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	conf.SamplerDebugDecisions = 10
+	agent := NewAgent(conf, make(chan struct{}))
+	server := httptest.NewServer(http.HandlerFunc(agent.Receiver.handleSamplerDebug))
+	defer server.Close()
+
+	trace := model.Trace{fixtures.TestSpan()}
+	trace[0].ParentID = 0
+	agent.ScoreEngine.Add(processedTrace{Trace: trace, Root: &trace[0], Env: "test"})
+
+	resp, err := http.Get(server.URL + samplerDebugPath)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var signatures samplerDebugSignatures
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&signatures))
+	resp.Body.Close()
+	assert.Equal(conf.SamplerDebugWindow.Seconds(), signatures.WindowSeconds)
+	assert.Len(signatures.Signatures, 1)
+	assert.Equal(trace[0].Service, signatures.Signatures[0].Service)
+	assert.Equal(int64(1), signatures.Signatures[0].Seen)
+
+	resp, err = http.Get(fmt.Sprintf("%s%s?trace_id=%d", server.URL, samplerDebugPath, trace[0].TraceID))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var decisions samplerDebugTrace
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&decisions))
+	resp.Body.Close()
+	assert.Equal(trace[0].TraceID, decisions.TraceID)
+	assert.Len(decisions.Decisions, 1)
+	assert.Equal("score", decisions.Decisions[0].Engine)
+	assert.NotEmpty(decisions.Decisions[0].Reason)
+
+	for query, status := range map[string]int{
+		"?trace_id=abc": http.StatusBadRequest,
+		fmt.Sprintf("?trace_id=%d", trace[0].TraceID+1): http.StatusNotFound,
+	} {
+		resp, err = http.Get(server.URL + samplerDebugPath + query)
+		assert.Nil(err)
+		assert.Equal(status, resp.StatusCode, query)
+		resp.Body.Close()
+	}
+}
+
+func TestReceiverSamplerDebugDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	receiver := NewHTTPReceiver(config.NewDefaultAgentConfig(), config.NewDynamicConfig())
+	server := httptest.NewServer(http.HandlerFunc(receiver.handleSamplerDebug))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + samplerDebugPath)
+	assert.Nil(err)
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+const (
+	// otlpTracesPath is the path OpenTelemetry OTLP/HTTP exporters send traces to
+	otlpTracesPath = "/v1/traces"
+
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// handleOTLPTraces handles an OTLP/HTTP export request, either protobuf or
+// JSON encoded, and feeds its spans to the same pipeline as native traces.
+func (r *HTTPReceiver) handleOTLPTraces(w http.ResponseWriter, req *http.Request) {
+	errTags := []string{tagTraceHandler, "v:otlp"}
+
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (contentType != contentTypeProtobuf && contentType != contentTypeJSON) {
+		log.Errorf("rejecting OTLP client request, unsupported media type %q", req.Header.Get("Content-Type"))
+		HTTPFormatError(errTags, w)
+		return
+	}
+
+	if !r.preSampler.Sample(req) {
+		replyOTLP(w, contentType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		log.Errorf("cannot read OTLP traces payload: %v", err)
+		HTTPDecodingError(err, errTags, w)
+		return
+	}
+
+	var export model.OTLPExportRequest
+	if contentType == contentTypeProtobuf {
+		err = export.UnmarshalProto(body)
+	} else {
+		err = json.Unmarshal(body, &export)
+	}
+	if err != nil {
+		log.Errorf("cannot decode OTLP traces payload: %v", err)
+		HTTPDecodingError(err, errTags, w)
+		return
+	}
+
+	// We successfuly decoded the payload
+	replyOTLP(w, contentType)
+
+	// OpenTelemetry SDKs describe themselves through resource attributes
+	// instead of the Datadog-Meta-* headers.
+	tags := Tags{
+		Lang:          export.ResourceAttribute("telemetry.sdk.language"),
+		TracerVersion: export.ResourceAttribute("telemetry.sdk.version"),
+	}
+	ts := r.stats.getTagStats(tags)
+
+	bytesRead := req.Body.(*model.LimitedReader).Count
+	if bytesRead > 0 {
+		atomic.AddInt64(&ts.TracesBytes, int64(bytesRead))
+	}
+
+	r.processTraces(ts, export.Traces())
+}
+
+// replyOTLP acknowledges an export request with an empty
+// ExportTraceServiceResponse in the encoding of the request.
+func replyOTLP(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == contentTypeJSON {
+		io.WriteString(w, "{}")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiverOTLPJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+	server := httptest.NewServer(http.HandlerFunc(receiver.httpHandle(receiver.handleOTLPTraces)))
+	defer server.Close()
+
+	start := time.Now().Add(-time.Second).UnixNano()
+	body := fmt.Sprintf(`{"resourceSpans": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "checkout"}},
+			{"key": "telemetry.sdk.language", "value": {"stringValue": "java"}}
+		]},
+		"scopeSpans": [{"spans": [{
+			"traceId": "5b8efff798038103d269b633813fc60c",
+			"spanId": "eee19b7ec3c1b174",
+			"name": "process",
+			"startTimeUnixNano": "%d",
+			"endTimeUnixNano": "%d"
+		}]}]
+	}]}`, start, start+1000)
+
+	resp, err := http.Post(server.URL+otlpTracesPath, "application/json; charset=utf-8", bytes.NewBufferString(body))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal("{}", string(respBody))
+
+	select {
+	case trace := <-receiver.traces:
+		assert.Len(trace, 1)
+		assert.Equal("checkout", trace[0].Service)
+		assert.Equal("otlp.unspecified", trace[0].Name)
+		assert.Equal("process", trace[0].Resource)
+		assert.Equal(uint64(0xd269b633813fc60c), trace[0].TraceID)
+	case <-time.After(time.Second):
+		t.Fatal("no trace received")
+	}
+
+	ts, ok := receiver.stats.Stats[Tags{Lang: "java"}]
+	assert.True(ok)
+	assert.Equal(int64(1), ts.TracesReceived)
+	assert.Equal(int64(len(body)), ts.TracesBytes)
+}
+
+func TestReceiverOTLPErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+	server := httptest.NewServer(http.HandlerFunc(receiver.httpHandle(receiver.handleOTLPTraces)))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/msgpack", "{}", http.StatusUnsupportedMediaType},
+		{"application/json", "{not json", http.StatusBadRequest},
+		{"application/x-protobuf", "\x0a\xff", http.StatusBadRequest},
+		{"application/x-protobuf", "", http.StatusOK},
+	} {
+		resp, err := http.Post(server.URL+otlpTracesPath, tc.contentType, bytes.NewBufferString(tc.body))
+		assert.Nil(err)
+		assert.Equal(tc.status, resp.StatusCode, tc.contentType)
+		resp.Body.Close()
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -86,6 +89,108 @@ func TestReceiverRequestBodyLength(t *testing.T) {
 	testBody(http.StatusRequestEntityTooLarge, " []")
 }
 
+func TestReceiverUnixSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-socket")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "apm.socket")
+
+	// leave a stale socket behind, as a crashed agent would
+	stale, err := net.Listen("unix", path)
+	assert.Nil(err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	dynConf := config.NewDynamicConfig()
+
+	// save the global mux aside, we don't want to break other tests
+	defaultMux := http.DefaultServeMux
+	http.DefaultServeMux = http.NewServeMux()
+
+	receiver := NewHTTPReceiver(conf, dynConf)
+	http.HandleFunc("/v0.4/traces", receiver.httpHandleWithVersion(v04, receiver.handleTraces))
+	assert.Nil(receiver.ListenUnix(path, 0700))
+
+	defer func() {
+		close(receiver.exit)
+		time.Sleep(2 * time.Second)
+		http.DefaultServeMux = defaultMux
+	}()
+
+	fi, err := os.Stat(path)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0700), fi.Mode()&os.ModePerm)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+	}
+
+	data, err := json.Marshal(model.Traces{model.Trace{fixtures.GetTestSpan()}})
+	assert.Nil(err)
+	req, err := http.NewRequest("POST", "http://unix/v0.4/traces", bytes.NewBuffer(data))
+	assert.Nil(err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	select {
+	case rt := <-receiver.traces:
+		assert.Len(rt, 1)
+		assert.Equal(uint64(42), rt[0].TraceID)
+	case <-time.After(time.Second):
+		t.Fatalf("no data received")
+	}
+}
+
+func TestReceiverUnixSocketNotASocket(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := ioutil.TempFile("", "trace-agent-socket")
+	assert.Nil(err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	conf := config.NewDefaultAgentConfig()
+	receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+
+	// a regular file must never be removed to make room for the socket
+	assert.NotNil(receiver.ListenUnix(f.Name(), 0700))
+	_, err = os.Stat(f.Name())
+	assert.Nil(err)
+}
+
+func TestReceiverTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	for receiverTimeout, expected := range map[int]time.Duration{
+		0:  5 * time.Second,
+		30: 30 * time.Second,
+	} {
+		conf := config.NewDefaultAgentConfig()
+		conf.ReceiverTimeout = receiverTimeout
+		receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+
+		listener, err := net.Listen("tcp", "localhost:0")
+		assert.Nil(err)
+		assert.Nil(receiver.serve(listener))
+		assert.Len(receiver.servers, 1)
+		assert.Equal(expected, receiver.servers[0].ReadTimeout)
+		assert.Equal(expected, receiver.servers[0].WriteTimeout)
+		assert.Nil(receiver.Stop())
+	}
+}
+
 func TestLegacyReceiver(t *testing.T) {
 	// testing traces without content-type in agent endpoints, it should use JSON decoding
 	assert := assert.New(t)
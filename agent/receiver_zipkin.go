@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+const (
+	// zipkinV1SpansPath and zipkinV2SpansPath are the paths Zipkin
+	// reporters send spans to
+	zipkinV1SpansPath = "/api/v1/spans"
+	zipkinV2SpansPath = "/api/v2/spans"
+)
+
+// handleZipkinSpans handles a Zipkin JSON payload, of the given version
+// ("v1" or "v2"), and feeds its spans to the same pipeline as native traces.
+func (r *HTTPReceiver) handleZipkinSpans(v string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		errTags := []string{tagTraceHandler, fmt.Sprintf("v:zipkin-%s", v)}
+
+		if ct := req.Header.Get("Content-Type"); ct != "" {
+			// Zipkin reporters can also send thrift or protobuf, we only
+			// support JSON
+			if contentType, _, err := mime.ParseMediaType(ct); err != nil || contentType != contentTypeJSON {
+				log.Errorf("rejecting zipkin client request, unsupported media type %q", ct)
+				HTTPFormatError(errTags, w)
+				return
+			}
+		}
+
+		if !r.preSampler.Sample(req) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		var spans model.ZipkinSpans
+		var err error
+		if v == "v1" {
+			var v1Spans model.ZipkinV1Spans
+			err = json.NewDecoder(req.Body).Decode(&v1Spans)
+			spans = v1Spans.ZipkinSpans()
+		} else {
+			err = json.NewDecoder(req.Body).Decode(&spans)
+		}
+		if err != nil {
+			log.Errorf("cannot decode zipkin %s spans payload: %v", v, err)
+			HTTPDecodingError(err, errTags, w)
+			return
+		}
+
+		// Zipkin collectors answer with 202 Accepted
+		w.WriteHeader(http.StatusAccepted)
+
+		tags := Tags{
+			req.Header.Get("Datadog-Meta-Lang"),
+			req.Header.Get("Datadog-Meta-Lang-Version"),
+			req.Header.Get("Datadog-Meta-Lang-Interpreter"),
+			req.Header.Get("Datadog-Meta-Tracer-Version"),
+		}
+		ts := r.stats.getTagStats(tags)
+
+		bytesRead := req.Body.(*model.LimitedReader).Count
+		if bytesRead > 0 {
+			atomic.AddInt64(&ts.TracesBytes, int64(bytesRead))
+		}
+
+		traces, errs := spans.Traces()
+		if len(errs) > 0 {
+			// those spans can't be attached to any trace
+			atomic.AddInt64(&ts.SpansReceived, int64(len(errs)))
+			atomic.AddInt64(&ts.SpansDropped, int64(len(errs)))
+			log.Errorf("dropping %d zipkin spans, first reason: %v", len(errs), errs[0])
+		}
+
+		r.processTraces(ts, traces)
+	}
+}
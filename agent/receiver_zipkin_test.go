@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiverZipkinV2(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+	server := httptest.NewServer(http.HandlerFunc(receiver.httpHandle(receiver.handleZipkinSpans("v2"))))
+	defer server.Close()
+
+	start := time.Now().Add(-time.Second).UnixNano() / 1000
+	body := fmt.Sprintf(`[
+		{"traceId": "1", "id": "1", "name": "get", "timestamp": %d, "duration": 100,
+		 "localEndpoint": {"serviceName": "frontend"}},
+		{"traceId": "1", "id": "2", "parentId": "1", "name": "query", "timestamp": %d, "duration": 10,
+		 "localEndpoint": {"serviceName": "frontend"}},
+		{"traceId": "1", "id": "not-an-id", "name": "broken", "timestamp": %d, "duration": 10}
+	]`, start, start+10, start+20)
+
+	resp, err := http.Post(server.URL+zipkinV2SpansPath, "application/json", bytes.NewBufferString(body))
+	assert.Nil(err)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	resp.Body.Close()
+
+	select {
+	case trace := <-receiver.traces:
+		assert.Len(trace, 2)
+		assert.Equal("frontend", trace[0].Service)
+		assert.Equal(uint64(1), trace[0].TraceID)
+	case <-time.After(time.Second):
+		t.Fatal("no trace received")
+	}
+
+	ts := receiver.stats.getTagStats(Tags{})
+	assert.Equal(int64(1), ts.TracesReceived)
+	assert.Equal(int64(3), ts.SpansReceived)
+	assert.Equal(int64(1), ts.SpansDropped)
+}
+
+func TestReceiverZipkinErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.NewDefaultAgentConfig()
+	receiver := NewHTTPReceiver(conf, config.NewDynamicConfig())
+
+	for _, tc := range []struct {
+		version     string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"v2", "application/x-thrift", "[]", http.StatusUnsupportedMediaType},
+		{"v2", "application/json", "{not json", http.StatusBadRequest},
+		{"v1", "application/json", `[{"annotations": 42}]`, http.StatusBadRequest},
+		{"v1", "", "[]", http.StatusAccepted},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(receiver.httpHandle(receiver.handleZipkinSpans(tc.version))))
+		req, err := http.NewRequest("POST", server.URL, bytes.NewBufferString(tc.body))
+		assert.Nil(err)
+		req.Header.Set("Content-Type", tc.contentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(err)
+		assert.Equal(tc.status, resp.StatusCode, tc.body)
+		resp.Body.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+const (
+	// fileReplayedExt is appended to the name of the files fully replayed,
+	// so that they are not replayed again
+	fileReplayedExt = ".replayed"
+	// fileProgressExt is appended to the name of a file partially replayed
+	// to name the file holding its replayProgress
+	fileProgressExt = ".progress"
+)
+
+// replayProgress is the number of records of a file each endpoint received,
+// by endpointID
+type replayProgress map[string]int
+
+// endpointID identifies an endpoint across runs, without saving its API key
+func endpointID(e *APIEndpoint) string {
+	h := fnv.New32a()
+	h.Write([]byte(e.apiKey))
+	return fmt.Sprintf("%s#%08x", e.url, h.Sum32())
+}
+
+// loadReplayProgress returns the progress saved for file, empty if there is none
+func loadReplayProgress(file string) (replayProgress, error) {
+	progress := make(replayProgress)
+	data, err := ioutil.ReadFile(file + fileProgressExt)
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("corrupt progress file: %v", err)
+	}
+	return progress, nil
+}
+
+// saveReplayProgress saves the progress of file, replacing the previous one atomically
+func saveReplayProgress(file string, progress replayProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	tmp := file + fileProgressExt + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file+fileProgressExt)
+}
+
+// replayFiles sends the payloads and services updates found in the files
+// written by a FileEndpoint to the API endpoints of conf. path is either
+// one of these files or the directory holding them, in which case all of
+// them are replayed, oldest first.
+//
+// Replaying is idempotent: files fully sent are renamed with the .replayed
+// extension, and when an endpoint fails, the number of records each endpoint
+// received is saved next to the file, so that the next replay resumes where
+// each of them stopped.
+func replayFiles(path string, conf *config.AgentConfig) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if fi.IsDir() {
+		if files, err = listOutputFiles(path); err != nil {
+			return err
+		}
+	} else if strings.HasSuffix(path, fileReplayedExt) {
+		log.Infof("%s was already replayed", path)
+		return nil
+	}
+
+	var endpoints []*APIEndpoint
+	urls, keys := conf.APIEndpointKeys()
+	for i := range urls {
+		endpoint := NewAPIEndpoint(urls[i], keys[i])
+		if conf.Proxy != nil {
+			endpoint.SetProxy(conf.Proxy)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	// payloads are sent with the version they were written with
+	defer func(v model.AgentPayloadVersion) { model.GlobalAgentPayloadVersion = v }(model.GlobalAgentPayloadVersion)
+
+	var failed []string
+	for _, file := range files {
+		nbPayloads, nbServices, err := replayFile(file, endpoints)
+		log.Infof("replayed %d payloads and %d services updates from %s", nbPayloads, nbServices, file)
+		if err != nil {
+			log.Errorf("cannot replay %s: %v", file, err)
+			failed = append(failed, file)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot replay %d files, replay them again to resume: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// replayFile sends the records of file to endpoints, skipping those each
+// endpoint received in a previous replay, and returns how many payloads and
+// services updates were read. An endpoint failing does not receive the
+// following records, but the others do.
+func replayFile(file string, endpoints []*APIEndpoint) (nbPayloads, nbServices int, err error) {
+	progress, err := loadReplayProgress(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	format := FileFormatWire
+	if filepath.Ext(file) == "."+FileFormatNDJSON {
+		format = FileFormatNDJSON
+	}
+	reader := newFileRecordReader(f, format)
+	defaultVersion := model.GlobalAgentPayloadVersion
+
+	// the errors of the endpoints which failed, by endpointID
+	failed := make(map[string]error)
+
+	for n := 0; ; n++ {
+		r, err := reader.Next()
+		if err == errTruncatedRecord {
+			// the agent stopped while writing this one
+			log.Warnf("ignoring truncated record at the end of %s", file)
+			err = io.EOF
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// keep what was sent so far
+			if serr := saveReplayProgress(file, progress); serr != nil {
+				log.Errorf("cannot save the progress of %s: %v", file, serr)
+			}
+			return nbPayloads, nbServices, err
+		}
+
+		model.GlobalAgentPayloadVersion = defaultVersion
+		if r.Version != "" {
+			model.GlobalAgentPayloadVersion = r.Version
+		}
+		if r.Type == fileRecordPayload {
+			for k, v := range r.Extras {
+				r.Payload.SetExtra(k, v)
+			}
+		}
+
+		for _, e := range endpoints {
+			id := endpointID(e)
+			if failed[id] != nil || progress[id] > n {
+				continue
+			}
+
+			switch r.Type {
+			case fileRecordPayload:
+				if _, err := e.Write(*r.Payload); err != nil {
+					if _, ok := err.(*apiError); ok {
+						failed[id] = err
+						continue
+					}
+					// the API will never accept it, move on
+					log.Errorf("dropping payload written at %d: %v", r.Time, err)
+				}
+			case fileRecordServices:
+				e.WriteServices(r.Services)
+			}
+			progress[id] = n + 1
+		}
+
+		switch r.Type {
+		case fileRecordPayload:
+			nbPayloads++
+		case fileRecordServices:
+			nbServices++
+		default:
+			log.Warnf("ignoring record of unknown type %q in %s", r.Type, file)
+		}
+	}
+
+	if len(failed) > 0 {
+		if err := saveReplayProgress(file, progress); err != nil {
+			log.Errorf("cannot save the progress of %s: %v", file, err)
+		}
+		var errs []string
+		for id, err := range failed {
+			errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+		}
+		return nbPayloads, nbServices, fmt.Errorf("%d endpoints failed: %s", len(failed), strings.Join(errs, ", "))
+	}
+
+	if err := os.Rename(file, file+fileReplayedExt); err != nil {
+		return nbPayloads, nbServices, err
+	}
+	if err := os.Remove(file + fileProgressExt); err != nil && !os.IsNotExist(err) {
+		log.Errorf("cannot remove the progress of %s: %v", file, err)
+	}
+	return nbPayloads, nbServices, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// retryJitter returns a random number in [0.0,1.0), replaced in tests
+var retryJitter = rand.Float64
+
+// retryPolicy decides when an endpoint which failed to accept payloads can
+// be tried again. Consecutive failures increase the delay exponentially,
+// with jitter so that agents do not all come back at once. After
+// threshold consecutive failures the circuit opens: nothing is sent to the
+// endpoint until the delay expires, and then a single payload probes it.
+// A threshold of 0 disables the circuit breaker.
+type retryPolicy struct {
+	base      time.Duration // delay after the first failure
+	max       time.Duration // upper bound of the delay
+	threshold int           // consecutive failures opening the circuit
+
+	failures  int       // number of consecutive failures
+	nextRetry time.Time // the earliest moment we can try again
+}
+
+func newRetryPolicy(base, max time.Duration, threshold int) *retryPolicy {
+	return &retryPolicy{base: base, max: max, threshold: threshold}
+}
+
+// CircuitOpen tells if the endpoint failed too many times in a row to be
+// sent anything but probes.
+func (rp *retryPolicy) CircuitOpen() bool {
+	return rp.threshold > 0 && rp.failures >= rp.threshold
+}
+
+// CanSend tells if a payload can be sent at now. Retries always wait for
+// the delay to expire, new payloads only wait if the circuit is open.
+func (rp *retryPolicy) CanSend(now time.Time, retry bool) bool {
+	if retry || rp.CircuitOpen() {
+		return !rp.nextRetry.After(now)
+	}
+	return true
+}
+
+// Failure records a failure at now and returns the delay before the next
+// retry. A non-zero retryAfter, as asked by the endpoint, is honored if
+// larger than the backoff delay, up to the maximum delay.
+func (rp *retryPolicy) Failure(now time.Time, retryAfter time.Duration) time.Duration {
+	rp.failures++
+
+	delay := rp.base
+	for i := 1; i < rp.failures && delay < rp.max; i++ {
+		delay *= 2
+	}
+	if delay > rp.max {
+		delay = rp.max
+	}
+	// "equal jitter": wait at least half the delay
+	delay = delay/2 + time.Duration(retryJitter()*float64(delay/2))
+
+	if retryAfter > delay {
+		delay = retryAfter
+		if delay > rp.max {
+			// do not let a misbehaving endpoint or proxy stall us for long
+			delay = rp.max
+		}
+	}
+
+	rp.nextRetry = now.Add(delay)
+	return delay
+}
+
+// Success records a successful write. It returns true if it closed the
+// circuit.
+func (rp *retryPolicy) Success() bool {
+	wasOpen := rp.CircuitOpen()
+	rp.failures = 0
+	rp.nextRetry = time.Time{}
+	return wasOpen
+}
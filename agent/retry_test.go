@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withRetryJitter(v float64) func() {
+	old := retryJitter
+	retryJitter = func() float64 { return v }
+	return func() { retryJitter = old }
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	assert := assert.New(t)
+	defer withRetryJitter(0.5)()
+
+	now := time.Now()
+	rp := newRetryPolicy(time.Second, 10*time.Second, 0)
+
+	// delays double, jitter keeping them between half and the full delay
+	for _, expected := range []time.Duration{
+		750 * time.Millisecond,
+		1500 * time.Millisecond,
+		3 * time.Second,
+		6 * time.Second,
+		7500 * time.Millisecond, // capped at 10s
+		7500 * time.Millisecond,
+	} {
+		assert.Equal(expected, rp.Failure(now, 0))
+		assert.Equal(now.Add(expected), rp.nextRetry)
+	}
+	assert.False(rp.CircuitOpen(), "circuit breaker is disabled")
+
+	// retries wait, new payloads don't
+	assert.False(rp.CanSend(now, true))
+	assert.True(rp.CanSend(now, false))
+	assert.True(rp.CanSend(now.Add(10*time.Second), true))
+
+	assert.False(rp.Success())
+	assert.Equal(750*time.Millisecond, rp.Failure(now, 0))
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	assert := assert.New(t)
+	rp := newRetryPolicy(time.Second, time.Minute, 0)
+
+	defer withRetryJitter(0)()
+	assert.Equal(500*time.Millisecond, rp.Failure(time.Now(), 0))
+
+	rp.Success()
+	retryJitter = func() float64 { return 0.999999 }
+	delay := rp.Failure(time.Now(), 0)
+	assert.True(delay > 999*time.Millisecond && delay < time.Second)
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	defer withRetryJitter(0.5)()
+
+	rp := newRetryPolicy(time.Second, time.Minute, 0)
+	assert.Equal(30*time.Second, rp.Failure(time.Now(), 30*time.Second))
+	// a shorter Retry-After does not shorten the backoff
+	assert.Equal(1500*time.Millisecond, rp.Failure(time.Now(), time.Millisecond))
+	// a longer one than the maximum delay is capped
+	now := time.Now()
+	assert.Equal(time.Minute, rp.Failure(now, 24*time.Hour))
+	assert.Equal(now.Add(time.Minute), rp.nextRetry)
+}
+
+func TestRetryPolicyCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	defer withRetryJitter(0.5)()
+
+	now := time.Now()
+	rp := newRetryPolicy(time.Second, time.Minute, 3)
+
+	rp.Failure(now, 0)
+	rp.Failure(now, 0)
+	assert.False(rp.CircuitOpen())
+	assert.True(rp.CanSend(now, false))
+
+	delay := rp.Failure(now, 0)
+	assert.True(rp.CircuitOpen())
+	assert.False(rp.CanSend(now, false), "nothing is sent while the circuit is open")
+
+	// once the delay expired, a probe can go
+	assert.True(rp.CanSend(now.Add(delay), false))
+
+	// a failed probe keeps the circuit open, with a longer delay
+	assert.True(rp.Failure(now, 0) > delay)
+	assert.True(rp.CircuitOpen())
+
+	assert.True(rp.Success())
+	assert.False(rp.CircuitOpen())
+	assert.True(rp.CanSend(now, false))
+}
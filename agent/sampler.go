@@ -43,19 +43,29 @@ type samplerInfo struct {
 
 // NewScoreEngine creates a new empty sampler ready to be started
 func NewScoreEngine(conf *config.AgentConfig) *Sampler {
+	engine := sampler.NewScoreEngine(conf.ExtraSampleRate, conf.MaxTPS, conf.SamplingRules)
+	engine.Sampler.UpdateBoosts(conf.ErrorSampleBoost, conf.LatencySampleBoost)
+	if conf.RareSamplerWindow > 0 {
+		engine.EnableRareSampler(conf.RareSamplerWindow, conf.RareSamplerCapacity)
+	}
+	engine.EnableTPSBudgets(conf.TPSBudgets)
+
 	return &Sampler{
 		sampledTraces: []model.Trace{},
 		traceCount:    0,
-		engine:        sampler.NewScoreEngine(conf.ExtraSampleRate, conf.MaxTPS),
+		engine:        engine,
 	}
 }
 
 // NewPriorityEngine creates a new empty distributed sampler ready to be started
 func NewPriorityEngine(conf *config.AgentConfig, dynConf *config.DynamicConfig) *Sampler {
+	engine := sampler.NewPriorityEngine(conf.ExtraSampleRate, conf.MaxTPS, &dynConf.RateByService)
+	engine.EnableTPSBudgets(conf.TPSBudgets)
+
 	return &Sampler{
 		sampledTraces: []model.Trace{},
 		traceCount:    0,
-		engine:        sampler.NewPriorityEngine(conf.ExtraSampleRate, conf.MaxTPS, &dynConf.RateByService),
+		engine:        engine,
 	}
 }
 
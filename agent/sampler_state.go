@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/sampler"
+)
+
+// samplerState is what is stored in the sampler state file
+type samplerState struct {
+	// Time is when the state was saved
+	Time     time.Time
+	Score    *sampler.Snapshot `json:",omitempty"`
+	Priority *sampler.Snapshot `json:",omitempty"`
+}
+
+// saveSamplerState writes the state of the samplers to path. The file is
+// replaced atomically so that a crash never leaves a truncated state behind.
+func saveSamplerState(path string, score, priority *Sampler) error {
+	state := samplerState{Time: time.Now()}
+	if score != nil {
+		snap := score.engine.Snapshot()
+		state.Score = &snap
+	}
+	if priority != nil {
+		snap := priority.engine.Snapshot()
+		state.Priority = &snap
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(tmp).Encode(state)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// loadSamplerState restores the state of the samplers saved in path, decayed
+// for the time elapsed since. A state older than maxAge, or which cannot be
+// read, is ignored and the samplers start from scratch.
+func loadSamplerState(path string, maxAge time.Duration, score, priority *Sampler) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var state samplerState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return fmt.Errorf("corrupt sampler state %s: %v", path, err)
+	}
+
+	elapsed := time.Since(state.Time)
+	if elapsed < 0 || elapsed > maxAge {
+		return fmt.Errorf("ignoring sampler state %s saved at %s", path, state.Time)
+	}
+
+	if score != nil && state.Score != nil {
+		score.engine.Restore(*state.Score, elapsed)
+	}
+	if priority != nil && state.Priority != nil {
+		priority.engine.Restore(*state.Priority, elapsed)
+	}
+	log.Infof("restored sampler state saved %s ago", elapsed)
+	return nil
+}
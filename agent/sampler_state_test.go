@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/sampler"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSamplers() (*Sampler, *Sampler) {
+	conf := config.NewDefaultAgentConfig()
+	return NewScoreEngine(conf), NewPriorityEngine(conf, config.NewDynamicConfig())
+}
+
+func TestSamplerStateSaveLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sampler-state")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "sampler.json")
+
+	score, priority := newTestSamplers()
+	for i := 0; i < 100; i++ {
+		trace := fixtures.RandomTrace(3, 3)
+		root := trace.GetRoot()
+		score.Add(processedTrace{Trace: trace, Root: root, Env: "test"})
+
+		root.Metrics = map[string]float64{samplingPriorityKey: 1}
+		priority.Add(processedTrace{Trace: trace, Root: root, Env: "test"})
+	}
+	assert.NoError(saveSamplerState(path, score, priority))
+
+	restoredScore, restoredPriority := newTestSamplers()
+	assert.NoError(loadSamplerState(path, time.Minute, restoredScore, restoredPriority))
+	assert.Equal(score.engine.Snapshot(), restoredScore.engine.Snapshot())
+	assert.Equal(priority.engine.Snapshot(), restoredPriority.engine.Snapshot())
+
+	// no temporary file is left behind
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(err)
+	assert.Len(files, 1)
+}
+
+func TestSamplerStateIgnored(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sampler-state")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sampler.json")
+
+	score, priority := newTestSamplers()
+	empty := score.engine.Snapshot()
+
+	// a missing state is not an error
+	assert.NoError(loadSamplerState(path, time.Minute, score, priority))
+
+	// a corrupt state is ignored
+	assert.NoError(ioutil.WriteFile(path, []byte(`{"Time": "2017-`), 0600))
+	assert.Error(loadSamplerState(path, time.Minute, score, priority))
+	assert.Equal(empty, score.engine.Snapshot())
+
+	// so are stale states, and states from the future
+	for _, at := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+		state := samplerState{
+			Time:  at,
+			Score: &sampler.Snapshot{Scores: map[sampler.Signature]float64{42: 100}, TotalScore: 100},
+		}
+		buf, err := json.Marshal(state)
+		assert.NoError(err)
+		assert.NoError(ioutil.WriteFile(path, buf, 0600))
+		assert.Error(loadSamplerState(path, time.Minute, score, priority))
+		assert.Equal(empty, score.engine.Snapshot())
+	}
+}
+
+func TestSamplerStateAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "sampler-state")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIKey = "test"
+	conf.SamplerStateFile = filepath.Join(dir, "sampler.json")
+	agent := NewAgent(conf, make(chan struct{}))
+
+	trace := model.Trace{fixtures.TestSpan()}
+	trace[0].ParentID = 0
+	agent.ScoreEngine.Add(processedTrace{Trace: trace, Root: &trace[0], Env: "test"})
+	agent.saveSamplerState()
+
+	_, err = os.Stat(conf.SamplerStateFile)
+	assert.NoError(err)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+const (
+	// spoolFileExt is the extension of the files holding spooled payloads
+	spoolFileExt = ".payload"
+	// spoolTmpPrefix prefixes files being written to the spool
+	spoolTmpPrefix = "tmp-"
+)
+
+// spooledPayload is what is stored on disk for each spooled payload. The
+// payload extras are private to model.AgentPayload so they are kept aside.
+type spooledPayload struct {
+	Payload *model.AgentPayload `json:"payload"`
+	Extras  map[string]string   `json:"extras,omitempty"`
+}
+
+// spoolFile is a payload file sitting in the spool directory
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// payloadSpool keeps payloads which could not be sent to the API on disk,
+// so that they survive restarts and can be replayed in order once the
+// endpoint recovers. The spool is bounded by maxSize bytes, the oldest
+// payloads being evicted first.
+// It is not safe for concurrent use, the Writer owns it.
+type payloadSpool struct {
+	dir     string
+	maxSize int64
+
+	files []spoolFile // sorted, oldest first
+	size  int64       // total size of files
+	seq   uint64      // disambiguates files created within the same nanosecond
+}
+
+// newPayloadSpool returns a spool backed by dir, creating it if needed and
+// picking up the payloads left there by a previous run.
+func newPayloadSpool(dir string, maxSize int) (*payloadSpool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadDir sorts entries by name and file names start with a fixed-width
+	// timestamp, so files come oldest first
+	s := &payloadSpool{dir: dir, maxSize: int64(maxSize)}
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), spoolTmpPrefix) {
+			// leftover of a write interrupted by a crash
+			os.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), spoolFileExt) {
+			continue
+		}
+		s.files = append(s.files, spoolFile{name: fi.Name(), size: fi.Size()})
+		s.size += fi.Size()
+	}
+
+	if len(s.files) > 0 {
+		log.Infof("found %d spooled payloads (%d bytes) in %s", len(s.files), s.size, dir)
+	}
+	s.evict()
+	return s, nil
+}
+
+// Len returns the number of spooled payloads
+func (s *payloadSpool) Len() int {
+	return len(s.files)
+}
+
+// Size returns the total size in bytes of spooled payloads
+func (s *payloadSpool) Size() int64 {
+	return s.size
+}
+
+// Push writes p to the spool, evicting the oldest payloads if the spool
+// grows over its size budget.
+func (s *payloadSpool) Push(p *model.AgentPayload) error {
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1e6, spoolFileExt)
+
+	// write to a temporary file first so that a crash never leaves a
+	// truncated payload behind
+	tmp, err := ioutil.TempFile(s.dir, spoolTmpPrefix)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(tmp)
+	err = json.NewEncoder(gz).Encode(spooledPayload{Payload: p, Extras: p.Extras()})
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	fi, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, spoolFile{name: name, size: fi.Size()})
+	s.size += fi.Size()
+	s.evict()
+	return nil
+}
+
+// Peek reads the oldest spooled payload, without removing it.
+func (s *payloadSpool) Peek() (model.AgentPayload, error) {
+	var sp spooledPayload
+	if len(s.files) == 0 {
+		return model.AgentPayload{}, fmt.Errorf("spool is empty")
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, s.files[0].name))
+	if err != nil {
+		return model.AgentPayload{}, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return model.AgentPayload{}, err
+	}
+	defer gz.Close()
+
+	if err := json.NewDecoder(gz).Decode(&sp); err != nil {
+		return model.AgentPayload{}, err
+	}
+	if sp.Payload == nil {
+		return model.AgentPayload{}, fmt.Errorf("no payload in %s", s.files[0].name)
+	}
+
+	p := model.AgentPayload{
+		HostName: sp.Payload.HostName,
+		Env:      sp.Payload.Env,
+		Traces:   sp.Payload.Traces,
+		Stats:    sp.Payload.Stats,
+	}
+	for k, v := range sp.Extras {
+		p.SetExtra(k, v)
+	}
+	return p, nil
+}
+
+// Pop removes the oldest spooled payload.
+func (s *payloadSpool) Pop() {
+	if len(s.files) == 0 {
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, s.files[0].name)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("cannot remove spooled payload: %v", err)
+	}
+	s.size -= s.files[0].size
+	s.files = s.files[1:]
+}
+
+// evict removes the oldest payloads until the spool fits its size budget.
+func (s *payloadSpool) evict() {
+	nbDrops := 0
+	for len(s.files) > 0 && s.size > s.maxSize {
+		s.Pop()
+		nbDrops++
+	}
+
+	if nbDrops > 0 {
+		log.Infof("dropping %d spooled payloads (payload spool full)", nbDrops)
+		statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+			int64(nbDrops), []string{"reason:spool_full"}, 1)
+	}
+}
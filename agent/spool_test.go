@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadSpool(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	spool, err := newPayloadSpool(dir, 1<<20)
+	assert.Nil(err)
+	assert.Equal(0, spool.Len())
+
+	for _, env := range []string{"p0", "p1", "p2"} {
+		p := newTestPayload(env)
+		p.SetExtra("Datadog-Meta-Lang", "go")
+		assert.Nil(spool.Push(&p))
+	}
+	assert.Equal(3, spool.Len())
+
+	// a new spool on the same directory picks up the payloads, in order
+	spool, err = newPayloadSpool(dir, 1<<20)
+	assert.Nil(err)
+	assert.Equal(3, spool.Len())
+
+	for _, env := range []string{"p0", "p1", "p2"} {
+		p, err := spool.Peek()
+		assert.Nil(err)
+		assert.Equal(env, p.Env)
+		assert.Equal("test.host", p.HostName)
+		assert.Len(p.Traces, 1)
+		assert.Len(p.Stats, 1)
+		assert.Equal("go", p.Extras()["Datadog-Meta-Lang"])
+		spool.Pop()
+	}
+	assert.Equal(0, spool.Len())
+	assert.Equal(int64(0), spool.Size())
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(files, 0)
+}
+
+func TestPayloadSpoolEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	spool, err := newPayloadSpool(dir, 1<<20)
+	assert.Nil(err)
+
+	p := newTestPayload("p0")
+	assert.Nil(spool.Push(&p))
+	size := spool.Size()
+
+	// a budget just large enough for two payloads
+	spool.maxSize = 2*size + size/2
+	for _, env := range []string{"p1", "p2"} {
+		p := newTestPayload(env)
+		assert.Nil(spool.Push(&p))
+	}
+
+	assert.Equal(2, spool.Len())
+	p, err = spool.Peek()
+	assert.Nil(err)
+	assert.Equal("p1", p.Env)
+
+	// shrinking the budget on restart evicts too
+	spool, err = newPayloadSpool(dir, int(size))
+	assert.Nil(err)
+	assert.Equal(1, spool.Len())
+	p, err = spool.Peek()
+	assert.Nil(err)
+	assert.Equal("p2", p.Env)
+}
+
+func TestPayloadSpoolCleanup(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, spoolTmpPrefix+"123"), []byte("partial"), 0600))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "00000000000000000001-000001"+spoolFileExt), []byte("garbage"), 0600))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a payload"), 0600))
+
+	spool, err := newPayloadSpool(dir, 1<<20)
+	assert.Nil(err)
+	assert.Equal(1, spool.Len())
+
+	_, err = os.Stat(filepath.Join(dir, spoolTmpPrefix+"123"))
+	assert.True(os.IsNotExist(err))
+
+	_, err = spool.Peek()
+	assert.NotNil(err)
+}
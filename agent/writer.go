@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -12,23 +16,70 @@ import (
 	"github.com/DataDog/datadog-trace-agent/watchdog"
 )
 
-// the amount of time in seconds to wait before resending a payload
-const payloadResendDelay = 5 * time.Second
-
 // the amount of time in seconds a payload can stay buffered before being dropped
 const payloadMaxAge = 10 * time.Minute
 
-// writerPayload wraps a model.AgentPayload and keeps track of a list of
-// endpoints the payload must be sent to.
+// how often buffered payloads are retried while exiting
+const shutdownFlushInterval = 100 * time.Millisecond
+
+// the maximum number of spooled payloads replayed in a single flush, so that
+// a large spool does not hold the writer for too long
+const spoolReplayBatch = 10
+
+// writerEndpoint is an endpoint the Writer sends payloads to, along with
+// the state needed to retry the payloads it failed to accept.
+type writerEndpoint struct {
+	AgentEndpoint
+
+	retry *retryPolicy  // when the endpoint can be sent payloads again after failures
+	spool *payloadSpool // on-disk buffer of failed payloads, nil if disabled
+	tags  []string      // statsd tags identifying the endpoint
+}
+
+func newWriterEndpoint(conf *config.AgentConfig, endpoint AgentEndpoint, name string) *writerEndpoint {
+	return &writerEndpoint{
+		AgentEndpoint: endpoint,
+		retry:         newRetryPolicy(conf.APIRetryBackoffBase, conf.APIRetryBackoffMax, conf.APICircuitBreakerThreshold),
+		tags:          []string{"endpoint:" + name},
+	}
+}
+
+// failure records that the endpoint failed to accept a payload
+func (e *writerEndpoint) failure(err *apiError, now time.Time) {
+	wasOpen := e.retry.CircuitOpen()
+	delay := e.retry.Failure(now, err.retryAfter)
+
+	if err.retryAfter > 0 {
+		statsd.Client.Count("datadog.trace_agent.writer.retry_after", 1, e.tags, 1)
+	}
+	statsd.Client.Gauge("datadog.trace_agent.writer.retry_delay", delay.Seconds(), e.tags, 1)
+
+	if !wasOpen && e.retry.CircuitOpen() {
+		log.Warnf("%d consecutive errors from %s, only probing it until it recovers", e.retry.failures, err.endpoint.url)
+		statsd.Client.Count("datadog.trace_agent.writer.circuit_breaker", 1, append(e.tags, "state:open"), 1)
+	}
+}
+
+// success records that the endpoint accepted a payload
+func (e *writerEndpoint) success() {
+	if e.retry.Success() {
+		log.Info("endpoint recovered, resuming normal flushes")
+		statsd.Client.Count("datadog.trace_agent.writer.circuit_breaker", 1, append(e.tags, "state:closed"), 1)
+	}
+}
+
+// writerPayload wraps a model.AgentPayload and keeps track of the
+// endpoint the payload must be sent to.
 type writerPayload struct {
 	payload      model.AgentPayload // the payload itself
+	data         []byte             // the serialized payload, shared by the endpoints, or nil if it has not been serialized yet
 	size         int                // the size of the serialized payload or 0 if it has not been serialized yet
-	endpoint     AgentEndpoint      // the endpoints the payload must be sent to
+	endpoint     *writerEndpoint    // the endpoint the payload must be sent to
 	creationDate time.Time          // the creation date of the payload
-	nextFlush    time.Time          // The earliest moment we can flush
+	attempts     int                // the number of times we tried to send the payload
 }
 
-func newWriterPayload(p model.AgentPayload, endpoint AgentEndpoint) *writerPayload {
+func newWriterPayload(p model.AgentPayload, endpoint *writerEndpoint) *writerPayload {
 	return &writerPayload{
 		payload:      p,
 		endpoint:     endpoint,
@@ -37,16 +88,23 @@ func newWriterPayload(p model.AgentPayload, endpoint AgentEndpoint) *writerPaylo
 }
 
 func (p *writerPayload) write() error {
-	size, err := p.endpoint.Write(p.payload)
+	var size int
+	var err error
+	if w, ok := p.endpoint.AgentEndpoint.(encodedWriter); ok && p.data != nil {
+		size, err = w.WriteEncoded(p.payload, p.data)
+	} else {
+		size, err = p.endpoint.Write(p.payload)
+	}
 	p.size = size
+	p.attempts++
 	return err
 }
 
 // Writer is the last chain of trace-agent which takes the
 // pre-processed data from channels and tentatively output them
-// to a given endpoint.
+// to the given endpoints.
 type Writer struct {
-	endpoint AgentEndpoint // where the data will end
+	endpoints []*writerEndpoint // where the data will end, each payload goes to all of them
 
 	// input data
 	inPayloads chan model.AgentPayload     // main payloads for processed traces/stats
@@ -63,23 +121,40 @@ type Writer struct {
 
 // NewWriter returns a new Writer
 func NewWriter(conf *config.AgentConfig) *Writer {
-	var endpoint AgentEndpoint
+	var endpoints []*writerEndpoint
 
 	if conf.APIEnabled {
-		endpoint = NewAPIEndpoint(conf.APIEndpoint, conf.APIKey)
-		if conf.Proxy != nil {
-			// we have some kind of proxy configured.
-			// make sure our http client uses it
-			log.Infof("configuring proxy through host %s", conf.Proxy.Host)
-			endpoint.(*APIEndpoint).SetProxy(conf.Proxy)
+		urls, keys := conf.APIEndpointKeys()
+		for i := range urls {
+			endpoint := NewAPIEndpoint(urls[i], keys[i])
+			if conf.Proxy != nil {
+				// we have some kind of proxy configured.
+				// make sure our http client uses it
+				log.Infof("configuring proxy through host %s", conf.Proxy.Host)
+				endpoint.SetProxy(conf.Proxy)
+			}
+			e := newWriterEndpoint(conf, endpoint, endpoint.url)
+			e.spool = newEndpointSpool(conf, endpoint)
+			endpoints = append(endpoints, e)
 		}
-	} else {
+	}
+
+	if conf.FileOutputDir != "" {
+		if endpoint, err := NewFileEndpoint(conf); err != nil {
+			log.Errorf("cannot write payloads to %s: %v", conf.FileOutputDir, err)
+		} else {
+			log.Infof("writing payloads to files in %s", conf.FileOutputDir)
+			endpoints = append(endpoints, newWriterEndpoint(conf, endpoint, "file"))
+		}
+	}
+
+	if len(endpoints) == 0 {
 		log.Info("API interface is disabled, flushing to /dev/null instead")
-		endpoint = NullEndpoint{}
+		endpoints = []*writerEndpoint{newWriterEndpoint(conf, NullEndpoint{}, "null")}
 	}
 
 	return &Writer{
-		endpoint: endpoint,
+		endpoints: endpoints,
 
 		// small buffer to not block in case we're flushing
 		inPayloads: make(chan model.AgentPayload, 1),
@@ -94,6 +169,27 @@ func NewWriter(conf *config.AgentConfig) *Writer {
 	}
 }
 
+// newEndpointSpool returns the spool of the given endpoint, or nil if
+// spooling is disabled. Each endpoint/API key pair has its own directory so
+// that a payload is only replayed to the endpoint which failed it.
+func newEndpointSpool(conf *config.AgentConfig, endpoint *APIEndpoint) *payloadSpool {
+	if conf.APIPayloadSpoolDir == "" {
+		return nil
+	}
+
+	h := fnv.New64a()
+	io.WriteString(h, endpoint.url)
+	io.WriteString(h, endpoint.apiKey)
+	dir := filepath.Join(conf.APIPayloadSpoolDir, fmt.Sprintf("%016x", h.Sum64()))
+
+	spool, err := newPayloadSpool(dir, conf.APIPayloadSpoolMaxSize)
+	if err != nil {
+		log.Errorf("cannot use payload spool directory %s, spooling disabled for %s: %v", dir, endpoint.url, err)
+		return nil
+	}
+	return spool
+}
+
 // isPayloadBufferingEnabled returns true if payload buffering is enabled or
 // false if it is not.
 func (w *Writer) isPayloadBufferingEnabled() bool {
@@ -125,8 +221,7 @@ func (w *Writer) main() {
 			if p.IsEmpty() {
 				continue
 			}
-			w.payloadBuffer = append(w.payloadBuffer,
-				newWriterPayload(p, w.endpoint))
+			w.addPayload(p)
 			w.Flush()
 		case <-flushTicker.C:
 			w.Flush()
@@ -138,21 +233,87 @@ func (w *Writer) main() {
 			}
 		case <-w.exit:
 			log.Info("exiting, trying to flush all remaining data")
-			w.Flush()
+			w.shutdown(time.Now().Add(w.conf.WriterShutdownTimeout))
 			return
 		}
 	}
 }
 
+// addPayload encodes p, splitting it if needed, and buffers a copy of each
+// chunk for every endpoint, all of them sharing its encoding.
+func (w *Writer) addPayload(p model.AgentPayload) {
+	for _, chunk := range splitPayload(p, w.conf.APIPayloadMaxSize, w.conf.APIPayloadMaxTraces) {
+		for _, e := range w.endpoints {
+			wp := newWriterPayload(chunk.AgentPayload, e)
+			wp.data = chunk.data
+			wp.size = len(chunk.data)
+			w.payloadBuffer = append(w.payloadBuffer, wp)
+		}
+	}
+}
+
+// shutdown buffers the payloads and services still waiting in the input
+// channels then tries to deliver all the buffered payloads until deadline.
+// The payloads which could not be delivered in time are spooled if possible.
+func (w *Writer) shutdown(deadline time.Time) {
+	for draining := true; draining; {
+		select {
+		case p := <-w.inPayloads:
+			if p.IsEmpty() {
+				continue
+			}
+			w.addPayload(p)
+		case sm := <-w.inServices:
+			if w.serviceBuffer.Update(sm) {
+				w.FlushServices()
+			}
+		default:
+			draining = false
+		}
+	}
+
+	for {
+		w.Flush()
+		if len(w.payloadBuffer) == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(shutdownFlushInterval)
+	}
+
+	var payloads []*writerPayload
+	for _, p := range w.payloadBuffer {
+		if p.endpoint.spool != nil && w.spoolPayload(p) {
+			continue
+		}
+		payloads = append(payloads, p)
+	}
+	if len(payloads) > 0 {
+		log.Warnf("dropping %d payloads which could not be sent before exiting", len(payloads))
+		statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+			int64(len(payloads)), []string{"reason:shutdown"}, 1)
+	}
+	w.payloadBuffer = payloads
+}
+
 // Stop stops the main Run loop
 func (w *Writer) Stop() {
 	close(w.exit)
 	w.exitWG.Wait()
+
+	for _, e := range w.endpoints {
+		if c, ok := e.AgentEndpoint.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Errorf("error closing endpoint: %v", err)
+			}
+		}
+	}
 }
 
-// FlushServices initiate a flush of the services to the services endpoint
+// FlushServices initiate a flush of the services to the services endpoints
 func (w *Writer) FlushServices() {
-	w.endpoint.WriteServices(w.serviceBuffer)
+	for _, e := range w.endpoints {
+		e.WriteServices(w.serviceBuffer)
+	}
 }
 
 // Flush actually writes the data in the API
@@ -168,47 +329,85 @@ func (w *Writer) Flush() {
 		bufSize += p.size
 	}
 
+	// Replay the spools first, so that payloads are sent in order: new
+	// payloads are spooled too until the spool of their endpoint is empty
+	for _, e := range w.endpoints {
+		w.replaySpool(e, now)
+	}
+
 	nbSuccesses := 0
 	nbErrors := 0
 
 	for _, p := range w.payloadBuffer {
-		if w.isPayloadBufferingEnabled() && p.nextFlush.After(now) {
-			// We already tried to flush recently, so there's no
-			// point in trying again right now.
+		if p.endpoint.spool != nil && p.endpoint.spool.Len() > 0 && w.spoolPayload(p) {
+			// Older payloads are still spooled, this one is sent after them.
+			continue
+		}
+
+		if !p.endpoint.retry.CanSend(now, p.attempts > 0) {
+			// The endpoint failed recently, so there's no point
+			// in trying again right now.
+			if p.endpoint.spool != nil && w.spoolPayload(p) {
+				// The spool will send it once the endpoint recovers.
+				continue
+			}
+			if !w.isPayloadBufferingEnabled() {
+				statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+					1, []string{"reason:circuit_open"}, 1)
+				continue
+			}
+			if now.Sub(p.creationDate) > payloadMaxAge {
+				statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+					1, []string{"reason:too_old"}, 1)
+				continue
+			}
 			bufferPayload(p)
 			continue
 		}
 
+		if p.attempts > 0 {
+			statsd.Client.Count("datadog.trace_agent.writer.retry", 1, p.endpoint.tags, 1)
+		}
+
 		err := p.write()
 
 		if err == nil {
 			nbSuccesses++
-		} else {
-			nbErrors++
+			p.endpoint.success()
+			continue
 		}
 
-		if err == nil || !w.isPayloadBufferingEnabled() {
+		nbErrors++
+
+		terr, ok := err.(*apiError)
+		if !ok {
+			// This payload will never be accepted, do not retry it.
 			continue
 		}
 
-		if terr, ok := err.(*apiError); ok {
-			// We could not send the payload and this is an API
-			// endpoint error, so we can try again later.
+		// We could not send the payload and this is an API
+		// endpoint error, so we can try again later.
+		p.endpoint.failure(terr, now)
 
-			if now.Sub(p.creationDate) > payloadMaxAge {
-				// The payload is too old, let's drop it
-				statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
-					int64(1), []string{"reason:too_old"}, 1)
-				continue
-			}
+		if p.endpoint.spool != nil && w.spoolPayload(p) {
+			// The spool will take care of sending it again.
+			continue
+		}
 
-			p.nextFlush = now.Add(payloadResendDelay)
+		if !w.isPayloadBufferingEnabled() {
+			continue
+		}
 
-			// Keep this payload in the buffer to try again later,
-			// but only with the endpoints that failed.
-			p.endpoint = terr.endpoint
-			bufferPayload(p)
+		if now.Sub(p.creationDate) > payloadMaxAge {
+			// The payload is too old, let's drop it
+			statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+				int64(1), []string{"reason:too_old"}, 1)
+			continue
 		}
+
+		// Keep this payload in the buffer to try again later,
+		// other endpoints have their own copy.
+		bufferPayload(p)
 	}
 
 	if nbSuccesses > 0 {
@@ -239,5 +438,87 @@ func (w *Writer) Flush() {
 	statsd.Client.Gauge("datadog.trace_agent.writer.payload_buffer_size",
 		float64(bufSize), nil, 1)
 
+	for _, e := range w.endpoints {
+		if e.spool == nil {
+			continue
+		}
+		statsd.Client.Gauge("datadog.trace_agent.writer.payload_spool_size",
+			float64(e.spool.Size()), e.tags, 1)
+		statsd.Client.Gauge("datadog.trace_agent.writer.payload_spool_count",
+			float64(e.spool.Len()), e.tags, 1)
+	}
+
+	for _, e := range w.endpoints {
+		open := 0.0
+		if e.retry.CircuitOpen() {
+			open = 1
+		}
+		statsd.Client.Gauge("datadog.trace_agent.writer.circuit_open", open, e.tags, 1)
+	}
+
 	w.payloadBuffer = payloads
 }
+
+// spoolPayload writes a payload which could not be sent to the spool. It
+// returns false if the payload could not be spooled.
+func (w *Writer) spoolPayload(p *writerPayload) bool {
+	if err := p.endpoint.spool.Push(&p.payload); err != nil {
+		log.Errorf("cannot spool payload: %v", err)
+		statsd.Client.Count("datadog.trace_agent.writer.spool_error", 1, nil, 1)
+		return false
+	}
+
+	statsd.Client.Count("datadog.trace_agent.writer.spooled_payload", 1, nil, 1)
+	return true
+}
+
+// replaySpool sends spooled payloads to e, oldest first, until its spool is
+// empty, the endpoint fails again or spoolReplayBatch payloads were sent.
+func (w *Writer) replaySpool(e *writerEndpoint, now time.Time) {
+	if e.spool == nil || e.spool.Len() == 0 || !e.retry.CanSend(now, true) {
+		return
+	}
+
+	nbReplays := 0
+	nbErrors := 0
+
+	for n := 0; n < spoolReplayBatch && e.spool.Len() > 0; n++ {
+		p, err := e.spool.Peek()
+		if err != nil {
+			log.Errorf("dropping unreadable spooled payload: %v", err)
+			statsd.Client.Count("datadog.trace_agent.writer.dropped_payload",
+				1, []string{"reason:spool_corrupt"}, 1)
+			e.spool.Pop()
+			continue
+		}
+
+		statsd.Client.Count("datadog.trace_agent.writer.retry", 1, e.tags, 1)
+		_, err = e.Write(p)
+		if terr, ok := err.(*apiError); ok {
+			// Still failing, keep the payload and try again later.
+			nbErrors++
+			e.failure(terr, now)
+			break
+		}
+
+		if err != nil {
+			// This payload will never be accepted, do not retry it.
+			nbErrors++
+		} else {
+			nbReplays++
+			e.success()
+		}
+		e.spool.Pop()
+	}
+
+	if nbReplays > 0 {
+		log.Infof("replayed %d spooled payloads, %d left", nbReplays, e.spool.Len())
+		statsd.Client.Count("datadog.trace_agent.writer.replayed_payload",
+			int64(nbReplays), nil, 1)
+	}
+
+	if nbErrors > 0 {
+		statsd.Client.Count("datadog.trace_agent.writer.flush",
+			int64(nbErrors), []string{"status:error"}, 1)
+	}
+}
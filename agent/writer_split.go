@@ -0,0 +1,137 @@
+package main
+
+import (
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+)
+
+// encodedPayload is a payload along with its encoding, so that it is encoded
+// once whatever the number of endpoints it is sent to. data is nil if the
+// payload cannot be encoded, the endpoints report the error when writing it.
+type encodedPayload struct {
+	model.AgentPayload
+	data []byte
+}
+
+// encodePayload returns p along with its encoding
+func encodePayload(p model.AgentPayload) encodedPayload {
+	data, err := model.EncodeAgentPayload(&p)
+	if err != nil {
+		data = nil
+	}
+	return encodedPayload{AgentPayload: p, data: data}
+}
+
+// fits tells if the encoded size of p is at most maxSize bytes, 0 meaning
+// there is no limit. Payloads which cannot be encoded are deemed to fit.
+func (p *encodedPayload) fits(maxSize int) bool {
+	return maxSize <= 0 || len(p.data) <= maxSize
+}
+
+// splitPayload encodes p, splitting it into payloads which hold at most
+// maxTraces traces and whose encoded size is at most maxSize bytes, so that
+// the API does not reject them for being too large. Stats go in a payload of
+// their own and traces are spread over as few payloads as possible. A limit
+// of 0 disables it. Extras are copied on every payload.
+func splitPayload(p model.AgentPayload, maxSize, maxTraces int) []encodedPayload {
+	// the ratio of the encoded size to the msgpack size, used to estimate the
+	// encoded size of the traces without encoding them, 0 until measured
+	var ratio float64
+	if maxTraces <= 0 || len(p.Traces) <= maxTraces {
+		ep := encodePayload(p)
+		if ep.fits(maxSize) {
+			return []encodedPayload{ep}
+		}
+		ratio = float64(len(ep.data)) / float64(p.Msgsize())
+	}
+
+	var payloads []encodedPayload
+	if len(p.Stats) > 0 {
+		stats := encodePayload(newPayloadChunk(&p, nil, p.Stats))
+		if !stats.fits(maxSize) {
+			log.Warnf("stats payload over the maximum payload size of %d bytes", maxSize)
+		}
+		payloads = append(payloads, stats)
+	}
+
+	traces := p.Traces
+	for len(traces) > 0 {
+		n := len(traces)
+		if maxTraces > 0 && n > maxTraces {
+			n = maxTraces
+		}
+		if ratio == 0 {
+			// measure it on the first group of traces, sent as is if it fits
+			chunk := encodePayload(newPayloadChunk(&p, traces[:n], nil))
+			ratio = float64(len(chunk.data)) / float64(chunk.Msgsize())
+			if chunk.fits(maxSize) {
+				payloads = append(payloads, chunk)
+				traces = traces[n:]
+				continue
+			}
+		}
+		payloads = splitTraces(payloads, &p, traces[:n], maxSize, ratio)
+		traces = traces[n:]
+	}
+
+	statsd.Client.Count("datadog.trace_agent.writer.split_payload", int64(len(payloads)-1), nil, 1)
+	log.Debugf("split payload of %d traces and %d stats buckets into %d payloads",
+		len(p.Traces), len(p.Stats), len(payloads))
+
+	return payloads
+}
+
+// splitTraces appends to payloads the traces grouped by their estimated
+// encoded size, given ratio, and returns the resulting slice. Groups are
+// filled up to 3/4 of maxSize as small payloads compress less well, so that
+// most of them are encoded once.
+func splitTraces(payloads []encodedPayload, p *model.AgentPayload, traces []model.Trace, maxSize int, ratio float64) []encodedPayload {
+	if maxSize <= 0 {
+		return appendTraces(payloads, p, traces, maxSize)
+	}
+	start, size := 0, 0
+	for i := range traces {
+		n := int(float64(traces[i].Msgsize()) * ratio)
+		if i > start && size+n > maxSize*3/4 {
+			payloads = appendTraces(payloads, p, traces[start:i], maxSize)
+			start, size = i, 0
+		}
+		size += n
+	}
+	return appendTraces(payloads, p, traces[start:], maxSize)
+}
+
+// appendTraces appends to payloads the chunks of traces, halved until their
+// encoded size is at most maxSize, and returns the resulting slice.
+func appendTraces(payloads []encodedPayload, p *model.AgentPayload, traces []model.Trace, maxSize int) []encodedPayload {
+	chunk := encodePayload(newPayloadChunk(p, traces, nil))
+	if chunk.fits(maxSize) {
+		return append(payloads, chunk)
+	}
+	if len(traces) == 1 {
+		// nothing more we can do, let the API decide
+		log.Warnf("a single trace is over the maximum payload size of %d bytes", maxSize)
+		return append(payloads, chunk)
+	}
+
+	half := len(traces) / 2
+	payloads = appendTraces(payloads, p, traces[:half], maxSize)
+	return appendTraces(payloads, p, traces[half:], maxSize)
+}
+
+// newPayloadChunk returns a payload with the metadata of p holding the given
+// traces and stats.
+func newPayloadChunk(p *model.AgentPayload, traces []model.Trace, stats []model.StatsBucket) model.AgentPayload {
+	chunk := model.AgentPayload{
+		HostName: p.HostName,
+		Env:      p.Env,
+		Traces:   traces,
+		Stats:    stats,
+	}
+	for k, v := range p.Extras() {
+		chunk.SetExtra(k, v)
+	}
+	return chunk
+}
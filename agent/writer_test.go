@@ -1,10 +1,15 @@
 package main
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -201,6 +206,7 @@ func TestWriterBuffering(t *testing.T) {
 	conf.APIEndpoint = server.URL
 	conf.APIKey = "key"
 	conf.APIPayloadBufferMaxSize = payloadSizes[0] + payloadSizes[1]
+	conf.WriterShutdownTimeout = 0 // do not retry on exit
 
 	w := NewWriter(conf)
 	// Make the chan unbuffered to block on write
@@ -245,3 +251,472 @@ func TestWriterDisabledBuffering(t *testing.T) {
 	// dropped and the buffer should be empty.
 	assert.Equal(0, len(w.payloadBuffer))
 }
+
+func TestWriterSpool(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// An API failing until healthy is set
+	data := make(chan dataFromAPI, 2)
+	var healthy int32
+	api := newTestServer(t, data)
+	defer api.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		api.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APIPayloadSpoolDir = dir
+
+	w := NewWriter(conf)
+	// Make the chan unbuffered to block on write
+	w.inPayloads = make(chan model.AgentPayload)
+	go w.Run()
+
+	w.inPayloads <- newTestPayload("p0")
+	w.inPayloads <- newTestPayload("p1")
+	w.Stop()
+
+	// Failed payloads went to disk instead of the memory buffer
+	assert.Equal(0, len(w.payloadBuffer))
+	assert.Equal(2, w.endpoints[0].spool.Len())
+
+	// Restart the writer once the endpoint recovered
+	atomic.StoreInt32(&healthy, 1)
+
+	w = NewWriter(conf)
+	assert.Equal(2, w.endpoints[0].spool.Len())
+	w.Flush()
+	assert.Equal(0, w.endpoints[0].spool.Len())
+
+	for _, env := range []string{"p0", "p1"} {
+		select {
+		case received := <-data:
+			assert.Equal("/api/v0.1/collector", received.urlPath)
+			gz, err := gzip.NewReader(strings.NewReader(received.body))
+			assert.Nil(err)
+			var p model.AgentPayload
+			assert.Nil(json.NewDecoder(gz).Decode(&p))
+			assert.Equal(env, p.Env)
+		case <-time.After(time.Second):
+			t.Fatal("did not receive spooled payload in time")
+		}
+	}
+}
+
+func TestWriterSpoolOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	data := make(chan dataFromAPI, 2*spoolReplayBatch)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APIPayloadSpoolDir = dir
+
+	w := NewWriter(conf)
+	e := w.endpoints[0]
+
+	// a backlog left by an outage
+	var envs []string
+	for i := 0; i < spoolReplayBatch+5; i++ {
+		p := newTestPayload(fmt.Sprintf("p%d", i))
+		assert.Nil(e.spool.Push(&p))
+		envs = append(envs, p.Env)
+	}
+
+	w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(newTestPayload("new"), e))
+	envs = append(envs, "new")
+	w.Flush()
+
+	// the new payload waits in the spool for the backlog to be sent
+	assert.Len(data, spoolReplayBatch)
+	assert.Equal(6, e.spool.Len())
+	assert.Equal(0, len(w.payloadBuffer))
+
+	w.Flush()
+	assert.Equal(0, e.spool.Len())
+	assert.Len(data, len(envs))
+	for _, env := range envs {
+		received := <-data
+		gz, err := gzip.NewReader(strings.NewReader(received.body))
+		assert.Nil(err)
+		var p model.AgentPayload
+		assert.Nil(json.NewDecoder(gz).Decode(&p))
+		assert.Equal(env, p.Env)
+	}
+}
+
+func TestWriterMultipleEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 2)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	failing := newFailingTestServer(t, http.StatusInternalServerError)
+	defer failing.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoints = []string{server.URL, failing.URL}
+	conf.APIKeys = []string{"key1", "key2"}
+	conf.WriterShutdownTimeout = 0 // do not retry on exit
+
+	w := NewWriter(conf)
+	assert.Len(w.endpoints, 2)
+	// Make the chan unbuffered to block on write
+	w.inPayloads = make(chan model.AgentPayload)
+	go w.Run()
+
+	w.inPayloads <- newTestPayload("test")
+	w.Stop()
+
+	select {
+	case received := <-data:
+		assert.Equal(map[string][]string{"api_key": []string{"key1"}}, received.urlParams)
+	case <-time.After(time.Second):
+		t.Fatal("did not receive payload in time")
+	}
+
+	// Only the failing endpoint has to be retried
+	assert.Equal(1, len(w.payloadBuffer))
+	assert.Equal(failing.URL, w.payloadBuffer[0].endpoint.AgentEndpoint.(*APIEndpoint).url)
+
+	w.payloadBuffer[0].endpoint.retry.nextRetry = time.Time{}
+	w.Flush()
+	assert.Equal(1, len(w.payloadBuffer))
+	select {
+	case <-data:
+		t.Fatal("payload sent again to the healthy endpoint")
+	default:
+	}
+}
+
+func TestWriterCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	defer withRetryJitter(0)()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APICircuitBreakerThreshold = 2
+
+	w := NewWriter(conf)
+	e := w.endpoints[0]
+
+	for i := 0; i < 5; i++ {
+		w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(newTestPayload("test"), e))
+		w.Flush()
+	}
+
+	// 2 payloads went through before the circuit opened, every other
+	// payload was buffered without hitting the endpoint
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	assert.True(e.retry.CircuitOpen())
+	assert.Equal(5, len(w.payloadBuffer))
+
+	// once the delay expired, a single payload probes the endpoint
+	e.retry.nextRetry = time.Now()
+	w.Flush()
+	assert.Equal(int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(5, len(w.payloadBuffer))
+	assert.True(e.retry.nextRetry.After(time.Now()))
+}
+
+func TestWriterCircuitOpenSpool(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APICircuitBreakerThreshold = 2
+	conf.APIPayloadSpoolDir = dir
+
+	w := NewWriter(conf)
+	e := w.endpoints[0]
+
+	// open the circuit
+	e.retry.Failure(time.Now(), time.Hour)
+	e.retry.Failure(time.Now(), time.Hour)
+	assert.True(e.retry.CircuitOpen())
+
+	for i := 0; i < 3; i++ {
+		w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(newTestPayload("test"), e))
+		w.Flush()
+	}
+
+	// payloads held back by the circuit breaker go to disk, not memory
+	assert.Equal(int32(0), atomic.LoadInt32(&requests))
+	assert.Equal(0, len(w.payloadBuffer))
+	assert.Equal(3, e.spool.Len())
+}
+
+func TestWriterCircuitOpenTooOld(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newFailingTestServer(t, http.StatusBadGateway)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APICircuitBreakerThreshold = 1
+
+	w := NewWriter(conf)
+	e := w.endpoints[0]
+	e.retry.Failure(time.Now(), time.Hour)
+
+	old := newWriterPayload(newTestPayload("old"), e)
+	old.creationDate = time.Now().Add(-payloadMaxAge - time.Minute)
+	w.payloadBuffer = append(w.payloadBuffer, old, newWriterPayload(newTestPayload("new"), e))
+	w.Flush()
+
+	// payloads held back by the circuit breaker still expire
+	assert.Equal(1, len(w.payloadBuffer))
+	assert.Equal("new", w.payloadBuffer[0].payload.Env)
+}
+
+func TestWriterRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APIRetryBackoffMax = 2 * time.Hour
+
+	w := NewWriter(conf)
+	e := w.endpoints[0]
+	w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(newTestPayload("test"), e))
+	w.Flush()
+
+	// rate limited payloads are kept, and retried after the asked delay
+	assert.Equal(1, len(w.payloadBuffer))
+	assert.True(e.retry.nextRetry.After(time.Now().Add(59 * time.Minute)))
+
+	// up to the maximum backoff delay
+	conf.APIRetryBackoffMax = time.Minute
+	w = NewWriter(conf)
+	e = w.endpoints[0]
+	w.payloadBuffer = append(w.payloadBuffer, newWriterPayload(newTestPayload("test"), e))
+	w.Flush()
+	assert.Equal(1, len(w.payloadBuffer))
+	assert.False(e.retry.nextRetry.After(time.Now().Add(time.Minute)))
+}
+
+func TestSplitPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := model.AgentPayload{
+		HostName: "test.host",
+		Env:      "test",
+		Stats:    []model.StatsBucket{fixtures.TestStatsBucket()},
+	}
+	for i := 0; i < 50; i++ {
+		payload.Traces = append(payload.Traces, fixtures.RandomTrace(5, 3))
+	}
+	payload.SetExtra("X-Datadog-Reported-Languages", "go")
+
+	data, err := model.EncodeAgentPayload(&payload)
+	assert.Nil(err)
+
+	// small enough payloads are left untouched
+	assert.Len(splitPayload(payload, len(data), 0), 1)
+	assert.Len(splitPayload(payload, 0, 0), 1)
+
+	for _, tc := range []struct {
+		maxSize, maxTraces int
+	}{
+		{len(data) / 4, 0},
+		{0, 8},
+		{len(data) / 2, 5},
+	} {
+		chunks := splitPayload(payload, tc.maxSize, tc.maxTraces)
+		assert.True(len(chunks) > 2, "%v", tc)
+
+		// stats come first, on their own
+		assert.Equal(payload.Stats, chunks[0].Stats)
+		assert.Len(chunks[0].Traces, 0)
+
+		var traces []model.Trace
+		for _, chunk := range chunks {
+			assert.Equal("test.host", chunk.HostName)
+			assert.Equal("test", chunk.Env)
+			assert.Equal(payload.Extras(), chunk.Extras())
+			// chunks come with their encoding
+			data, err := model.EncodeAgentPayload(&chunk.AgentPayload)
+			assert.Nil(err)
+			assert.Equal(len(data), len(chunk.data), "%v", tc)
+			if tc.maxSize > 0 {
+				assert.True(len(chunk.data) <= tc.maxSize, "%v: chunk of %d bytes", tc, len(chunk.data))
+			}
+			if tc.maxTraces > 0 {
+				assert.True(len(chunk.Traces) <= tc.maxTraces, "%v: chunk of %d traces", tc, len(chunk.Traces))
+			}
+			traces = append(traces, chunk.Traces...)
+		}
+		assert.Equal(payload.Traces, traces, "%v", tc)
+	}
+
+	// the encoded size of the traces is estimated as well when the number of
+	// traces is limited, so that chunks are not much smaller than needed
+	bySize := splitPayload(payload, len(data)/4, 0)
+	assert.True(len(splitPayload(payload, len(data)/4, 25)) <= len(bySize)+2)
+}
+
+func TestWriterSplitPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 10)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APIPayloadMaxTraces = 2
+
+	w := NewWriter(conf)
+	// Make the chan unbuffered to block on write
+	w.inPayloads = make(chan model.AgentPayload)
+	go w.Run()
+
+	payload := newTestPayload("test")
+	payload.Traces = append(payload.Traces, payload.Traces[0], payload.Traces[0])
+	payload.SetExtra("X-Datadog-Reported-Languages", "go")
+	w.inPayloads <- payload
+	w.Stop()
+
+	// 1 payload for stats and 2 for the 3 traces
+	assert.Len(data, 3)
+	for i := 0; i < 3; i++ {
+		received := <-data
+		assert.Equal("go", received.header.Get("X-Datadog-Reported-Languages"))
+	}
+}
+
+func TestWriterEncodeOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make(chan dataFromAPI, 2)
+	server := newTestServer(t, data)
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+
+	w := NewWriter(conf)
+	w.endpoints = append(w.endpoints, newWriterEndpoint(conf, NewAPIEndpoint(server.URL, "key2"), "other"))
+
+	payload := newTestPayload("test")
+	w.addPayload(payload)
+
+	// the payload is encoded once, for all the endpoints
+	assert.Len(w.payloadBuffer, 2)
+	assert.NotEmpty(w.payloadBuffer[0].data)
+	assert.Equal(&w.payloadBuffer[0].data[0], &w.payloadBuffer[1].data[0])
+	assert.Equal(len(w.payloadBuffer[0].data), w.payloadBuffer[0].size)
+
+	w.Flush()
+	assert.Len(w.payloadBuffer, 0)
+	assert.Len(data, 2)
+	first, second := <-data, <-data
+	assert.Equal(first.body, second.body)
+}
+
+func TestWriterShutdown(t *testing.T) {
+	assert := assert.New(t)
+	defer withRetryJitter(0)()
+
+	dir, err := ioutil.TempDir("", "trace-agent-spool")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// An API failing a few times before accepting payloads
+	data := make(chan dataFromAPI, 2)
+	var failures int32
+	api := newTestServer(t, data)
+	defer api.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failures, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		api.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	conf := config.NewDefaultAgentConfig()
+	conf.APIEndpoint = server.URL
+	conf.APIKey = "key"
+	conf.APIRetryBackoffBase = 20 * time.Millisecond
+	conf.WriterShutdownTimeout = time.Second
+
+	w := NewWriter(conf)
+	w.Run()
+
+	// the payload is still in the input channel when stopping
+	w.inPayloads <- newTestPayload("test")
+	w.Stop()
+
+	// and retried until delivered
+	select {
+	case received := <-data:
+		assert.Equal("/api/v0.1/collector", received.urlPath)
+	default:
+		t.Fatal("payload not delivered on exit")
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&failures))
+
+	// payloads which can't be delivered in time are spooled
+	atomic.StoreInt32(&failures, -100)
+	conf.APIPayloadSpoolDir = dir
+	conf.WriterShutdownTimeout = 50 * time.Millisecond
+	w = NewWriter(conf)
+	w.endpoints[0].retry.Failure(time.Now(), time.Minute)
+	w.Run()
+	w.inPayloads <- newTestPayload("test")
+	w.Stop()
+	assert.Equal(0, len(w.payloadBuffer))
+	assert.Equal(1, w.endpoints[0].spool.Len())
+}
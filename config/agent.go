@@ -3,6 +3,7 @@ package config
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
@@ -28,10 +29,38 @@ type AgentConfig struct {
 	DefaultEnv string // the traces will default to this environment
 
 	// API
-	APIEndpoint             string
-	APIKey                  string `json:"-"` // never publish this
-	APIEnabled              bool
+	APIEndpoint string
+	APIKey      string `json:"-"` // never publish this
+	APIEnabled  bool
+
+	// all the endpoints and API keys payloads are sent to, APIEndpoint and
+	// APIKey being the first ones, see APIEndpointKeys
+	APIEndpoints []string
+	APIKeys      []string `json:"-"` // never publish this
+
 	APIPayloadBufferMaxSize int
+	APIPayloadVersion       model.AgentPayloadVersion // encoding of the payloads sent to the API
+	APIPayloadMaxSize       int                       // payloads are split to keep their encoded size under this, 0 to disable
+	APIPayloadMaxTraces     int                       // payloads are split to hold at most this many traces, 0 to disable
+
+	// retry policy of each endpoint
+	APIRetryBackoffBase        time.Duration // delay before the first retry, doubled after each failure
+	APIRetryBackoffMax         time.Duration // upper bound of the retry delay
+	APICircuitBreakerThreshold int           // consecutive failures after which only probes are sent, 0 to disable
+
+	// how long the writer keeps trying to deliver payloads on exit
+	WriterShutdownTimeout time.Duration
+
+	// directory where payloads which could not be sent are kept, disabled if empty
+	APIPayloadSpoolDir     string
+	APIPayloadSpoolMaxSize int
+
+	// local files payloads and services are written to, disabled if FileOutputDir is empty
+	FileOutputDir            string
+	FileOutputFormat         string        // "ndjson" or "wire"
+	FileOutputRotateSize     int           // rotate files after this many bytes, 0 to disable
+	FileOutputRotateInterval time.Duration // rotate files after this duration, 0 to disable
+	FileOutputMaxFiles       int           // the number of rotated files to keep, 0 for all
 
 	// Concentrator
 	BucketInterval   time.Duration // the size of our pre-aggregation per bucket
@@ -43,12 +72,50 @@ type AgentConfig struct {
 	MaxTPS           float64
 	PrioritySampling bool
 
+	// factors applied to the sample rate of traces with errors, and of
+	// traces whose root is slower than the p99 of their signature, 1 to
+	// disable them
+	ErrorSampleBoost   float64
+	LatencySampleBoost float64
+
+	// the state of the samplers is saved to SamplerStateFile every
+	// SamplerStateInterval and on shutdown, and restored on startup if it
+	// is not older than SamplerStateMaxAge. Disabled if the file is empty.
+	SamplerStateFile     string
+	SamplerStateInterval time.Duration
+	SamplerStateMaxAge   time.Duration
+
+	// keep at least a trace per signature every RareSamplerWindow, for at
+	// most RareSamplerCapacity signatures. Disabled if the window is 0.
+	RareSamplerWindow   time.Duration
+	RareSamplerCapacity int
+
+	// limits to the number of traces kept per second by env and by service,
+	// on top of MaxTPS
+	TPSBudgets TPSBudgets
+
+	// the last SamplerDebugDecisions sampling decisions are served on the
+	// receiver port, as well as their sums by signature over the last
+	// SamplerDebugWindow. Disabled if there are no decisions to keep.
+	SamplerDebugDecisions int
+	SamplerDebugWindow    time.Duration
+
 	// Receiver
 	ReceiverHost    string
 	ReceiverPort    int
 	ConnectionLimit int // for rate-limiting, how many unique connections to allow in a lease period (30s)
 	ReceiverTimeout int
 
+	// unix domain socket the receiver listens on, in addition to ReceiverHost:ReceiverPort
+	ReceiverSocket     string
+	ReceiverSocketPerm os.FileMode
+
+	// the chunks of a trace are buffered until its root is received, for at
+	// most AssemblyWindow, and AssemblyMaxSpans spans are buffered at most.
+	// Disabled if the window is 0.
+	AssemblyWindow   time.Duration
+	AssemblyMaxSpans int
+
 	// internal telemetry
 	StatsdHost string
 	StatsdPort int
@@ -68,6 +135,126 @@ type AgentConfig struct {
 	Proxy *ProxySettings
 
 	Ignore map[string][]string
+
+	// allow and deny rules applied on the root span of traces, see the
+	// filters package for the syntax of conditions
+	FilterRules []FilterRule
+
+	// sensitive data scrubbing of span meta, see the obfuscate package
+	ObfuscationEnabled      bool
+	ObfuscationRules        []ObfuscationRule
+	ObfuscationRemoveKeys   []string // meta keys which are always removed
+	ObfuscationCreditCards  bool     // replace card numbers passing the Luhn check
+	ObfuscationBearerTokens bool     // replace the token of "Bearer <token>"
+	ObfuscationQuerySecrets []string // query string parameters whose values are replaced
+
+	// services whose http and web resources are normalized, see the quantizer
+	// package, and the custom path segments replaced for them
+	HTTPQuantizerServices []string
+	HTTPQuantizerPatterns []HTTPQuantizerPattern
+
+	// replace the arguments of redis commands by "?" in the redis.raw_command
+	// meta, except their keys
+	RedisObfuscateArgs bool
+
+	// rules overriding the signature sampling of the traces they match, see
+	// the sampler package
+	SamplingRules []SamplingRule
+}
+
+// TPSBudgets are the maximum numbers of traces kept per second for each env
+// and each service, as configured. Traces are limited by the budgets of both
+// their service and their env. Envs without a budget get the Default one,
+// and 0 means no budget.
+type TPSBudgets struct {
+	Default  float64
+	Envs     map[string]float64
+	Services map[string]float64
+}
+
+// SamplingRule is a named sampling rule, as configured. Traces whose root
+// span matches all its conditions are sampled at Rate, and at most MaxTPS
+// traces per second are kept if it is positive.
+type SamplingRule struct {
+	Name       string
+	Conditions []string // all conditions must match for the rule to apply
+	Rate       float64
+	MaxTPS     float64
+}
+
+// parseSamplingRule parses a sampling rule made of conditions and of the
+// rate=<rate> and max_tps=<tps> settings
+func parseSamplingRule(name string, args []string) (SamplingRule, error) {
+	rule := SamplingRule{Name: name, Rate: 1}
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "rate="):
+			rule.Rate, err = strconv.ParseFloat(strings.TrimPrefix(arg, "rate="), 64)
+			if err == nil && (rule.Rate < 0 || rule.Rate > 1) {
+				err = fmt.Errorf("rate %v is not between 0 and 1", rule.Rate)
+			}
+		case strings.HasPrefix(arg, "max_tps="):
+			rule.MaxTPS, err = strconv.ParseFloat(strings.TrimPrefix(arg, "max_tps="), 64)
+		default:
+			rule.Conditions = append(rule.Conditions, arg)
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+	if len(rule.Conditions) == 0 {
+		return rule, fmt.Errorf("no conditions")
+	}
+	return rule, nil
+}
+
+// setSamplingRule adds the rule to c, replacing the one of the same name if
+// any.
+func (c *AgentConfig) setSamplingRule(rule SamplingRule) {
+	for i, r := range c.SamplingRules {
+		if r.Name == rule.Name {
+			c.SamplingRules[i] = rule
+			return
+		}
+	}
+	c.SamplingRules = append(c.SamplingRules, rule)
+}
+
+// HTTPQuantizerPattern replaces the path segments of http resources matching
+// Pattern by the {Name} placeholder, as configured
+type HTTPQuantizerPattern struct {
+	Name    string
+	Pattern string
+}
+
+// ObfuscationRule is a named replacement of a regular expression in the
+// values of a meta key, as configured
+type ObfuscationRule struct {
+	Name        string
+	Key         string // the meta key, "*" for all of them
+	Pattern     string
+	Replacement string // may refer to submatches as $1 or ${name}
+}
+
+// FilterRule is a named filter rule, as configured
+type FilterRule struct {
+	Name       string
+	Action     string   // "allow" or "deny"
+	Conditions []string // all conditions must match for the rule to apply
+}
+
+// setFilterRule adds the rule to c, replacing the one of the same action and
+// name if any.
+func (c *AgentConfig) setFilterRule(rule FilterRule) {
+	for i, r := range c.FilterRules {
+		if r.Action == rule.Action && r.Name == rule.Name {
+			c.FilterRules[i] = rule
+			return
+		}
+	}
+	c.FilterRules = append(c.FilterRules, rule)
 }
 
 // mergeEnv applies overrides from environment variables to the trace agent configuration
@@ -90,6 +277,19 @@ func mergeEnv(c *AgentConfig) {
 			vals[i] = strings.TrimSpace(vals[i])
 		}
 		c.APIKey = vals[0]
+		c.APIKeys = vals
+	}
+
+	if v := os.Getenv("DD_PAYLOAD_SPOOL_DIR"); v != "" {
+		c.APIPayloadSpoolDir = v
+	}
+
+	if v := os.Getenv("DD_FILE_OUTPUT_DIR"); v != "" {
+		c.FileOutputDir = v
+	}
+
+	if v := os.Getenv("DD_SAMPLER_STATE_FILE"); v != "" {
+		c.SamplerStateFile = v
 	}
 
 	if v := os.Getenv("DD_RECEIVER_PORT"); v != "" {
@@ -101,6 +301,10 @@ func mergeEnv(c *AgentConfig) {
 		}
 	}
 
+	if v := os.Getenv("DD_RECEIVER_SOCKET"); v != "" {
+		c.ReceiverSocket = v
+	}
+
 	if v := os.Getenv("DD_BIND_HOST"); v != "" {
 		c.StatsdHost = v
 		c.ReceiverHost = v
@@ -110,6 +314,71 @@ func mergeEnv(c *AgentConfig) {
 		c.Ignore["resource"], _ = splitString(v, ',')
 	}
 
+	// DD_FILTER_DENY_<NAME> and DD_FILTER_ALLOW_<NAME> define filter rules
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, action := range []string{"allow", "deny"} {
+			prefix := "DD_FILTER_" + strings.ToUpper(action) + "_"
+			if !strings.HasPrefix(parts[0], prefix) || len(parts[0]) == len(prefix) {
+				continue
+			}
+			conditions, err := splitString(parts[1], ',')
+			if err != nil {
+				log.Errorf("Failed to parse %s: %v", parts[0], err)
+				continue
+			}
+			c.setFilterRule(FilterRule{
+				Name:       strings.ToLower(strings.TrimPrefix(parts[0], prefix)),
+				Action:     action,
+				Conditions: conditions,
+			})
+		}
+	}
+
+	if v := os.Getenv("DD_OBFUSCATION_ENABLED"); v == "true" {
+		c.ObfuscationEnabled = true
+	} else if v == "false" {
+		c.ObfuscationEnabled = false
+	}
+
+	if v := os.Getenv("DD_OBFUSCATION_REMOVE_KEYS"); v != "" {
+		c.ObfuscationRemoveKeys, _ = splitString(v, ',')
+	}
+
+	if v := os.Getenv("DD_HTTP_QUANTIZER_SERVICES"); v != "" {
+		c.HTTPQuantizerServices, _ = splitString(v, ',')
+	}
+
+	if v := os.Getenv("DD_REDIS_OBFUSCATE_ARGS"); v == "true" {
+		c.RedisObfuscateArgs = true
+	} else if v == "false" {
+		c.RedisObfuscateArgs = false
+	}
+
+	// DD_SAMPLING_RULE_<NAME> defines sampling rules
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		prefix := "DD_SAMPLING_RULE_"
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) || len(parts[0]) == len(prefix) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(parts[0], prefix))
+		args, err := splitString(parts[1], ',')
+		if err != nil {
+			log.Errorf("Failed to parse %s: %v", parts[0], err)
+			continue
+		}
+		rule, err := parseSamplingRule(name, args)
+		if err != nil {
+			log.Errorf("Invalid sampling rule %s: %v", parts[0], err)
+			continue
+		}
+		c.setSamplingRule(rule)
+	}
+
 	if v := os.Getenv("DD_DOGSTATSD_PORT"); v != "" {
 		port, err := strconv.Atoi(v)
 		if err != nil {
@@ -153,6 +422,39 @@ func getHostname() (string, error) {
 	return hostname, err
 }
 
+// APIEndpointKeys returns the endpoint/API key pairs payloads are sent to.
+// A single endpoint is used with every API key, a single API key with every
+// endpoint, otherwise endpoints and API keys are paired in order.
+func (c *AgentConfig) APIEndpointKeys() (endpoints, keys []string) {
+	endpoints, keys = c.APIEndpoints, c.APIKeys
+	if len(endpoints) == 0 {
+		endpoints = []string{c.APIEndpoint}
+	}
+	if len(keys) == 0 {
+		keys = []string{c.APIKey}
+	}
+
+	switch {
+	case len(endpoints) == 1 && len(keys) > 1:
+		endpoints = repeatString(endpoints[0], len(keys))
+	case len(keys) == 1 && len(endpoints) > 1:
+		keys = repeatString(keys[0], len(endpoints))
+	case len(keys) > len(endpoints):
+		keys = keys[:len(endpoints)]
+	case len(endpoints) > len(keys):
+		endpoints = endpoints[:len(keys)]
+	}
+	return endpoints, keys
+}
+
+func repeatString(s string, n int) []string {
+	l := make([]string, n)
+	for i := range l {
+		l[i] = s
+	}
+	return l
+}
+
 // NewDefaultAgentConfig returns a configuration with the default values
 func NewDefaultAgentConfig() *AgentConfig {
 	hostname, err := getHostname()
@@ -167,6 +469,19 @@ func NewDefaultAgentConfig() *AgentConfig {
 		APIKey:                  "",
 		APIEnabled:              true,
 		APIPayloadBufferMaxSize: 16 * 1024 * 1024,
+		APIPayloadSpoolMaxSize:  128 * 1024 * 1024,
+		APIPayloadVersion:       model.AgentPayloadV01,
+		APIPayloadMaxSize:       3 * 1024 * 1024,
+
+		APIRetryBackoffBase:        5 * time.Second,
+		APIRetryBackoffMax:         5 * time.Minute,
+		APICircuitBreakerThreshold: 5,
+		WriterShutdownTimeout:      5 * time.Second,
+
+		FileOutputFormat:         "ndjson",
+		FileOutputRotateSize:     64 * 1024 * 1024,
+		FileOutputRotateInterval: time.Hour,
+		FileOutputMaxFiles:       48,
 
 		BucketInterval:   time.Duration(10) * time.Second,
 		ExtraAggregators: []string{"http.status_code"},
@@ -176,10 +491,24 @@ func NewDefaultAgentConfig() *AgentConfig {
 		MaxTPS:           10,
 		PrioritySampling: true,
 
+		ErrorSampleBoost:   1.0,
+		LatencySampleBoost: 1.0,
+
+		SamplerStateInterval: time.Minute,
+		SamplerStateMaxAge:   10 * time.Minute,
+
+		RareSamplerCapacity: 10000,
+
+		SamplerDebugWindow: 5 * time.Minute,
+
 		ReceiverHost:    "localhost",
 		ReceiverPort:    8126,
 		ConnectionLimit: 2000,
 
+		ReceiverSocketPerm: 0722,
+
+		AssemblyMaxSpans: 100000,
+
 		StatsdHost: "localhost",
 		StatsdPort: 8125,
 
@@ -193,6 +522,10 @@ func NewDefaultAgentConfig() *AgentConfig {
 		WatchdogInterval: time.Minute,
 
 		Ignore: make(map[string][]string),
+
+		ObfuscationCreditCards:  true,
+		ObfuscationBearerTokens: true,
+		ObfuscationQuerySecrets: []string{"password", "passwd", "secret", "token", "access_token", "api_key", "apikey", "sig", "signature"},
 	}
 
 	return ac
@@ -219,6 +552,7 @@ func NewAgentConfig(conf *File, legacyConf *File) (*AgentConfig, error) {
 
 		if v := m.Key("api_key").Strings(","); len(v) != 0 {
 			c.APIKey = v[0]
+			c.APIKeys = v
 		} else {
 			log.Info("Failed to parse api_key from dd-agent config")
 		}
@@ -279,6 +613,129 @@ APM_CONF:
 		c.Ignore["resource"] = v
 	}
 
+	if section, err := conf.GetSection("trace.filters"); err == nil {
+		for _, key := range section.Keys() {
+			parts := strings.SplitN(key.Name(), ".", 2)
+			if len(parts) != 2 || (parts[0] != "allow" && parts[0] != "deny") {
+				log.Errorf("Invalid filter rule %q: rules must be named allow.<name> or deny.<name>", key.Name())
+				continue
+			}
+			conditions, err := splitString(key.String(), ',')
+			if err != nil {
+				log.Errorf("Failed to parse filter rule %q: %v", key.Name(), err)
+				continue
+			}
+			c.setFilterRule(FilterRule{Name: parts[1], Action: parts[0], Conditions: conditions})
+		}
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.obfuscation", "enabled", "")); v == "yes" || v == "true" {
+		c.ObfuscationEnabled = true
+	}
+
+	if v, e := conf.GetStrArray("trace.obfuscation", "remove_keys", ','); e == nil {
+		c.ObfuscationRemoveKeys = v
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.obfuscation", "credit_cards", "")); v == "no" || v == "false" {
+		c.ObfuscationCreditCards = false
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.obfuscation", "bearer_tokens", "")); v == "no" || v == "false" {
+		c.ObfuscationBearerTokens = false
+	}
+
+	// an empty list disables the detection of query string secrets
+	if v, e := conf.GetStrArray("trace.obfuscation", "query_secrets", ','); e == nil {
+		c.ObfuscationQuerySecrets = v
+	}
+
+	if section, err := conf.GetSection("trace.obfuscation"); err == nil {
+		for _, key := range section.Keys() {
+			name := strings.TrimPrefix(key.Name(), "rule.")
+			if name == key.Name() {
+				continue
+			}
+			args, err := splitString(key.String(), ',')
+			if err != nil || len(args) != 3 {
+				log.Errorf("Invalid obfuscation rule %q: it should be \"<key>\",\"<regexp>\",\"<replacement>\"", key.Name())
+				continue
+			}
+			c.ObfuscationRules = append(c.ObfuscationRules, ObfuscationRule{
+				Name:        name,
+				Key:         args[0],
+				Pattern:     args[1],
+				Replacement: args[2],
+			})
+		}
+	}
+
+	if v, e := conf.GetStrArray("trace.http_quantizer", "services", ','); e == nil {
+		c.HTTPQuantizerServices = v
+	}
+
+	if section, err := conf.GetSection("trace.http_quantizer"); err == nil {
+		for _, key := range section.Keys() {
+			name := strings.TrimPrefix(key.Name(), "pattern.")
+			if name == key.Name() {
+				continue
+			}
+			c.HTTPQuantizerPatterns = append(c.HTTPQuantizerPatterns, HTTPQuantizerPattern{Name: name, Pattern: key.String()})
+		}
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.redis_quantizer", "obfuscate_args", "")); v == "yes" || v == "true" {
+		c.RedisObfuscateArgs = true
+	}
+
+	if section, err := conf.GetSection("trace.sampling_rules"); err == nil {
+		for _, key := range section.Keys() {
+			name := strings.TrimPrefix(key.Name(), "rule.")
+			if name == key.Name() || name == "" {
+				log.Errorf("Invalid sampling rule %q: rules must be named rule.<name>", key.Name())
+				continue
+			}
+			args, err := splitString(key.String(), ',')
+			if err != nil {
+				log.Errorf("Failed to parse sampling rule %q: %v", key.Name(), err)
+				continue
+			}
+			rule, err := parseSamplingRule(name, args)
+			if err != nil {
+				log.Errorf("Invalid sampling rule %q: %v", key.Name(), err)
+				continue
+			}
+			c.setSamplingRule(rule)
+		}
+	}
+
+	if section, err := conf.GetSection("trace.tps_budgets"); err == nil {
+		for _, key := range section.Keys() {
+			tps, err := key.Float64()
+			if err != nil || tps < 0 {
+				log.Errorf("Invalid TPS budget %q: it should be a positive number", key.Name())
+				continue
+			}
+			parts := strings.SplitN(key.Name(), ".", 2)
+			switch {
+			case key.Name() == "default":
+				c.TPSBudgets.Default = tps
+			case len(parts) == 2 && parts[0] == "env" && parts[1] != "":
+				if c.TPSBudgets.Envs == nil {
+					c.TPSBudgets.Envs = make(map[string]float64)
+				}
+				c.TPSBudgets.Envs[model.NormalizeTag(parts[1])] = tps
+			case len(parts) == 2 && parts[0] == "service" && parts[1] != "":
+				if c.TPSBudgets.Services == nil {
+					c.TPSBudgets.Services = make(map[string]float64)
+				}
+				c.TPSBudgets.Services[model.NormalizeTag(parts[1])] = tps
+			default:
+				log.Errorf("Invalid TPS budget %q: budgets must be named default, env.<env> or service.<service>", key.Name())
+			}
+		}
+	}
+
 	if v := strings.ToLower(conf.GetDefault("trace.config", "log_throttling", "")); v == "no" || v == "false" {
 		c.LogThrottlingEnabled = false
 	}
@@ -289,6 +746,7 @@ APM_CONF:
 			vals[i] = strings.TrimSpace(vals[i])
 		}
 		c.APIKey = vals[0]
+		c.APIKeys = vals
 	}
 
 	if v, _ := conf.Get("trace.api", "endpoint"); v != "" {
@@ -297,14 +755,79 @@ APM_CONF:
 			vals[i] = strings.TrimSpace(vals[i])
 		}
 
-		// Takes the first endpoint
 		c.APIEndpoint = vals[0]
+		c.APIEndpoints = vals
 	}
 
 	if v, e := conf.GetInt("trace.api", "payload_buffer_max_size"); e == nil {
 		c.APIPayloadBufferMaxSize = v
 	}
 
+	if v, e := conf.GetInt("trace.api", "payload_max_size"); e == nil {
+		c.APIPayloadMaxSize = v
+	}
+
+	if v, e := conf.GetInt("trace.api", "payload_max_traces"); e == nil {
+		c.APIPayloadMaxTraces = v
+	}
+
+	if v, _ := conf.Get("trace.api", "payload_version"); v != "" {
+		switch pv := model.AgentPayloadVersion(v); pv {
+		case model.AgentPayloadV01, model.AgentPayloadV02:
+			c.APIPayloadVersion = pv
+		default:
+			log.Errorf("Unknown payload_version %q, using %s", v, c.APIPayloadVersion)
+		}
+	}
+
+	if v, e := conf.GetInt("trace.api", "retry_backoff_base_seconds"); e == nil {
+		c.APIRetryBackoffBase = time.Duration(v) * time.Second
+	}
+
+	if v, e := conf.GetInt("trace.api", "retry_backoff_max_seconds"); e == nil {
+		c.APIRetryBackoffMax = time.Duration(v) * time.Second
+	}
+
+	if v, e := conf.GetInt("trace.api", "circuit_breaker_threshold"); e == nil {
+		c.APICircuitBreakerThreshold = v
+	}
+
+	if v, e := conf.GetInt("trace.api", "shutdown_timeout_seconds"); e == nil {
+		c.WriterShutdownTimeout = time.Duration(v) * time.Second
+	}
+
+	if v, _ := conf.Get("trace.api", "payload_spool_dir"); v != "" {
+		c.APIPayloadSpoolDir = v
+	}
+
+	if v, e := conf.GetInt("trace.api", "payload_spool_max_size"); e == nil {
+		c.APIPayloadSpoolMaxSize = v
+	}
+
+	if v := strings.ToLower(conf.GetDefault("trace.api", "enabled", "")); v == "no" || v == "false" {
+		c.APIEnabled = false
+	}
+
+	if v, _ := conf.Get("trace.file", "dir"); v != "" {
+		c.FileOutputDir = v
+	}
+
+	if v, _ := conf.Get("trace.file", "format"); v != "" {
+		c.FileOutputFormat = v
+	}
+
+	if v, e := conf.GetInt("trace.file", "rotate_size"); e == nil {
+		c.FileOutputRotateSize = v
+	}
+
+	if v, e := conf.GetInt("trace.file", "rotate_interval_seconds"); e == nil {
+		c.FileOutputRotateInterval = time.Duration(v) * time.Second
+	}
+
+	if v, e := conf.GetInt("trace.file", "max_files"); e == nil {
+		c.FileOutputMaxFiles = v
+	}
+
 	if v, e := conf.GetInt("trace.concentrator", "bucket_size_seconds"); e == nil {
 		c.BucketInterval = time.Duration(v) * time.Second
 	}
@@ -327,6 +850,41 @@ APM_CONF:
 	if v := strings.ToLower(conf.GetDefault("trace.sampler", "priority_sampling", "")); v == "yes" || v == "true" {
 		c.PrioritySampling = true
 	}
+	if v, e := conf.GetFloat("trace.sampler", "error_boost"); e == nil {
+		if v >= 1 {
+			c.ErrorSampleBoost = v
+		} else {
+			log.Errorf("Invalid error_boost %v: it should be at least 1", v)
+		}
+	}
+	if v, e := conf.GetFloat("trace.sampler", "latency_boost"); e == nil {
+		if v >= 1 {
+			c.LatencySampleBoost = v
+		} else {
+			log.Errorf("Invalid latency_boost %v: it should be at least 1", v)
+		}
+	}
+	if v, _ := conf.Get("trace.sampler", "state_file"); v != "" {
+		c.SamplerStateFile = v
+	}
+	if v, e := conf.GetInt("trace.sampler", "state_interval_seconds"); e == nil && v > 0 {
+		c.SamplerStateInterval = time.Duration(v) * time.Second
+	}
+	if v, e := conf.GetInt("trace.sampler", "state_max_age_seconds"); e == nil && v > 0 {
+		c.SamplerStateMaxAge = time.Duration(v) * time.Second
+	}
+	if v, e := conf.GetInt("trace.sampler", "rare_window_seconds"); e == nil && v >= 0 {
+		c.RareSamplerWindow = time.Duration(v) * time.Second
+	}
+	if v, e := conf.GetInt("trace.sampler", "rare_max_signatures"); e == nil && v > 0 {
+		c.RareSamplerCapacity = v
+	}
+	if v, e := conf.GetInt("trace.sampler", "debug_decisions"); e == nil && v >= 0 {
+		c.SamplerDebugDecisions = v
+	}
+	if v, e := conf.GetInt("trace.sampler", "debug_window_seconds"); e == nil && v > 0 {
+		c.SamplerDebugWindow = time.Duration(v) * time.Second
+	}
 
 	if v, e := conf.GetInt("trace.receiver", "receiver_port"); e == nil {
 		c.ReceiverPort = v
@@ -340,6 +898,26 @@ APM_CONF:
 		c.ReceiverTimeout = v
 	}
 
+	if v, _ := conf.Get("trace.receiver", "receiver_socket"); v != "" {
+		c.ReceiverSocket = v
+	}
+
+	if v, _ := conf.Get("trace.receiver", "receiver_socket_permissions"); v != "" {
+		if perm, err := strconv.ParseUint(v, 8, 32); err == nil {
+			c.ReceiverSocketPerm = os.FileMode(perm) & os.ModePerm
+		} else {
+			log.Errorf("Failed to parse receiver_socket_permissions: it should be an octal file mode such as 0722")
+		}
+	}
+
+	if v, e := conf.GetInt("trace.receiver", "assembly_window_seconds"); e == nil && v >= 0 {
+		c.AssemblyWindow = time.Duration(v) * time.Second
+	}
+
+	if v, e := conf.GetInt("trace.receiver", "assembly_max_spans"); e == nil && v > 0 {
+		c.AssemblyMaxSpans = v
+	}
+
 	if v, e := conf.GetFloat("trace.watchdog", "max_memory"); e == nil {
 		c.MaxMemory = v
 	}
@@ -365,5 +943,9 @@ ENV_CONF:
 		return c, errors.New("you must specify an API Key, either via a configuration file or the DD_API_KEY env var")
 	}
 
+	if n, k := len(c.APIEndpoints), len(c.APIKeys); n > 1 && k > 1 && n != k {
+		return c, fmt.Errorf("you must specify one API key per endpoint, got %d endpoints and %d API keys", n, k)
+	}
+
 	return c, nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -112,6 +113,46 @@ func TestDDAgentMultiAPIKeys(t *testing.T) {
 	assert.Equal("foo", agentConfig.APIKey)
 }
 
+func TestAPIEndpointKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	legacy, _ := ini.Load([]byte(strings.Join([]string{
+		"[trace.api]",
+		"api_key = key1, key2",
+		"endpoint = https://prod, https://staging",
+	}, "\n")))
+	agentConfig, err := NewAgentConfig(nil, &File{instance: legacy, Path: "whatever"})
+	assert.Nil(err)
+	assert.Equal("key1", agentConfig.APIKey)
+	assert.Equal("https://prod", agentConfig.APIEndpoint)
+
+	urls, keys := agentConfig.APIEndpointKeys()
+	assert.Equal([]string{"https://prod", "https://staging"}, urls)
+	assert.Equal([]string{"key1", "key2"}, keys)
+
+	// a single endpoint is used with every key
+	agentConfig.APIEndpoints = nil
+	urls, keys = agentConfig.APIEndpointKeys()
+	assert.Equal([]string{"https://prod", "https://prod"}, urls)
+	assert.Equal([]string{"key1", "key2"}, keys)
+
+	// and a single key with every endpoint
+	agentConfig.APIEndpoints = []string{"https://prod", "https://staging"}
+	agentConfig.APIKeys = []string{"key1"}
+	urls, keys = agentConfig.APIEndpointKeys()
+	assert.Equal([]string{"https://prod", "https://staging"}, urls)
+	assert.Equal([]string{"key1", "key1"}, keys)
+
+	// mismatching lists are refused
+	legacy, _ = ini.Load([]byte(strings.Join([]string{
+		"[trace.api]",
+		"api_key = key1, key2",
+		"endpoint = https://a, https://b, https://c",
+	}, "\n")))
+	_, err = NewAgentConfig(nil, &File{instance: legacy, Path: "whatever"})
+	assert.NotNil(err)
+}
+
 func TestDDAgentConfigWithLegacy(t *testing.T) {
 	assert := assert.New(t)
 
@@ -185,6 +226,303 @@ func TestEmptyExtraAggregatorsFromConfig(t *testing.T) {
 	assert.Equal([]string{"http.status_code"}, agentConfig.ExtraAggregators)
 }
 
+func TestReceiverSocketConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.receiver]",
+		"receiver_socket = /var/run/datadog/apm.socket",
+		"receiver_socket_permissions = 0700",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal("/var/run/datadog/apm.socket", agentConfig.ReceiverSocket)
+	assert.Equal(os.FileMode(0700), agentConfig.ReceiverSocketPerm)
+
+	os.Setenv("DD_RECEIVER_SOCKET", "/tmp/apm.socket")
+	defer os.Unsetenv("DD_RECEIVER_SOCKET")
+
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal("/tmp/apm.socket", agentConfig.ReceiverSocket)
+}
+
+func TestFilterRulesConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.filters]",
+		`deny.health = "meta.http.user_agent:^kube-probe/", "name:http.request"`,
+		"allow.web = service:^web$",
+		"drop.invalid = service:web",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]FilterRule{
+		{Name: "health", Action: "deny", Conditions: []string{"meta.http.user_agent:^kube-probe/", "name:http.request"}},
+		{Name: "web", Action: "allow", Conditions: []string{"service:^web$"}},
+	}, agentConfig.FilterRules)
+
+	os.Setenv("DD_FILTER_ALLOW_WEB", "service:^www$")
+	defer os.Unsetenv("DD_FILTER_ALLOW_WEB")
+	os.Setenv("DD_FILTER_DENY_FAST", "duration<1ms,type:cache")
+	defer os.Unsetenv("DD_FILTER_DENY_FAST")
+
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal([]FilterRule{
+		{Name: "health", Action: "deny", Conditions: []string{"meta.http.user_agent:^kube-probe/", "name:http.request"}},
+		{Name: "web", Action: "allow", Conditions: []string{"service:^www$"}},
+		{Name: "fast", Action: "deny", Conditions: []string{"duration<1ms", "type:cache"}},
+	}, agentConfig.FilterRules)
+}
+
+func TestSampleBoostConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(1.0, agentConfig.ErrorSampleBoost)
+	assert.Equal(1.0, agentConfig.LatencySampleBoost)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampler]",
+		"error_boost = 5",
+		"latency_boost = 0.5",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(5.0, agentConfig.ErrorSampleBoost)
+	assert.Equal(1.0, agentConfig.LatencySampleBoost)
+}
+
+func TestSamplerStateConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal("", agentConfig.SamplerStateFile)
+	assert.Equal(time.Minute, agentConfig.SamplerStateInterval)
+	assert.Equal(10*time.Minute, agentConfig.SamplerStateMaxAge)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampler]",
+		"state_file = /var/lib/datadog/trace-agent/sampler.json",
+		"state_interval_seconds = 30",
+		"state_max_age_seconds = 300",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal("/var/lib/datadog/trace-agent/sampler.json", agentConfig.SamplerStateFile)
+	assert.Equal(30*time.Second, agentConfig.SamplerStateInterval)
+	assert.Equal(5*time.Minute, agentConfig.SamplerStateMaxAge)
+
+	os.Setenv("DD_SAMPLER_STATE_FILE", "/tmp/sampler.json")
+	defer os.Unsetenv("DD_SAMPLER_STATE_FILE")
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal("/tmp/sampler.json", agentConfig.SamplerStateFile)
+}
+
+func TestRareSamplerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(time.Duration(0), agentConfig.RareSamplerWindow)
+	assert.Equal(10000, agentConfig.RareSamplerCapacity)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampler]",
+		"rare_window_seconds = 60",
+		"rare_max_signatures = 500",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(time.Minute, agentConfig.RareSamplerWindow)
+	assert.Equal(500, agentConfig.RareSamplerCapacity)
+}
+
+func TestSamplerDebugConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(0, agentConfig.SamplerDebugDecisions)
+	assert.Equal(5*time.Minute, agentConfig.SamplerDebugWindow)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampler]",
+		"debug_decisions = 1000",
+		"debug_window_seconds = 60",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(1000, agentConfig.SamplerDebugDecisions)
+	assert.Equal(time.Minute, agentConfig.SamplerDebugWindow)
+}
+
+func TestAssemblyConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	agentConfig := NewDefaultAgentConfig()
+	assert.Equal(time.Duration(0), agentConfig.AssemblyWindow)
+	assert.Equal(100000, agentConfig.AssemblyMaxSpans)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.receiver]",
+		"assembly_window_seconds = 5",
+		"assembly_max_spans = 5000",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal(5*time.Second, agentConfig.AssemblyWindow)
+	assert.Equal(5000, agentConfig.AssemblyMaxSpans)
+}
+
+func TestTPSBudgetsConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.tps_budgets]",
+		"default = 20",
+		"env.Prod = 50",
+		"service.checkout = 10.5",
+		"service.web = -1",
+		"host.foo = 3",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal(TPSBudgets{
+		Default:  20,
+		Envs:     map[string]float64{"prod": 50},
+		Services: map[string]float64{"checkout": 10.5},
+	}, agentConfig.TPSBudgets)
+}
+
+func TestSamplingRulesConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.sampling_rules]",
+		`rule.checkout = "service:^checkout$", "env:prod", "rate=0.5"`,
+		`rule.gold = "meta.customer.tier:gold", "max_tps=20"`,
+		`rule.bad_rate = "service:web", "rate=2"`,
+		`rule.no_conditions = "rate=0.1"`,
+		"invalid = service:web",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]SamplingRule{
+		{Name: "checkout", Conditions: []string{"service:^checkout$", "env:prod"}, Rate: 0.5},
+		{Name: "gold", Conditions: []string{"meta.customer.tier:gold"}, Rate: 1, MaxTPS: 20},
+	}, agentConfig.SamplingRules)
+
+	os.Setenv("DD_SAMPLING_RULE_CHECKOUT", "service:^checkout$,rate=0.1")
+	defer os.Unsetenv("DD_SAMPLING_RULE_CHECKOUT")
+	os.Setenv("DD_SAMPLING_RULE_HEALTH", "resource:^GET /health,rate=0")
+	defer os.Unsetenv("DD_SAMPLING_RULE_HEALTH")
+
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal([]SamplingRule{
+		{Name: "checkout", Conditions: []string{"service:^checkout$"}, Rate: 0.1},
+		{Name: "gold", Conditions: []string{"meta.customer.tier:gold"}, Rate: 1, MaxTPS: 20},
+		{Name: "health", Conditions: []string{"resource:^GET /health"}, Rate: 0},
+	}, agentConfig.SamplingRules)
+}
+
+func TestObfuscationConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.obfuscation]",
+		"enabled = yes",
+		"remove_keys = http.request.headers.cookie, user.email",
+		"bearer_tokens = no",
+		"query_secrets =",
+		`rule.emails = "*", "[\w.+-]+@[\w-]+\.[\w.]+", "?"`,
+		`rule.users = "http.url", "/users/\d+"`,
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.True(agentConfig.ObfuscationEnabled)
+	assert.Equal([]string{"http.request.headers.cookie", "user.email"}, agentConfig.ObfuscationRemoveKeys)
+	assert.True(agentConfig.ObfuscationCreditCards)
+	assert.False(agentConfig.ObfuscationBearerTokens)
+	assert.Empty(agentConfig.ObfuscationQuerySecrets)
+	assert.Equal([]ObfuscationRule{
+		{Name: "emails", Key: "*", Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`, Replacement: "?"},
+	}, agentConfig.ObfuscationRules)
+
+	os.Setenv("DD_OBFUSCATION_ENABLED", "false")
+	defer os.Unsetenv("DD_OBFUSCATION_ENABLED")
+	os.Setenv("DD_OBFUSCATION_REMOVE_KEYS", "password")
+	defer os.Unsetenv("DD_OBFUSCATION_REMOVE_KEYS")
+
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.False(agentConfig.ObfuscationEnabled)
+	assert.Equal([]string{"password"}, agentConfig.ObfuscationRemoveKeys)
+
+	// the query secrets have defaults
+	assert.NotEmpty(NewDefaultAgentConfig().ObfuscationQuerySecrets)
+}
+
+func TestQuantizerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dd, _ := ini.Load([]byte(strings.Join([]string{
+		"[Main]",
+		"api_key = apikey_12",
+		"[trace.http_quantizer]",
+		"services = web, api",
+		`pattern.sku = [A-Z]{3}-\d+`,
+		`pattern.locale = [a-z]{2}_[A-Z]{2}`,
+		"[trace.redis_quantizer]",
+		"obfuscate_args = true",
+	}, "\n")))
+
+	conf := &File{instance: dd, Path: "whatever"}
+	agentConfig, _ := NewAgentConfig(conf, nil)
+	assert.Equal([]string{"web", "api"}, agentConfig.HTTPQuantizerServices)
+	assert.Equal([]HTTPQuantizerPattern{
+		{Name: "sku", Pattern: `[A-Z]{3}-\d+`},
+		{Name: "locale", Pattern: `[a-z]{2}_[A-Z]{2}`},
+	}, agentConfig.HTTPQuantizerPatterns)
+	assert.True(agentConfig.RedisObfuscateArgs)
+
+	os.Setenv("DD_HTTP_QUANTIZER_SERVICES", "checkout")
+	defer os.Unsetenv("DD_HTTP_QUANTIZER_SERVICES")
+	os.Setenv("DD_REDIS_OBFUSCATE_ARGS", "false")
+	defer os.Unsetenv("DD_REDIS_OBFUSCATE_ARGS")
+
+	agentConfig, _ = NewAgentConfig(conf, nil)
+	assert.Equal([]string{"checkout"}, agentConfig.HTTPQuantizerServices)
+	assert.False(agentConfig.RedisObfuscateArgs)
+}
+
 func TestConfigNewIfExists(t *testing.T) {
 	// The file does not exist: no error returned
 	conf, err := NewIfExists("/does-not-exist")
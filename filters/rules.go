@@ -0,0 +1,193 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// allowlistRuleName tags traces rejected because they match none of the
+// allow rules
+const allowlistRuleName = "allowlist"
+
+// ruleStatsInterval is how often rule counters are reported
+const ruleStatsInterval = 10 * time.Second
+
+// condition tells if a span matches one of the conditions of a rule
+type condition func(*model.Span) bool
+
+// rule is a named set of conditions, all of which must match
+type rule struct {
+	name       string
+	conditions []condition
+	rejected   int64 // the number of traces rejected by this rule, atomic
+}
+
+// match returns true if s matches all the conditions of the rule
+func (r *rule) match(s *model.Span) bool {
+	for _, c := range r.conditions {
+		if !c(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleFilter implements a filter over attributes of the root span of
+// traces. A trace is rejected if it matches any deny rule or, when there
+// are allow rules, if it matches none of them.
+type ruleFilter struct {
+	allow []*rule
+	deny  []*rule
+
+	allowlist rule // counts the traces matching no allow rule
+}
+
+// Keep returns true if the span matches no deny rule and, if there are
+// allow rules, at least one of them
+func (f *ruleFilter) Keep(s *model.Span) bool {
+	for _, r := range f.deny {
+		if r.match(s) {
+			atomic.AddInt64(&r.rejected, 1)
+			return false
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, r := range f.allow {
+		if r.match(s) {
+			return true
+		}
+	}
+	atomic.AddInt64(&f.allowlist.rejected, 1)
+	return false
+}
+
+// logStats periodically reports the number of traces each rule rejected
+func (f *ruleFilter) logStats() {
+	rules := append([]*rule{&f.allowlist}, f.deny...)
+	for range time.Tick(ruleStatsInterval) {
+		for _, r := range rules {
+			if n := atomic.SwapInt64(&r.rejected, 0); n > 0 {
+				statsd.Client.Count("datadog.trace_agent.filters.rejected", n, []string{"rule:" + r.name}, 1)
+			}
+		}
+	}
+}
+
+func newRuleFilter(conf *config.AgentConfig) Filter {
+	f := &ruleFilter{allowlist: rule{name: allowlistRuleName}}
+
+	for _, fr := range conf.FilterRules {
+		r, err := compileRule(fr)
+		if err != nil {
+			log.Errorf("invalid filter rule %s.%s: %v", fr.Action, fr.Name, err)
+			continue
+		}
+		switch fr.Action {
+		case "allow":
+			f.allow = append(f.allow, r)
+		case "deny":
+			f.deny = append(f.deny, r)
+		default:
+			log.Errorf("invalid filter rule %s.%s: unknown action %q", fr.Action, fr.Name, fr.Action)
+		}
+	}
+
+	if len(f.allow) > 0 || len(f.deny) > 0 {
+		log.Infof("filtering traces with %d allow rules and %d deny rules", len(f.allow), len(f.deny))
+		go func() {
+			defer watchdog.LogOnPanic()
+			f.logStats()
+		}()
+	}
+	return f
+}
+
+// compileRule compiles the conditions of a configured rule. Conditions are:
+//   - service:<regexp>, name:<regexp>, resource:<regexp>, type:<regexp>
+//   - meta.<key>:<regexp>, the tag must be set and match
+//   - error:true or error:false
+//   - duration>10ms or duration<1s
+func compileRule(fr config.FilterRule) (*rule, error) {
+	if len(fr.Conditions) == 0 {
+		return nil, fmt.Errorf("no conditions")
+	}
+
+	r := &rule{name: fr.Name}
+	for _, expr := range fr.Conditions {
+		c, err := compileCondition(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, err
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	return r, nil
+}
+
+func compileCondition(expr string) (condition, error) {
+	if strings.HasPrefix(expr, "duration") {
+		op := strings.TrimPrefix(expr, "duration")
+		if op == "" || (op[0] != '<' && op[0] != '>') {
+			return nil, fmt.Errorf("invalid duration condition %q", expr)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(op[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration condition %q: %v", expr, err)
+		}
+		if op[0] == '<' {
+			return func(s *model.Span) bool { return s.Duration < d.Nanoseconds() }, nil
+		}
+		return func(s *model.Span) bool { return s.Duration > d.Nanoseconds() }, nil
+	}
+
+	parts := strings.SplitN(expr, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid condition %q", expr)
+	}
+	field, value := parts[0], parts[1]
+
+	if field == "error" {
+		isError, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error condition %q", expr)
+		}
+		return func(s *model.Span) bool { return (s.Error != 0) == isError }, nil
+	}
+
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp in %q: %v", expr, err)
+	}
+
+	switch field {
+	case "service":
+		return func(s *model.Span) bool { return re.MatchString(s.Service) }, nil
+	case "name":
+		return func(s *model.Span) bool { return re.MatchString(s.Name) }, nil
+	case "resource":
+		return func(s *model.Span) bool { return re.MatchString(s.Resource) }, nil
+	case "type":
+		return func(s *model.Span) bool { return re.MatchString(s.Type) }, nil
+	}
+
+	if key := strings.TrimPrefix(field, "meta."); key != field && key != "" {
+		return func(s *model.Span) bool {
+			v, ok := s.Meta[key]
+			return ok && re.MatchString(v)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
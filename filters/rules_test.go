@@ -0,0 +1,88 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRuleFilter(rules ...config.FilterRule) *ruleFilter {
+	c := config.NewDefaultAgentConfig()
+	c.FilterRules = rules
+	return newRuleFilter(c).(*ruleFilter)
+}
+
+func TestRuleConditions(t *testing.T) {
+	span := &model.Span{
+		Service:  "web",
+		Name:     "http.request",
+		Resource: "GET /health",
+		Type:     "http",
+		Duration: 5e6,
+		Meta:     map[string]string{"http.user_agent": "kube-probe/1.7"},
+	}
+
+	tests := []struct {
+		conditions []string
+		match      bool
+	}{
+		{[]string{"service:^web$"}, true},
+		{[]string{"service:^db$"}, false},
+		{[]string{"name:^http\\."}, true},
+		{[]string{"resource:/health$"}, true},
+		{[]string{"type:sql"}, false},
+		{[]string{"meta.http.user_agent:^kube-probe/"}, true},
+		{[]string{"meta.http.url:."}, false},
+		{[]string{"error:false"}, true},
+		{[]string{"error:true"}, false},
+		{[]string{"duration<10ms"}, true},
+		{[]string{"duration>10ms"}, false},
+		{[]string{"duration>1ms", "service:web", "meta.http.user_agent:kube"}, true},
+		{[]string{"duration>1ms", "service:db"}, false},
+	}
+
+	for _, test := range tests {
+		f := newTestRuleFilter(config.FilterRule{Name: "test", Action: "deny", Conditions: test.conditions})
+		assert.Len(t, f.deny, 1)
+		assert.Equal(t, !test.match, f.Keep(span), "%v", test.conditions)
+	}
+}
+
+func TestRuleAllowDeny(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newTestRuleFilter(
+		config.FilterRule{Name: "checkout", Action: "allow", Conditions: []string{"service:^checkout$"}},
+		config.FilterRule{Name: "billing", Action: "allow", Conditions: []string{"service:^billing$"}},
+		config.FilterRule{Name: "health", Action: "deny", Conditions: []string{"resource:/health"}},
+	)
+
+	assert.True(f.Keep(&model.Span{Service: "checkout", Resource: "POST /cart"}))
+	assert.True(f.Keep(&model.Span{Service: "billing", Resource: "POST /invoice"}))
+	// deny rules win
+	assert.False(f.Keep(&model.Span{Service: "checkout", Resource: "GET /health"}))
+	// not allowed
+	assert.False(f.Keep(&model.Span{Service: "search", Resource: "GET /"}))
+	assert.False(f.Keep(&model.Span{Service: "search", Resource: "GET /health"}))
+
+	assert.Equal(int64(2), f.deny[0].rejected)
+	assert.Equal(int64(1), f.allowlist.rejected)
+}
+
+func TestRuleCompilationFailure(t *testing.T) {
+	f := newTestRuleFilter(
+		config.FilterRule{Name: "regexp", Action: "deny", Conditions: []string{"service:[123"}},
+		config.FilterRule{Name: "field", Action: "deny", Conditions: []string{"foo:bar"}},
+		config.FilterRule{Name: "duration", Action: "deny", Conditions: []string{"duration>ten"}},
+		config.FilterRule{Name: "error", Action: "deny", Conditions: []string{"error:maybe"}},
+		config.FilterRule{Name: "empty", Action: "deny"},
+		config.FilterRule{Name: "action", Action: "drop", Conditions: []string{"service:web"}},
+	)
+
+	// invalid rules are ignored
+	assert.Len(t, f.deny, 0)
+	assert.Len(t, f.allow, 0)
+	assert.True(t, f.Keep(&model.Span{Service: "web"}))
+}
@@ -0,0 +1,384 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OTLPDefaultService is the service used for OpenTelemetry spans
+	// whose resource does not carry a service.name attribute.
+	OTLPDefaultService = "unknown_service"
+)
+
+// OpenTelemetry span kinds, as defined in opentelemetry-proto.
+const (
+	otlpSpanKindUnspecified = 0
+	otlpSpanKindInternal    = 1
+	otlpSpanKindServer      = 2
+	otlpSpanKindClient      = 3
+	otlpSpanKindProducer    = 4
+	otlpSpanKindConsumer    = 5
+)
+
+// otlpStatusCodeError is the OpenTelemetry status code of a failed span.
+const otlpStatusCodeError = 2
+
+var otlpSpanKindNames = map[int]string{
+	otlpSpanKindUnspecified: "unspecified",
+	otlpSpanKindInternal:    "internal",
+	otlpSpanKindServer:      "server",
+	otlpSpanKindClient:      "client",
+	otlpSpanKindProducer:    "producer",
+	otlpSpanKindConsumer:    "consumer",
+}
+
+// OTLPExportRequest is the subset of an OpenTelemetry
+// ExportTraceServiceRequest the agent understands. It can be decoded from
+// both the OTLP/HTTP JSON encoding (encoding/json) and the protobuf one
+// (UnmarshalProto).
+type OTLPExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	// InstrumentationLibrarySpans is the pre-1.0 name of ScopeSpans,
+	// still sent by older SDKs.
+	InstrumentationLibrarySpans []otlpScopeSpans `json:"instrumentationLibrarySpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope                  otlpScope  `json:"scope"`
+	InstrumentationLibrary otlpScope  `json:"instrumentationLibrary"`
+	Spans                  []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           otlpID         `json:"traceId"`
+	SpanID            otlpID         `json:"spanId"`
+	ParentSpanID      otlpID         `json:"parentSpanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano otlpUint64     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpUint64     `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Events            []otlpEvent    `json:"events"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	Name       string         `json:"name"`
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue holds one of the typed values an attribute can have. Only
+// one of the pointers is set at a time.
+type otlpAnyValue struct {
+	StringValue *string           `json:"stringValue,omitempty"`
+	BoolValue   *bool             `json:"boolValue,omitempty"`
+	IntValue    *otlpInt64        `json:"intValue,omitempty"`
+	DoubleValue *float64          `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte            `json:"bytesValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValueList struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpID is a trace or span ID. OTLP/JSON encodes them as hex strings.
+type otlpID []byte
+
+// UnmarshalJSON decodes a hex-encoded ID.
+func (id *otlpID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP id %q: %v", s, err)
+	}
+	*id = b
+	return nil
+}
+
+// uint64 returns the lower 64 bits of the ID, which is how 128-bit
+// OpenTelemetry trace IDs are folded into our 64-bit ones.
+func (id otlpID) uint64() uint64 {
+	if len(id) >= 8 {
+		return binary.BigEndian.Uint64(id[len(id)-8:])
+	}
+	var buf [8]byte
+	copy(buf[8-len(id):], id)
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// otlpUint64 is a 64-bit integer which OTLP/JSON encodes either as a
+// number or as a decimal string.
+type otlpUint64 uint64
+
+// UnmarshalJSON decodes a number or a string holding a number.
+func (n *otlpUint64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP uint64 %s: %v", data, err)
+	}
+	*n = otlpUint64(v)
+	return nil
+}
+
+// otlpInt64 is the signed version of otlpUint64.
+type otlpInt64 int64
+
+// UnmarshalJSON decodes a number or a string holding a number.
+func (n *otlpInt64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP int64 %s: %v", data, err)
+	}
+	*n = otlpInt64(v)
+	return nil
+}
+
+// String returns a string representation of the value, used when it ends
+// up in Meta.
+func (v *otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64)
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case v.ArrayValue != nil:
+		vals := make([]string, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			vals[i] = v.ArrayValue.Values[i].String()
+		}
+		b, _ := json.Marshal(vals)
+		return string(b)
+	case v.KvlistValue != nil:
+		vals := make(map[string]string, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			vals[kv.Key] = kv.Value.String()
+		}
+		b, _ := json.Marshal(vals)
+		return string(b)
+	}
+	return ""
+}
+
+// number returns the numeric value of int and double values.
+func (v *otlpAnyValue) number() (float64, bool) {
+	switch {
+	case v.IntValue != nil:
+		return float64(*v.IntValue), true
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, true
+	}
+	return 0, false
+}
+
+// setOTLPAttribute maps a single OpenTelemetry attribute onto a span: numbers
+// go to Metrics, anything else to Meta. A few well-known keys are renamed
+// to what the rest of the pipeline expects.
+func setOTLPAttribute(s *Span, kv *otlpKeyValue) {
+	switch kv.Key {
+	case "service.name":
+		s.Service = kv.Value.String()
+		return
+	case "deployment.environment":
+		s.Meta["env"] = kv.Value.String()
+		return
+	case "service.version":
+		s.Meta["version"] = kv.Value.String()
+		return
+	case "http.status_code", "http.response.status_code":
+		// the concentrator and the normalizer read it from Meta
+		s.Meta["http.status_code"] = kv.Value.String()
+		return
+	}
+
+	if n, ok := kv.Value.number(); ok {
+		s.Metrics[kv.Key] = n
+		return
+	}
+	s.Meta[kv.Key] = kv.Value.String()
+}
+
+// otlpSpanType guesses the Datadog span type from the span kind and
+// attributes, so that the quantizer and the UI treat it properly.
+func otlpSpanType(s *Span, kind int) string {
+	if system, ok := s.Meta["db.system"]; ok {
+		switch system {
+		case "redis", "memcached", "cassandra", "mongodb", "elasticsearch":
+			return system
+		default:
+			return "sql"
+		}
+	}
+	if _, ok := s.Meta["http.method"]; ok {
+		switch kind {
+		case otlpSpanKindServer:
+			return "web"
+		case otlpSpanKindClient:
+			return "http"
+		}
+	}
+	return ""
+}
+
+// otlpSpanResource guesses the resource of a span: the route for HTTP server
+// spans, the statement for database spans and the span name otherwise.
+func otlpSpanResource(s *Span, name string) string {
+	if method, ok := s.Meta["http.method"]; ok {
+		if route, ok := s.Meta["http.route"]; ok {
+			return method + " " + route
+		}
+	}
+	if statement, ok := s.Meta["db.statement"]; ok && statement != "" {
+		return statement
+	}
+	return name
+}
+
+// convert converts an OpenTelemetry span into a Span. The resource and
+// scope attributes are applied first so that span attributes take
+// precedence over them.
+func (sp *otlpSpan) convert(resource []otlpKeyValue, scope otlpScope) Span {
+	s := Span{
+		Service:  OTLPDefaultService,
+		TraceID:  sp.TraceID.uint64(),
+		SpanID:   sp.SpanID.uint64(),
+		ParentID: sp.ParentSpanID.uint64(),
+		Start:    int64(sp.StartTimeUnixNano),
+		Duration: int64(sp.EndTimeUnixNano) - int64(sp.StartTimeUnixNano),
+		Meta:     make(map[string]string, len(resource)+len(sp.Attributes)+2),
+		Metrics:  make(map[string]float64),
+	}
+
+	for i := range resource {
+		setOTLPAttribute(&s, &resource[i])
+	}
+	if scope.Name != "" {
+		s.Meta["otel.library.name"] = scope.Name
+	}
+	if scope.Version != "" {
+		s.Meta["otel.library.version"] = scope.Version
+	}
+	for i := range sp.Attributes {
+		setOTLPAttribute(&s, &sp.Attributes[i])
+	}
+
+	kind, ok := otlpSpanKindNames[sp.Kind]
+	if !ok {
+		kind = otlpSpanKindNames[otlpSpanKindUnspecified]
+	}
+	s.Meta["span.kind"] = kind
+	if len(sp.TraceID) > 8 {
+		s.Meta["otel.trace_id"] = hex.EncodeToString(sp.TraceID)
+	}
+
+	// Name is used in stats aggregation, so keep it low-cardinality and
+	// put the actual span name in the resource.
+	if scope.Name != "" {
+		s.Name = strings.ToLower(scope.Name) + "." + kind
+	} else {
+		s.Name = "otlp." + kind
+	}
+	if len(s.Name) > MaxNameLen {
+		s.Name = s.Name[len(s.Name)-MaxNameLen:]
+	}
+	s.Resource = otlpSpanResource(&s, sp.Name)
+	s.Type = otlpSpanType(&s, sp.Kind)
+
+	if sp.Status.Code == otlpStatusCodeError {
+		s.Error = 1
+		if sp.Status.Message != "" {
+			s.Meta["error.msg"] = sp.Status.Message
+		}
+	}
+	for _, e := range sp.Events {
+		if e.Name != "exception" {
+			continue
+		}
+		for _, kv := range e.Attributes {
+			switch kv.Key {
+			case "exception.type":
+				s.Meta["error.type"] = kv.Value.String()
+			case "exception.message":
+				s.Meta["error.msg"] = kv.Value.String()
+			case "exception.stacktrace":
+				s.Meta["error.stack"] = kv.Value.String()
+			}
+		}
+	}
+
+	return s
+}
+
+// Traces converts the request into Traces, grouping spans by trace ID.
+func (r *OTLPExportRequest) Traces() Traces {
+	var spans []Span
+	for _, rs := range r.ResourceSpans {
+		for _, ss := range append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...) {
+			scope := ss.Scope
+			if scope.Name == "" {
+				scope = ss.InstrumentationLibrary
+			}
+			for i := range ss.Spans {
+				spans = append(spans, ss.Spans[i].convert(rs.Resource.Attributes, scope))
+			}
+		}
+	}
+	return TracesFromSpans(spans)
+}
+
+// ResourceAttribute returns the value of the given resource attribute for
+// the first resource that has it, or an empty string.
+func (r *OTLPExportRequest) ResourceAttribute(key string) string {
+	for _, rs := range r.ResourceSpans {
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == key {
+				return kv.Value.String()
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,401 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file implements a minimal protobuf decoder for the messages of
+// opentelemetry-proto we need, so that we don't have to pull a full
+// protobuf runtime and generated code in the agent. Unknown fields are
+// skipped, as mandated by the protobuf specification.
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("protobuf: unexpected end of message")
+
+// otlpMaxValueDepth is how deep attribute values can nest arrays and lists,
+// so that crafted payloads cannot exhaust the stack.
+const otlpMaxValueDepth = 32
+
+var errProtoTooDeep = fmt.Errorf("protobuf: attribute values nested deeper than %d", otlpMaxValueDepth)
+
+// protoBuffer reads protobuf-encoded fields from a byte slice.
+type protoBuffer struct {
+	buf []byte
+}
+
+// done tells if the whole buffer has been consumed.
+func (b *protoBuffer) done() bool {
+	return len(b.buf) == 0
+}
+
+// varint reads a base 128 varint.
+func (b *protoBuffer) varint() (uint64, error) {
+	v, n := binary.Uvarint(b.buf)
+	if n <= 0 {
+		return 0, errProtoTruncated
+	}
+	b.buf = b.buf[n:]
+	return v, nil
+}
+
+// fixed64 reads a little-endian 64-bit value.
+func (b *protoBuffer) fixed64() (uint64, error) {
+	if len(b.buf) < 8 {
+		return 0, errProtoTruncated
+	}
+	v := binary.LittleEndian.Uint64(b.buf)
+	b.buf = b.buf[8:]
+	return v, nil
+}
+
+// bytes reads a length-delimited value. The returned slice shares memory
+// with the buffer.
+func (b *protoBuffer) bytes() ([]byte, error) {
+	l, err := b.varint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(b.buf)) {
+		return nil, errProtoTruncated
+	}
+	v := b.buf[:l]
+	b.buf = b.buf[l:]
+	return v, nil
+}
+
+// field reads the next field key.
+func (b *protoBuffer) field() (num int, wire int, err error) {
+	k, err := b.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(k >> 3), int(k & 7), nil
+}
+
+// skip skips the value of a field of the given wire type.
+func (b *protoBuffer) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = b.varint()
+	case wireFixed64:
+		_, err = b.fixed64()
+	case wireBytes:
+		_, err = b.bytes()
+	case wireFixed32:
+		if len(b.buf) < 4 {
+			return errProtoTruncated
+		}
+		b.buf = b.buf[4:]
+	default:
+		err = fmt.Errorf("protobuf: unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// protoMessage decodes the fields of data by calling fn for each of them.
+// fn must consume the value of the fields it handles and return false for
+// those it does not, which are then skipped.
+func protoMessage(data []byte, fn func(b *protoBuffer, num, wire int) (bool, error)) error {
+	b := &protoBuffer{buf: data}
+	for !b.done() {
+		num, wire, err := b.field()
+		if err != nil {
+			return err
+		}
+		handled, err := fn(b, num, wire)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			if err := b.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalProto decodes a protobuf-encoded ExportTraceServiceRequest.
+func (r *OTLPExportRequest) UnmarshalProto(data []byte) error {
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		if num != 1 || wire != wireBytes {
+			return false, nil
+		}
+		msg, err := b.bytes()
+		if err != nil {
+			return true, err
+		}
+		var rs otlpResourceSpans
+		if err := rs.unmarshalProto(msg); err != nil {
+			return true, err
+		}
+		r.ResourceSpans = append(r.ResourceSpans, rs)
+		return true, nil
+	})
+}
+
+func (rs *otlpResourceSpans) unmarshalProto(data []byte) error {
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch num {
+		case 1: // resource
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			return true, protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+				if num != 1 || wire != wireBytes {
+					return false, nil
+				}
+				return true, unmarshalProtoKeyValue(b, &rs.Resource.Attributes, 0)
+			})
+		case 2, 1000: // scope_spans, instrumentation_library_spans
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			var ss otlpScopeSpans
+			if err := ss.unmarshalProto(msg); err != nil {
+				return true, err
+			}
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+func (ss *otlpScopeSpans) unmarshalProto(data []byte) error {
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch num {
+		case 1: // scope, or instrumentation_library
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			return true, ss.Scope.unmarshalProto(msg)
+		case 2: // spans
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			var s otlpSpan
+			if err := s.unmarshalProto(msg); err != nil {
+				return true, err
+			}
+			ss.Spans = append(ss.Spans, s)
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+func (s *otlpScope) unmarshalProto(data []byte) error {
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		if wire != wireBytes || (num != 1 && num != 2) {
+			return false, nil
+		}
+		v, err := b.bytes()
+		if err != nil {
+			return true, err
+		}
+		if num == 1 {
+			s.Name = string(v)
+		} else {
+			s.Version = string(v)
+		}
+		return true, nil
+	})
+}
+
+func (s *otlpSpan) unmarshalProto(data []byte) error {
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		var err error
+		switch {
+		case num == 1 && wire == wireBytes: // trace_id
+			s.TraceID, err = b.bytes()
+		case num == 2 && wire == wireBytes: // span_id
+			s.SpanID, err = b.bytes()
+		case num == 4 && wire == wireBytes: // parent_span_id
+			s.ParentSpanID, err = b.bytes()
+		case num == 5 && wire == wireBytes: // name
+			var v []byte
+			v, err = b.bytes()
+			s.Name = string(v)
+		case num == 6 && wire == wireVarint: // kind
+			var v uint64
+			v, err = b.varint()
+			s.Kind = int(v)
+		case num == 7 && wire == wireFixed64: // start_time_unix_nano
+			var v uint64
+			v, err = b.fixed64()
+			s.StartTimeUnixNano = otlpUint64(v)
+		case num == 8 && wire == wireFixed64: // end_time_unix_nano
+			var v uint64
+			v, err = b.fixed64()
+			s.EndTimeUnixNano = otlpUint64(v)
+		case num == 9 && wire == wireBytes: // attributes
+			err = unmarshalProtoKeyValue(b, &s.Attributes, 0)
+		case num == 11 && wire == wireBytes: // events
+			var msg []byte
+			if msg, err = b.bytes(); err != nil {
+				return true, err
+			}
+			var e otlpEvent
+			err = protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+				if wire != wireBytes {
+					return false, nil
+				}
+				switch num {
+				case 2: // name
+					v, err := b.bytes()
+					e.Name = string(v)
+					return true, err
+				case 3: // attributes
+					return true, unmarshalProtoKeyValue(b, &e.Attributes, 0)
+				}
+				return false, nil
+			})
+			s.Events = append(s.Events, e)
+		case num == 15 && wire == wireBytes: // status
+			var msg []byte
+			if msg, err = b.bytes(); err != nil {
+				return true, err
+			}
+			err = protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+				switch {
+				case num == 2 && wire == wireBytes: // message
+					v, err := b.bytes()
+					s.Status.Message = string(v)
+					return true, err
+				case num == 3 && wire == wireVarint: // code
+					v, err := b.varint()
+					s.Status.Code = int(v)
+					return true, err
+				}
+				return false, nil
+			})
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+// unmarshalProtoKeyValue reads a KeyValue message, nested in depth values,
+// and appends it to kvs.
+func unmarshalProtoKeyValue(b *protoBuffer, kvs *[]otlpKeyValue, depth int) error {
+	msg, err := b.bytes()
+	if err != nil {
+		return err
+	}
+	var kv otlpKeyValue
+	err = protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+		if wire != wireBytes {
+			return false, nil
+		}
+		switch num {
+		case 1: // key
+			v, err := b.bytes()
+			kv.Key = string(v)
+			return true, err
+		case 2: // value
+			v, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			return true, kv.Value.unmarshalProto(v, depth)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	*kvs = append(*kvs, kv)
+	return nil
+}
+
+// unmarshalProto reads an AnyValue message, nested in depth values.
+func (v *otlpAnyValue) unmarshalProto(data []byte, depth int) error {
+	if depth >= otlpMaxValueDepth {
+		return errProtoTooDeep
+	}
+	return protoMessage(data, func(b *protoBuffer, num, wire int) (bool, error) {
+		switch {
+		case num == 1 && wire == wireBytes: // string_value
+			s, err := b.bytes()
+			str := string(s)
+			v.StringValue = &str
+			return true, err
+		case num == 2 && wire == wireVarint: // bool_value
+			n, err := b.varint()
+			bv := n != 0
+			v.BoolValue = &bv
+			return true, err
+		case num == 3 && wire == wireVarint: // int_value
+			n, err := b.varint()
+			iv := otlpInt64(n)
+			v.IntValue = &iv
+			return true, err
+		case num == 4 && wire == wireFixed64: // double_value
+			n, err := b.fixed64()
+			dv := math.Float64frombits(n)
+			v.DoubleValue = &dv
+			return true, err
+		case num == 5 && wire == wireBytes: // array_value
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			v.ArrayValue = &otlpArrayValue{}
+			return true, protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+				if num != 1 || wire != wireBytes {
+					return false, nil
+				}
+				elem, err := b.bytes()
+				if err != nil {
+					return true, err
+				}
+				var av otlpAnyValue
+				if err := av.unmarshalProto(elem, depth+1); err != nil {
+					return true, err
+				}
+				v.ArrayValue.Values = append(v.ArrayValue.Values, av)
+				return true, nil
+			})
+		case num == 6 && wire == wireBytes: // kvlist_value
+			msg, err := b.bytes()
+			if err != nil {
+				return true, err
+			}
+			v.KvlistValue = &otlpKeyValueList{}
+			return true, protoMessage(msg, func(b *protoBuffer, num, wire int) (bool, error) {
+				if num != 1 || wire != wireBytes {
+					return false, nil
+				}
+				return true, unmarshalProtoKeyValue(b, &v.KvlistValue.Values, depth+1)
+			})
+		case num == 7 && wire == wireBytes: // bytes_value
+			bv, err := b.bytes()
+			v.BytesValue = bv
+			return true, err
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,219 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const otlpTestJSON = `{
+  "resourceSpans": [{
+    "resource": {"attributes": [
+      {"key": "service.name", "value": {"stringValue": "checkout"}},
+      {"key": "deployment.environment", "value": {"stringValue": "prod"}},
+      {"key": "telemetry.sdk.language", "value": {"stringValue": "python"}}
+    ]},
+    "scopeSpans": [{
+      "scope": {"name": "opentelemetry.instrumentation.flask", "version": "0.40b0"},
+      "spans": [{
+        "traceId": "5b8efff798038103d269b633813fc60c",
+        "spanId": "eee19b7ec3c1b174",
+        "name": "GET /users/{id}",
+        "kind": 2,
+        "startTimeUnixNano": "1544712660000000000",
+        "endTimeUnixNano": "1544712661000000000",
+        "attributes": [
+          {"key": "http.method", "value": {"stringValue": "GET"}},
+          {"key": "http.route", "value": {"stringValue": "/users/{id}"}},
+          {"key": "http.status_code", "value": {"intValue": "500"}},
+          {"key": "retries", "value": {"intValue": 2}},
+          {"key": "ratio", "value": {"doubleValue": 0.5}},
+          {"key": "cached", "value": {"boolValue": false}}
+        ],
+        "events": [{
+          "name": "exception",
+          "attributes": [{"key": "exception.type", "value": {"stringValue": "KeyError"}}]
+        }],
+        "status": {"code": 2, "message": "boom"}
+      }, {
+        "traceId": "5b8efff798038103d269b633813fc60c",
+        "spanId": "eee19b7ec3c1b175",
+        "parentSpanId": "eee19b7ec3c1b174",
+        "name": "SELECT",
+        "kind": 3,
+        "startTimeUnixNano": 1544712660100000000,
+        "endTimeUnixNano": 1544712660200000000,
+        "attributes": [
+          {"key": "db.system", "value": {"stringValue": "postgresql"}},
+          {"key": "db.statement", "value": {"stringValue": "SELECT * FROM users WHERE id = 42"}}
+        ]
+      }]
+    }]
+  }]
+}`
+
+func TestOTLPJSONTraces(t *testing.T) {
+	assert := assert.New(t)
+
+	var req OTLPExportRequest
+	assert.Nil(json.Unmarshal([]byte(otlpTestJSON), &req))
+	assert.Equal("python", req.ResourceAttribute("telemetry.sdk.language"))
+
+	traces := req.Traces()
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 2)
+
+	root := traces[0][0]
+	assert.Equal("checkout", root.Service)
+	assert.Equal("opentelemetry.instrumentation.flask.server", root.Name)
+	assert.Equal("GET /users/{id}", root.Resource)
+	assert.Equal("web", root.Type)
+	assert.Equal(uint64(0xd269b633813fc60c), root.TraceID)
+	assert.Equal(uint64(0xeee19b7ec3c1b174), root.SpanID)
+	assert.Equal(uint64(0), root.ParentID)
+	assert.Equal(int64(1544712660000000000), root.Start)
+	assert.Equal(int64(1e9), root.Duration)
+	assert.Equal(int32(1), root.Error)
+	assert.Equal("boom", root.Meta["error.msg"])
+	assert.Equal("KeyError", root.Meta["error.type"])
+	assert.Equal("prod", root.Meta["env"])
+	assert.Equal("500", root.Meta["http.status_code"])
+	assert.Equal("false", root.Meta["cached"])
+	assert.Equal("5b8efff798038103d269b633813fc60c", root.Meta["otel.trace_id"])
+	assert.Equal(2.0, root.Metrics["retries"])
+	assert.Equal(0.5, root.Metrics["ratio"])
+
+	child := traces[0][1]
+	assert.Equal(root.TraceID, child.TraceID)
+	assert.Equal(root.SpanID, child.ParentID)
+	assert.Equal("sql", child.Type)
+	assert.Equal("SELECT * FROM users WHERE id = 42", child.Resource)
+	assert.Equal(int32(0), child.Error)
+
+	_, err := NormalizeTrace(traces[0])
+	assert.Nil(err)
+}
+
+func TestOTLPJSONInvalidID(t *testing.T) {
+	var req OTLPExportRequest
+	err := json.Unmarshal([]byte(`{"resourceSpans":[{"scopeSpans":[{"spans":[{"traceId":"xyz"}]}]}]}`), &req)
+	assert.NotNil(t, err)
+}
+
+// protobuf encoding helpers, only the bits needed to build test messages
+
+func pbUvarint(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+func pbKey(num, wire int) []byte {
+	return pbUvarint(uint64(num<<3 | wire))
+}
+
+func pbBytes(num int, v []byte) []byte {
+	return pbConcat(pbKey(num, wireBytes), pbUvarint(uint64(len(v))), v)
+}
+
+func pbVarint(num int, v uint64) []byte {
+	return pbConcat(pbKey(num, wireVarint), pbUvarint(v))
+}
+
+func pbFixed64(num int, v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return pbConcat(pbKey(num, wireFixed64), buf)
+}
+
+func pbConcat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func pbKeyValue(key string, value []byte) []byte {
+	return pbBytes(9, pbConcat(pbBytes(1, []byte(key)), pbBytes(2, value)))
+}
+
+func TestOTLPProtoTraces(t *testing.T) {
+	assert := assert.New(t)
+
+	traceID := []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}
+	spanID := []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}
+
+	span := pbConcat(
+		pbBytes(1, traceID),
+		pbBytes(2, spanID),
+		pbBytes(5, []byte("consume")),
+		pbVarint(6, otlpSpanKindConsumer),
+		pbFixed64(7, 1544712660000000000),
+		pbFixed64(8, 1544712660500000000),
+		pbKeyValue("messaging.system", pbBytes(1, []byte("kafka"))),
+		pbKeyValue("partition", pbVarint(3, 7)),
+		pbKeyValue("lag", pbFixed64(4, math.Float64bits(1.5))),
+		pbKeyValue("tags", pbBytes(5, pbConcat(pbBytes(1, pbBytes(1, []byte("a"))), pbBytes(1, pbBytes(1, []byte("b")))))),
+		pbBytes(15, pbConcat(pbBytes(2, []byte("failed")), pbVarint(3, otlpStatusCodeError))),
+		pbVarint(99, 1), // unknown field, should be skipped
+	)
+	resource := pbBytes(1, pbBytes(1, pbConcat(pbBytes(1, []byte("service.name")), pbBytes(2, pbBytes(1, []byte("billing"))))))
+	scope := pbBytes(1, pbConcat(pbBytes(1, []byte("kafka-go")), pbBytes(2, []byte("1.0"))))
+	data := pbBytes(1, pbConcat(resource, pbBytes(2, pbConcat(scope, pbBytes(2, span)))))
+
+	var req OTLPExportRequest
+	assert.Nil(req.UnmarshalProto(data))
+
+	traces := req.Traces()
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 1)
+
+	s := traces[0][0]
+	assert.Equal("billing", s.Service)
+	assert.Equal("kafka-go.consumer", s.Name)
+	assert.Equal("consume", s.Resource)
+	assert.Equal(uint64(0xd269b633813fc60c), s.TraceID)
+	assert.Equal(uint64(0xeee19b7ec3c1b174), s.SpanID)
+	assert.Equal(int64(5e8), s.Duration)
+	assert.Equal("kafka", s.Meta["messaging.system"])
+	assert.Equal(`["a","b"]`, s.Meta["tags"])
+	assert.Equal("1.0", s.Meta["otel.library.version"])
+	assert.Equal(7.0, s.Metrics["partition"])
+	assert.Equal(1.5, s.Metrics["lag"])
+	assert.Equal(int32(1), s.Error)
+	assert.Equal("failed", s.Meta["error.msg"])
+}
+
+func TestOTLPProtoTruncated(t *testing.T) {
+	data := pbBytes(1, pbBytes(2, pbBytes(2, pbBytes(1, []byte("0123456789abcdef")))))
+
+	for i := 1; i < len(data); i++ {
+		var req OTLPExportRequest
+		assert.NotNil(t, req.UnmarshalProto(data[:i]), "truncated at %d", i)
+	}
+}
+
+func TestOTLPProtoTooDeep(t *testing.T) {
+	assert := assert.New(t)
+
+	// an attribute value made of arrays of arrays
+	nested := func(depth int) []byte {
+		value := pbBytes(1, []byte("leaf"))
+		for i := 0; i < depth; i++ {
+			value = pbBytes(5, pbBytes(1, value))
+		}
+		span := pbConcat(pbBytes(1, make([]byte, 16)), pbBytes(2, make([]byte, 8)), pbKeyValue("nested", value))
+		return pbBytes(1, pbBytes(2, pbBytes(2, span)))
+	}
+
+	var req OTLPExportRequest
+	assert.Nil(req.UnmarshalProto(nested(otlpMaxValueDepth - 1)))
+
+	req = OTLPExportRequest{}
+	assert.Equal(errProtoTooDeep, req.UnmarshalProto(nested(otlpMaxValueDepth)))
+	req = OTLPExportRequest{}
+	assert.Equal(errProtoTooDeep, req.UnmarshalProto(nested(1000)))
+}
@@ -8,15 +8,20 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+
+	"github.com/tinylib/msgp/msgp"
 )
 
+//go:generate msgp -marshal=false
+//msgp:ignore AgentPayloadVersion
+
 // AgentPayload is the main payload to carry data that has been
 // pre-processed to the Datadog mothership
 type AgentPayload struct {
-	HostName string        `json:"hostname"` // the host name that will be resolved by the API
-	Env      string        `json:"env"`      // the default environment this agent uses
-	Traces   []Trace       `json:"traces"`   // the traces we sampled
-	Stats    []StatsBucket `json:"stats"`    // the statistics we pre-computed
+	HostName string        `json:"hostname" msg:"hostname"` // the host name that will be resolved by the API
+	Env      string        `json:"env" msg:"env"`           // the default environment this agent uses
+	Traces   []Trace       `json:"traces" msg:"traces"`     // the traces we sampled
+	Stats    []StatsBucket `json:"stats" msg:"stats"`       // the statistics we pre-computed
 
 	// private
 	mu     sync.RWMutex
@@ -55,6 +60,9 @@ type AgentPayloadVersion string
 const (
 	// AgentPayloadV01 is a simple json'd/gzip'd dump of the payload
 	AgentPayloadV01 AgentPayloadVersion = "v0.1"
+	// AgentPayloadV02 is a msgpack'd/gzip'd dump of the payload, cheaper
+	// to encode and more compact than V01
+	AgentPayloadV02 AgentPayloadVersion = "v0.2"
 )
 
 var (
@@ -67,21 +75,59 @@ var (
 // payload (according to GlobalAgentPayloadVersion)
 func EncodeAgentPayload(p *AgentPayload) ([]byte, error) {
 	var b bytes.Buffer
+	var gz *gzip.Writer
 	var err error
 
 	switch GlobalAgentPayloadVersion {
 	case AgentPayloadV01:
-		gz, err := gzip.NewWriterLevel(&b, gzip.BestSpeed)
-		if err != nil {
+		if gz, err = gzip.NewWriterLevel(&b, gzip.BestSpeed); err != nil {
 			return nil, err
 		}
 		err = json.NewEncoder(gz).Encode(p)
-		gz.Close()
+	case AgentPayloadV02:
+		if gz, err = gzip.NewWriterLevel(&b, gzip.BestSpeed); err != nil {
+			return nil, err
+		}
+		err = msgp.Encode(gz, p)
 	default:
-		err = errors.New("unknown payload version")
+		return nil, errors.New("unknown payload version")
+	}
+
+	// closing flushes the end of the compressed stream, the body is
+	// truncated without it
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecodeAgentPayload decodes a payload encoded by EncodeAgentPayload
+// (according to GlobalAgentPayloadVersion) into p. Extras are not part of
+// the encoded payload and are left untouched.
+func DecodeAgentPayload(data []byte, p *AgentPayload) error {
+	return DecodeAgentPayloadVersion(GlobalAgentPayloadVersion, data, p)
+}
+
+// DecodeAgentPayloadVersion decodes a payload encoded with the given
+// version into p.
+func DecodeAgentPayloadVersion(v AgentPayloadVersion, data []byte, p *AgentPayload) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
 	}
+	defer gz.Close()
 
-	return b.Bytes(), err
+	switch v {
+	case AgentPayloadV01:
+		return json.NewDecoder(gz).Decode(p)
+	case AgentPayloadV02:
+		return msgp.Decode(gz, p)
+	default:
+		return errors.New("unknown payload version")
+	}
 }
 
 // AgentPayloadAPIPath returns the path (after the first slash) to which
@@ -99,6 +145,13 @@ func SetAgentPayloadHeaders(h http.Header, extras map[string]string) {
 		h.Set("Content-Type", "application/json")
 		h.Set("Content-Encoding", "gzip")
 
+		for key, value := range extras {
+			h.Set(key, value)
+		}
+	case AgentPayloadV02:
+		h.Set("Content-Type", "application/msgpack")
+		h.Set("Content-Encoding", "gzip")
+
 		for key, value := range extras {
 			h.Set(key, value)
 		}
@@ -0,0 +1,146 @@
+package model
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *AgentPayload) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "hostname":
+			z.HostName, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "env":
+			z.Env, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "traces":
+			var zb0002 uint32
+			zb0002, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Traces) >= int(zb0002) {
+				z.Traces = (z.Traces)[:zb0002]
+			} else {
+				z.Traces = make([]Trace, zb0002)
+			}
+			for za0001 := range z.Traces {
+				err = z.Traces[za0001].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		case "stats":
+			var zb0003 uint32
+			zb0003, err = dc.ReadArrayHeader()
+			if err != nil {
+				return
+			}
+			if cap(z.Stats) >= int(zb0003) {
+				z.Stats = (z.Stats)[:zb0003]
+			} else {
+				z.Stats = make([]StatsBucket, zb0003)
+			}
+			for za0002 := range z.Stats {
+				err = z.Stats[za0002].DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *AgentPayload) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 4
+	// write "hostname"
+	err = en.Append(0x84, 0xa8, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.HostName)
+	if err != nil {
+		return
+	}
+	// write "env"
+	err = en.Append(0xa3, 0x65, 0x6e, 0x76)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Env)
+	if err != nil {
+		return
+	}
+	// write "traces"
+	err = en.Append(0xa6, 0x74, 0x72, 0x61, 0x63, 0x65, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Traces)))
+	if err != nil {
+		return
+	}
+	for za0001 := range z.Traces {
+		err = z.Traces[za0001].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "stats"
+	err = en.Append(0xa5, 0x73, 0x74, 0x61, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteArrayHeader(uint32(len(z.Stats)))
+	if err != nil {
+		return
+	}
+	for za0002 := range z.Stats {
+		err = z.Stats[za0002].EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *AgentPayload) Msgsize() (s int) {
+	s = 1 + 9 + msgp.StringPrefixSize + len(z.HostName) + 4 + msgp.StringPrefixSize + len(z.Env) + 7 + msgp.ArrayHeaderSize
+	for za0001 := range z.Traces {
+		s += z.Traces[za0001].Msgsize()
+	}
+	s += 6 + msgp.ArrayHeaderSize
+	for za0002 := range z.Stats {
+		s += z.Stats[za0002].Msgsize()
+	}
+	return
+}
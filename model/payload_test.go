@@ -0,0 +1,63 @@
+package model
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentPayloadHeaders(t *testing.T) {
+	defer func(v AgentPayloadVersion) { GlobalAgentPayloadVersion = v }(GlobalAgentPayloadVersion)
+
+	for _, tc := range []struct {
+		version     AgentPayloadVersion
+		path        string
+		contentType string
+	}{
+		{AgentPayloadV01, "/api/v0.1/collector", "application/json"},
+		{AgentPayloadV02, "/api/v0.2/collector", "application/msgpack"},
+	} {
+		GlobalAgentPayloadVersion = tc.version
+
+		h := http.Header{}
+		SetAgentPayloadHeaders(h, map[string]string{"X-Datadog-Reported-Languages": "go"})
+		assert.Equal(t, tc.path, AgentPayloadAPIPath())
+		assert.Equal(t, tc.contentType, h.Get("Content-Type"))
+		assert.Equal(t, "gzip", h.Get("Content-Encoding"))
+		assert.Equal(t, "go", h.Get("X-Datadog-Reported-Languages"))
+
+		h = http.Header{}
+		SetServicesPayloadHeaders(h)
+		assert.Equal(t, "application/json", h.Get("Content-Type"))
+	}
+}
+
+func TestEncodeAgentPayloadErrors(t *testing.T) {
+	assert := assert.New(t)
+	defer func(v AgentPayloadVersion) { GlobalAgentPayloadVersion = v }(GlobalAgentPayloadVersion)
+
+	// JSON cannot encode NaN
+	p := AgentPayload{HostName: "h", Traces: []Trace{{Span{Metrics: map[string]float64{"x": math.NaN()}}}}}
+	GlobalAgentPayloadVersion = AgentPayloadV01
+	data, err := EncodeAgentPayload(&p)
+	assert.NotNil(err)
+	assert.Nil(data)
+
+	GlobalAgentPayloadVersion = "v0.42"
+	data, err = EncodeAgentPayload(&p)
+	assert.NotNil(err)
+	assert.Nil(data)
+
+	// the compressed stream is complete
+	for _, v := range []AgentPayloadVersion{AgentPayloadV01, AgentPayloadV02} {
+		GlobalAgentPayloadVersion = v
+		p := AgentPayload{HostName: "h", Env: "e"}
+		data, err := EncodeAgentPayload(&p)
+		assert.Nil(err)
+		var decoded AgentPayload
+		assert.Nil(DecodeAgentPayload(data, &decoded))
+		assert.Equal("e", decoded.Env)
+	}
+}
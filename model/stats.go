@@ -6,6 +6,8 @@ import (
 	"github.com/DataDog/datadog-trace-agent/quantile"
 )
 
+//go:generate msgp -marshal=false
+
 // Hardcoded measures names for ease of reference
 const (
 	HITS     string = "hits"
@@ -23,26 +25,26 @@ var (
 
 // Count represents one specific "metric" we track for a given tagset
 type Count struct {
-	Key     string `json:"key"`
-	Name    string `json:"name"`    // the name of the trace/spans we count (was a member of TagSet)
-	Measure string `json:"measure"` // represents the entity we count, e.g. "hits", "errors", "time" (was Name)
-	TagSet  TagSet `json:"tagset"`  // set of tags for which we account this Distribution
+	Key     string `json:"key" msg:"key"`
+	Name    string `json:"name" msg:"name"`       // the name of the trace/spans we count (was a member of TagSet)
+	Measure string `json:"measure" msg:"measure"` // represents the entity we count, e.g. "hits", "errors", "time" (was Name)
+	TagSet  TagSet `json:"tagset" msg:"tagset"`   // set of tags for which we account this Distribution
 
-	TopLevel float64 `json:"top_level"` // number of top-level spans contributing to this count
+	TopLevel float64 `json:"top_level" msg:"top_level"` // number of top-level spans contributing to this count
 
-	Value float64 `json:"value"` // accumulated values
+	Value float64 `json:"value" msg:"value"` // accumulated values
 }
 
 // Distribution represents a true image of the spectrum of values, allowing arbitrary quantile queries
 type Distribution struct {
-	Key     string `json:"key"`
-	Name    string `json:"name"`    // the name of the trace/spans we count (was a member of TagSet)
-	Measure string `json:"measure"` // represents the entity we count, e.g. "hits", "errors", "time"
-	TagSet  TagSet `json:"tagset"`  // set of tags for which we account this Distribution
+	Key     string `json:"key" msg:"key"`
+	Name    string `json:"name" msg:"name"`       // the name of the trace/spans we count (was a member of TagSet)
+	Measure string `json:"measure" msg:"measure"` // represents the entity we count, e.g. "hits", "errors", "time"
+	TagSet  TagSet `json:"tagset" msg:"tagset"`   // set of tags for which we account this Distribution
 
-	TopLevel float64 `json:"top_level"` // number of top-level spans contributing to this count
+	TopLevel float64 `json:"top_level" msg:"top_level"` // number of top-level spans contributing to this count
 
-	Summary *quantile.SliceSummary `json:"summary"` // actual representation of data
+	Summary *quantile.SliceSummary `json:"summary" msg:"summary"` // actual representation of data
 }
 
 // GrainKey generates the key used to aggregate counts and distributions
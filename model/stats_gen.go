@@ -0,0 +1,501 @@
+package model
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/DataDog/datadog-trace-agent/quantile"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *Count) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "key":
+			z.Key, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "name":
+			z.Name, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "measure":
+			z.Measure, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "tagset":
+			err = z.TagSet.DecodeMsg(dc)
+			if err != nil {
+				return
+			}
+		case "top_level":
+			z.TopLevel, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "value":
+			z.Value, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *Count) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 6
+	// write "key"
+	err = en.Append(0x86, 0xa3, 0x6b, 0x65, 0x79)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Key)
+	if err != nil {
+		return
+	}
+	// write "name"
+	err = en.Append(0xa4, 0x6e, 0x61, 0x6d, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Name)
+	if err != nil {
+		return
+	}
+	// write "measure"
+	err = en.Append(0xa7, 0x6d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Measure)
+	if err != nil {
+		return
+	}
+	// write "tagset"
+	err = en.Append(0xa6, 0x74, 0x61, 0x67, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = z.TagSet.EncodeMsg(en)
+	if err != nil {
+		return
+	}
+	// write "top_level"
+	err = en.Append(0xa9, 0x74, 0x6f, 0x70, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.TopLevel)
+	if err != nil {
+		return
+	}
+	// write "value"
+	err = en.Append(0xa5, 0x76, 0x61, 0x6c, 0x75, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.Value)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *Count) Msgsize() (s int) {
+	s = 1 + 4 + msgp.StringPrefixSize + len(z.Key) + 5 + msgp.StringPrefixSize + len(z.Name) + 8 + msgp.StringPrefixSize + len(z.Measure) + 7 + z.TagSet.Msgsize() + 10 + msgp.Float64Size + 6 + msgp.Float64Size
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *Distribution) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "key":
+			z.Key, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "name":
+			z.Name, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "measure":
+			z.Measure, err = dc.ReadString()
+			if err != nil {
+				return
+			}
+		case "tagset":
+			err = z.TagSet.DecodeMsg(dc)
+			if err != nil {
+				return
+			}
+		case "top_level":
+			z.TopLevel, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "summary":
+			if dc.IsNil() {
+				err = dc.ReadNil()
+				if err != nil {
+					return
+				}
+				z.Summary = nil
+			} else {
+				if z.Summary == nil {
+					z.Summary = new(quantile.SliceSummary)
+				}
+				err = z.Summary.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *Distribution) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 6
+	// write "key"
+	err = en.Append(0x86, 0xa3, 0x6b, 0x65, 0x79)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Key)
+	if err != nil {
+		return
+	}
+	// write "name"
+	err = en.Append(0xa4, 0x6e, 0x61, 0x6d, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Name)
+	if err != nil {
+		return
+	}
+	// write "measure"
+	err = en.Append(0xa7, 0x6d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65)
+	if err != nil {
+		return
+	}
+	err = en.WriteString(z.Measure)
+	if err != nil {
+		return
+	}
+	// write "tagset"
+	err = en.Append(0xa6, 0x74, 0x61, 0x67, 0x73, 0x65, 0x74)
+	if err != nil {
+		return
+	}
+	err = z.TagSet.EncodeMsg(en)
+	if err != nil {
+		return
+	}
+	// write "top_level"
+	err = en.Append(0xa9, 0x74, 0x6f, 0x70, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.TopLevel)
+	if err != nil {
+		return
+	}
+	// write "summary"
+	err = en.Append(0xa7, 0x73, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79)
+	if err != nil {
+		return
+	}
+	if z.Summary == nil {
+		err = en.WriteNil()
+		if err != nil {
+			return
+		}
+	} else {
+		err = z.Summary.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *Distribution) Msgsize() (s int) {
+	s = 1 + 4 + msgp.StringPrefixSize + len(z.Key) + 5 + msgp.StringPrefixSize + len(z.Name) + 8 + msgp.StringPrefixSize + len(z.Measure) + 7 + z.TagSet.Msgsize() + 10 + msgp.Float64Size + 8
+	if z.Summary == nil {
+		s += msgp.NilSize
+	} else {
+		s += z.Summary.Msgsize()
+	}
+	return
+}
+
+// DecodeMsg implements msgp.Decodable
+func (z *StatsBucket) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "Start":
+			z.Start, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "Duration":
+			z.Duration, err = dc.ReadInt64()
+			if err != nil {
+				return
+			}
+		case "Counts":
+			var zb0002 uint32
+			zb0002, err = dc.ReadMapHeader()
+			if err != nil {
+				return
+			}
+			if z.Counts == nil {
+				z.Counts = make(map[string]Count, zb0002)
+			} else if len(z.Counts) > 0 {
+				for key := range z.Counts {
+					delete(z.Counts, key)
+				}
+			}
+			for zb0002 > 0 {
+				zb0002--
+				var za0001 string
+				var za0002 Count
+				za0001, err = dc.ReadString()
+				if err != nil {
+					return
+				}
+				err = za0002.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+				z.Counts[za0001] = za0002
+			}
+		case "Distributions":
+			var zb0003 uint32
+			zb0003, err = dc.ReadMapHeader()
+			if err != nil {
+				return
+			}
+			if z.Distributions == nil {
+				z.Distributions = make(map[string]Distribution, zb0003)
+			} else if len(z.Distributions) > 0 {
+				for key := range z.Distributions {
+					delete(z.Distributions, key)
+				}
+			}
+			for zb0003 > 0 {
+				zb0003--
+				var za0003 string
+				var za0004 Distribution
+				za0003, err = dc.ReadString()
+				if err != nil {
+					return
+				}
+				err = za0004.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+				z.Distributions[za0003] = za0004
+			}
+		case "ErrDistributions":
+			var zb0004 uint32
+			zb0004, err = dc.ReadMapHeader()
+			if err != nil {
+				return
+			}
+			if z.ErrDistributions == nil {
+				z.ErrDistributions = make(map[string]Distribution, zb0004)
+			} else if len(z.ErrDistributions) > 0 {
+				for key := range z.ErrDistributions {
+					delete(z.ErrDistributions, key)
+				}
+			}
+			for zb0004 > 0 {
+				zb0004--
+				var za0005 string
+				var za0006 Distribution
+				za0005, err = dc.ReadString()
+				if err != nil {
+					return
+				}
+				err = za0006.DecodeMsg(dc)
+				if err != nil {
+					return
+				}
+				z.ErrDistributions[za0005] = za0006
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z *StatsBucket) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 5
+	// write "Start"
+	err = en.Append(0x85, 0xa5, 0x53, 0x74, 0x61, 0x72, 0x74)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Start)
+	if err != nil {
+		return
+	}
+	// write "Duration"
+	err = en.Append(0xa8, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt64(z.Duration)
+	if err != nil {
+		return
+	}
+	// write "Counts"
+	err = en.Append(0xa6, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteMapHeader(uint32(len(z.Counts)))
+	if err != nil {
+		return
+	}
+	for za0001, za0002 := range z.Counts {
+		err = en.WriteString(za0001)
+		if err != nil {
+			return
+		}
+		err = za0002.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "Distributions"
+	err = en.Append(0xad, 0x44, 0x69, 0x73, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteMapHeader(uint32(len(z.Distributions)))
+	if err != nil {
+		return
+	}
+	for za0003, za0004 := range z.Distributions {
+		err = en.WriteString(za0003)
+		if err != nil {
+			return
+		}
+		err = za0004.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	// write "ErrDistributions"
+	err = en.Append(0xb0, 0x45, 0x72, 0x72, 0x44, 0x69, 0x73, 0x74, 0x72, 0x69, 0x62, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x73)
+	if err != nil {
+		return
+	}
+	err = en.WriteMapHeader(uint32(len(z.ErrDistributions)))
+	if err != nil {
+		return
+	}
+	for za0005, za0006 := range z.ErrDistributions {
+		err = en.WriteString(za0005)
+		if err != nil {
+			return
+		}
+		err = za0006.EncodeMsg(en)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *StatsBucket) Msgsize() (s int) {
+	s = 1 + 6 + msgp.Int64Size + 9 + msgp.Int64Size + 7 + msgp.MapHeaderSize
+	if z.Counts != nil {
+		for za0001, za0002 := range z.Counts {
+			_ = za0002
+			s += msgp.StringPrefixSize + len(za0001) + za0002.Msgsize()
+		}
+	}
+	s += 14 + msgp.MapHeaderSize
+	if z.Distributions != nil {
+		for za0003, za0004 := range z.Distributions {
+			_ = za0004
+			s += msgp.StringPrefixSize + len(za0003) + za0004.Msgsize()
+		}
+	}
+	s += 17 + msgp.MapHeaderSize
+	if z.ErrDistributions != nil {
+		for za0005, za0006 := range z.ErrDistributions {
+			_ = za0006
+			s += msgp.StringPrefixSize + len(za0005) + za0006.Msgsize()
+		}
+	}
+	return
+}
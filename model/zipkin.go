@@ -0,0 +1,247 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ZipkinDefaultService is the service used for Zipkin spans which do not
+// have a local endpoint service name, as Zipkin does.
+const ZipkinDefaultService = "unknown"
+
+// ZipkinSpans is a list of spans in the Zipkin v2 JSON format, as sent to
+// the /api/v2/spans endpoint.
+type ZipkinSpans []zipkinSpan
+
+type zipkinSpan struct {
+	TraceID        string             `json:"traceId"`
+	ID             string             `json:"id"`
+	ParentID       string             `json:"parentId"`
+	Name           string             `json:"name"`
+	Kind           string             `json:"kind"`
+	Timestamp      int64              `json:"timestamp"` // microseconds
+	Duration       int64              `json:"duration"`  // microseconds
+	LocalEndpoint  *zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint    `json:"remoteEndpoint"`
+	Tags           map[string]string  `json:"tags"`
+	Annotations    []zipkinAnnotation `json:"annotations"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+	IPv4        string `json:"ipv4"`
+	IPv6        string `json:"ipv6"`
+	Port        int    `json:"port"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64           `json:"timestamp"`
+	Value     string          `json:"value"`
+	Endpoint  *zipkinEndpoint `json:"endpoint"` // v1 only
+}
+
+// ZipkinV1Spans is a list of spans in the legacy Zipkin v1 JSON format, as
+// sent to the /api/v1/spans endpoint.
+type ZipkinV1Spans []zipkinV1Span
+
+type zipkinV1Span struct {
+	TraceID           string                   `json:"traceId"`
+	ID                string                   `json:"id"`
+	ParentID          string                   `json:"parentId"`
+	Name              string                   `json:"name"`
+	Timestamp         int64                    `json:"timestamp"`
+	Duration          int64                    `json:"duration"`
+	Annotations       []zipkinAnnotation       `json:"annotations"`
+	BinaryAnnotations []zipkinBinaryAnnotation `json:"binaryAnnotations"`
+}
+
+type zipkinBinaryAnnotation struct {
+	Key      string          `json:"key"`
+	Value    interface{}     `json:"value"`
+	Endpoint *zipkinEndpoint `json:"endpoint"`
+}
+
+// parseZipkinID parses a hex-encoded Zipkin ID. 128-bit trace IDs are
+// folded into their lower 64 bits.
+func parseZipkinID(id string) (uint64, error) {
+	if len(id) > 16 {
+		id = id[len(id)-16:]
+	}
+	return strconv.ParseUint(id, 16, 64)
+}
+
+// convert converts a Zipkin v2 span into a Span.
+func (zs *zipkinSpan) convert() (Span, error) {
+	var s Span
+	var err error
+
+	if s.TraceID, err = parseZipkinID(zs.TraceID); err != nil {
+		return s, fmt.Errorf("invalid zipkin trace id %q", zs.TraceID)
+	}
+	if s.SpanID, err = parseZipkinID(zs.ID); err != nil {
+		return s, fmt.Errorf("invalid zipkin span id %q", zs.ID)
+	}
+	if zs.ParentID != "" {
+		if s.ParentID, err = parseZipkinID(zs.ParentID); err != nil {
+			return s, fmt.Errorf("invalid zipkin parent id %q", zs.ParentID)
+		}
+	}
+
+	s.Service = ZipkinDefaultService
+	if zs.LocalEndpoint != nil && zs.LocalEndpoint.ServiceName != "" {
+		s.Service = zs.LocalEndpoint.ServiceName
+	}
+	s.Name = zs.Name
+	s.Start = zs.Timestamp * 1000
+	s.Duration = zs.Duration * 1000
+	s.Meta = make(map[string]string, len(zs.Tags)+2)
+
+	for k, v := range zs.Tags {
+		s.Meta[k] = v
+	}
+	if zs.Kind != "" {
+		s.Meta["span.kind"] = strings.ToLower(zs.Kind)
+	}
+	if re := zs.RemoteEndpoint; re != nil {
+		if re.ServiceName != "" {
+			s.Meta["peer.service"] = re.ServiceName
+		}
+		if re.IPv4 != "" {
+			s.Meta["out.host"] = re.IPv4
+		} else if re.IPv6 != "" {
+			s.Meta["out.host"] = re.IPv6
+		}
+		if re.Port != 0 {
+			s.Meta["out.port"] = strconv.Itoa(re.Port)
+		}
+	}
+
+	if len(zs.Annotations) > 0 {
+		values := make([]string, len(zs.Annotations))
+		for i, a := range zs.Annotations {
+			values[i] = a.Value
+			if a.Value == "error" {
+				s.Error = 1
+			}
+		}
+		s.Meta["zipkin.annotations"] = strings.Join(values, ", ")
+	}
+	if msg, ok := zs.Tags["error"]; ok {
+		// Zipkin flags failed spans with an "error" tag holding the message
+		s.Error = 1
+		delete(s.Meta, "error")
+		if msg != "" && msg != "true" {
+			s.Meta["error.msg"] = msg
+		}
+	}
+
+	method, isHTTP := zs.Tags["http.method"]
+	s.Resource = s.Name
+	switch {
+	case isHTTP && zs.Kind == "SERVER":
+		s.Type = "web"
+		if route, ok := zs.Tags["http.route"]; ok && route != "" {
+			s.Resource = method + " " + route
+		}
+	case isHTTP && zs.Kind == "CLIENT":
+		s.Type = "http"
+	case zs.Tags["sql.query"] != "":
+		s.Type = "sql"
+		s.Resource = zs.Tags["sql.query"]
+	}
+
+	return s, nil
+}
+
+// Traces converts the spans into Traces, grouping them by trace ID the same
+// way TracesFromSpans does. Spans that cannot be converted are left out and
+// reported in the returned errors.
+func (spans ZipkinSpans) Traces() (Traces, []error) {
+	converted := make([]Span, 0, len(spans))
+	var errs []error
+	for i := range spans {
+		s, err := spans[i].convert()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		converted = append(converted, s)
+	}
+	return TracesFromSpans(converted), errs
+}
+
+// zipkinV1CoreKinds maps the v1 core annotations to the v2 span kind they
+// imply, and tells whether they mark the start of the span.
+var zipkinV1CoreKinds = map[string]struct {
+	kind  string
+	start bool
+}{
+	"cs": {"CLIENT", true},
+	"cr": {"CLIENT", false},
+	"sr": {"SERVER", true},
+	"ss": {"SERVER", false},
+	"ms": {"PRODUCER", true},
+	"mr": {"CONSUMER", true},
+}
+
+// ZipkinSpans converts legacy v1 spans into the v2 model, following the
+// same rules as the Zipkin v1 to v2 converter: core annotations give the
+// kind, local endpoint and bounds of the span, "ca"/"sa" binary
+// annotations give the remote endpoint, and other binary annotations
+// become tags.
+func (spans ZipkinV1Spans) ZipkinSpans() ZipkinSpans {
+	v2 := make(ZipkinSpans, 0, len(spans))
+	for _, s1 := range spans {
+		s2 := zipkinSpan{
+			TraceID:   s1.TraceID,
+			ID:        s1.ID,
+			ParentID:  s1.ParentID,
+			Name:      s1.Name,
+			Timestamp: s1.Timestamp,
+			Duration:  s1.Duration,
+			Tags:      make(map[string]string, len(s1.BinaryAnnotations)),
+		}
+
+		var start, end int64
+		for _, a := range s1.Annotations {
+			core, ok := zipkinV1CoreKinds[a.Value]
+			if !ok {
+				s2.Annotations = append(s2.Annotations, zipkinAnnotation{Timestamp: a.Timestamp, Value: a.Value})
+				continue
+			}
+			if s2.Kind == "" {
+				s2.Kind = core.kind
+			}
+			if s2.LocalEndpoint == nil {
+				s2.LocalEndpoint = a.Endpoint
+			}
+			if core.start {
+				start = a.Timestamp
+			} else {
+				end = a.Timestamp
+			}
+		}
+		if s2.Timestamp == 0 {
+			s2.Timestamp = start
+		}
+		if s2.Duration == 0 && start != 0 && end > start {
+			s2.Duration = end - start
+		}
+
+		for _, b := range s1.BinaryAnnotations {
+			switch b.Key {
+			case "ca", "sa", "ma":
+				s2.RemoteEndpoint = b.Endpoint
+				continue
+			}
+			if s2.LocalEndpoint == nil {
+				s2.LocalEndpoint = b.Endpoint
+			}
+			s2.Tags[b.Key] = fmt.Sprint(b.Value)
+		}
+
+		v2 = append(v2, s2)
+	}
+	return v2
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const zipkinV2TestJSON = `[{
+  "traceId": "463ac35c9f6413ad48485a3953bb6124",
+  "id": "48485a3953bb6124",
+  "name": "get /users/{id}",
+  "kind": "SERVER",
+  "timestamp": 1502787600000000,
+  "duration": 207000,
+  "localEndpoint": {"serviceName": "frontend", "ipv4": "127.0.0.1"},
+  "tags": {"http.method": "GET", "http.route": "/users/{id}", "http.status_code": "500", "error": "Internal Server Error"},
+  "annotations": [{"timestamp": 1502787600100000, "value": "wr"}]
+}, {
+  "traceId": "463ac35c9f6413ad48485a3953bb6124",
+  "id": "e457b5a2e4d86bd1",
+  "parentId": "48485a3953bb6124",
+  "name": "query",
+  "kind": "CLIENT",
+  "timestamp": 1502787600050000,
+  "duration": 10000,
+  "localEndpoint": {"serviceName": "frontend"},
+  "remoteEndpoint": {"serviceName": "postgres", "ipv4": "10.0.0.2", "port": 5432},
+  "tags": {"sql.query": "SELECT * FROM users WHERE id = 42"}
+}, {
+  "traceId": "0000000000000001",
+  "id": "0000000000000002",
+  "name": "orphan",
+  "timestamp": 1502787600000000,
+  "duration": 1000
+}]`
+
+func TestZipkinV2Traces(t *testing.T) {
+	assert := assert.New(t)
+
+	var spans ZipkinSpans
+	assert.Nil(json.Unmarshal([]byte(zipkinV2TestJSON), &spans))
+
+	traces, errs := spans.Traces()
+	assert.Len(errs, 0)
+	assert.Len(traces, 2)
+
+	var trace Trace
+	for _, t := range traces {
+		if t[0].TraceID == 0x48485a3953bb6124 {
+			trace = t
+		}
+	}
+	assert.Len(trace, 2)
+
+	root := trace[0]
+	assert.Equal("frontend", root.Service)
+	assert.Equal("get /users/{id}", root.Name)
+	assert.Equal("GET /users/{id}", root.Resource)
+	assert.Equal("web", root.Type)
+	assert.Equal(uint64(0x48485a3953bb6124), root.SpanID)
+	assert.Equal(int64(1502787600000000000), root.Start)
+	assert.Equal(int64(207000000), root.Duration)
+	assert.Equal(int32(1), root.Error)
+	assert.Equal("Internal Server Error", root.Meta["error.msg"])
+	assert.Equal("server", root.Meta["span.kind"])
+	assert.Equal("500", root.Meta["http.status_code"])
+	assert.Equal("wr", root.Meta["zipkin.annotations"])
+
+	child := trace[1]
+	assert.Equal(root.SpanID, child.ParentID)
+	assert.Equal("sql", child.Type)
+	assert.Equal("SELECT * FROM users WHERE id = 42", child.Resource)
+	assert.Equal("postgres", child.Meta["peer.service"])
+	assert.Equal("10.0.0.2", child.Meta["out.host"])
+	assert.Equal("5432", child.Meta["out.port"])
+	assert.Equal(int32(0), child.Error)
+
+	_, err := NormalizeTrace(trace)
+	assert.Nil(err)
+}
+
+func TestZipkinInvalidIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	spans := ZipkinSpans{
+		{TraceID: "not-hex", ID: "1"},
+		{TraceID: "1", ID: ""},
+		{TraceID: "1", ID: "2", ParentID: "zz"},
+		{TraceID: "1", ID: "3", Name: "ok"},
+	}
+
+	traces, errs := spans.Traces()
+	assert.Len(errs, 3)
+	assert.Len(traces, 1)
+	assert.Equal(uint64(3), traces[0][0].SpanID)
+}
+
+func TestZipkinV1Spans(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := `[{
+	  "traceId": "5af7183fb1d4cf5f",
+	  "id": "352bff9a74ca9ad2",
+	  "parentId": "6b221d5bc9e6496c",
+	  "name": "get",
+	  "annotations": [
+	    {"timestamp": 1461750491274000, "value": "sr", "endpoint": {"serviceName": "backend", "ipv4": "192.168.99.101"}},
+	    {"timestamp": 1461750491280000, "value": "custom"},
+	    {"timestamp": 1461750491365000, "value": "ss", "endpoint": {"serviceName": "backend", "ipv4": "192.168.99.101"}}
+	  ],
+	  "binaryAnnotations": [
+	    {"key": "http.method", "value": "GET", "endpoint": {"serviceName": "backend"}},
+	    {"key": "http.status_code", "value": 200, "endpoint": {"serviceName": "backend"}},
+	    {"key": "ca", "value": true, "endpoint": {"serviceName": "frontend", "ipv4": "172.17.0.13", "port": 63679}}
+	  ]
+	}]`
+
+	var v1 ZipkinV1Spans
+	assert.Nil(json.Unmarshal([]byte(payload), &v1))
+
+	traces, errs := v1.ZipkinSpans().Traces()
+	assert.Len(errs, 0)
+	assert.Len(traces, 1)
+	assert.Len(traces[0], 1)
+
+	s := traces[0][0]
+	assert.Equal("backend", s.Service)
+	assert.Equal("get", s.Name)
+	assert.Equal("web", s.Type)
+	assert.Equal(uint64(0x6b221d5bc9e6496c), s.ParentID)
+	assert.Equal(int64(1461750491274000000), s.Start)
+	assert.Equal(int64(91000000), s.Duration)
+	assert.Equal("server", s.Meta["span.kind"])
+	assert.Equal("200", s.Meta["http.status_code"])
+	assert.Equal("frontend", s.Meta["peer.service"])
+	assert.Equal("custom", s.Meta["zipkin.annotations"])
+}
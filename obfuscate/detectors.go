@@ -0,0 +1,64 @@
+package obfuscate
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// creditCardRegexp matches candidate card numbers: 13 to 19 digits,
+	// possibly grouped with spaces or dashes
+	creditCardRegexp = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	// bearerTokenRegexp matches the token of an Authorization header value
+	bearerTokenRegexp = regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+)
+
+// replaceCreditCards replaces the card numbers found in v by "?". Only the
+// numbers passing the Luhn check are replaced so that ids and timestamps are
+// mostly left alone.
+func replaceCreditCards(v string) string {
+	return creditCardRegexp.ReplaceAllStringFunc(v, func(m string) string {
+		if luhn(m) {
+			return "?"
+		}
+		return m
+	})
+}
+
+// luhn tells if the digits of s, ignoring spaces and dashes, pass the Luhn
+// checksum
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// replaceBearerTokens replaces the tokens of "Bearer <token>" values by "?"
+func replaceBearerTokens(v string) string {
+	return bearerTokenRegexp.ReplaceAllString(v, "${1}?")
+}
+
+// querySecretsRegexp returns a regular expression matching the parameters of
+// a query string named after one of params, the parameter name and the equal
+// sign being its first submatch
+func querySecretsRegexp(params []string) *regexp.Regexp {
+	quoted := make([]string, len(params))
+	for i, p := range params {
+		quoted[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile(`(?i)([?&;](?:` + strings.Join(quoted, "|") + `)=)[^&#;\s]*`)
+}
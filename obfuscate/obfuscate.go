@@ -0,0 +1,134 @@
+// Package obfuscate scrubs sensitive data, such as credit card numbers,
+// authentication tokens or user defined patterns, from the meta of spans
+// before they leave the agent.
+package obfuscate
+
+import (
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/statsd"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+const (
+	// anyKey is the key of rules applying to all meta values
+	anyKey = "*"
+	// statsInterval is how often rule counters are reported
+	statsInterval = 10 * time.Second
+)
+
+// names of the built-in rules, used as rule:<name> tags of the counters
+const (
+	removeKeyRuleName   = "remove_key"
+	creditCardRuleName  = "credit_card"
+	bearerTokenRuleName = "bearer_token"
+	querySecretRuleName = "query_secret"
+)
+
+// rule replaces sensitive data in the values of a meta key
+type rule struct {
+	name    string
+	key     string // anyKey for all of them
+	replace func(string) string
+	count   int64 // the number of values changed by this rule, atomic
+}
+
+// Obfuscator scrubs the meta of spans according to a set of rules
+type Obfuscator struct {
+	removeKeys map[string]struct{}
+	removed    rule // counts removed keys
+	rules      []*rule
+}
+
+// NewObfuscator returns an Obfuscator applying the built-in detectors enabled
+// in conf, then its user defined rules. Invalid rules are logged and ignored.
+func NewObfuscator(conf *config.AgentConfig) *Obfuscator {
+	o := &Obfuscator{
+		removeKeys: make(map[string]struct{}, len(conf.ObfuscationRemoveKeys)),
+		removed:    rule{name: removeKeyRuleName},
+	}
+	for _, k := range conf.ObfuscationRemoveKeys {
+		o.removeKeys[k] = struct{}{}
+	}
+
+	if conf.ObfuscationCreditCards {
+		o.rules = append(o.rules, &rule{name: creditCardRuleName, key: anyKey, replace: replaceCreditCards})
+	}
+	if conf.ObfuscationBearerTokens {
+		o.rules = append(o.rules, &rule{name: bearerTokenRuleName, key: anyKey, replace: replaceBearerTokens})
+	}
+	if len(conf.ObfuscationQuerySecrets) > 0 {
+		re := querySecretsRegexp(conf.ObfuscationQuerySecrets)
+		o.rules = append(o.rules, &rule{
+			name:    querySecretRuleName,
+			key:     anyKey,
+			replace: func(v string) string { return re.ReplaceAllString(v, "${1}?") },
+		})
+	}
+
+	for _, r := range conf.ObfuscationRules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			log.Errorf("invalid obfuscation rule %s: %v", r.Name, err)
+			continue
+		}
+		replacement := r.Replacement
+		o.rules = append(o.rules, &rule{
+			name:    r.Name,
+			key:     r.Key,
+			replace: func(v string) string { return re.ReplaceAllString(v, replacement) },
+		})
+	}
+
+	log.Infof("obfuscating span meta with %d rules and %d removed keys", len(o.rules), len(o.removeKeys))
+	go func() {
+		defer watchdog.LogOnPanic()
+		o.logStats()
+	}()
+	return o
+}
+
+// Obfuscate removes the configured keys from the meta of s and applies the
+// rules to the remaining values
+func (o *Obfuscator) Obfuscate(s *model.Span) {
+	for k, v := range s.Meta {
+		if _, ok := o.removeKeys[k]; ok {
+			delete(s.Meta, k)
+			atomic.AddInt64(&o.removed.count, 1)
+			continue
+		}
+
+		changed := false
+		for _, r := range o.rules {
+			if r.key != anyKey && r.key != k {
+				continue
+			}
+			if nv := r.replace(v); nv != v {
+				atomic.AddInt64(&r.count, 1)
+				v = nv
+				changed = true
+			}
+		}
+		if changed {
+			s.Meta[k] = v
+		}
+	}
+}
+
+// logStats periodically reports the number of values each rule changed
+func (o *Obfuscator) logStats() {
+	rules := append([]*rule{&o.removed}, o.rules...)
+	for range time.Tick(statsInterval) {
+		for _, r := range rules {
+			if n := atomic.SwapInt64(&r.count, 0); n > 0 {
+				statsd.Client.Count("datadog.trace_agent.obfuscation.count", n, []string{"rule:" + r.name}, 1)
+			}
+		}
+	}
+}
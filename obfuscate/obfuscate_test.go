@@ -0,0 +1,137 @@
+package obfuscate
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestObfuscator(rules ...config.ObfuscationRule) *Obfuscator {
+	c := config.NewDefaultAgentConfig()
+	c.ObfuscationEnabled = true
+	c.ObfuscationRemoveKeys = []string{"http.request.headers.cookie"}
+	c.ObfuscationRules = rules
+	return NewObfuscator(c)
+}
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"5500-0000-0000-0004", true},
+		{"378282246310005", true},
+		{"4111111111111112", false},
+		{"1234567890123", false},
+		{"0000000000", false}, // too short
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.valid, luhn(test.in), test.in)
+	}
+}
+
+func TestDetectors(t *testing.T) {
+	o := newTestObfuscator()
+
+	tests := []struct {
+		in, out string
+	}{
+		{"paid with 4111 1111 1111 1111 today", "paid with ? today"},
+		{"card=5500-0000-0000-0004&id=12", "card=?&id=12"},
+		{"order 4111111111111112 not a card", "order 4111111111111112 not a card"},
+		{"created at 1512038400000000000", "created at 1512038400000000000"},
+		{"Bearer eyJhbGciOiJIUzI1NiJ9.e30.ZRrHA1JJJW8opsbCGfG_HACGpVUMN_a9IV7pAx_Zmeo=", "Bearer ?"},
+		{"Authorization: bearer abc-123 and more", "Authorization: bearer ? and more"},
+		{"/login?user=bob&password=hunter2&next=/", "/login?user=bob&password=?&next=/"},
+		{"https://s3/key?AccessKey=x&Signature=abc%2F12&Expires=1", "https://s3/key?AccessKey=x&Signature=?&Expires=1"},
+		{"/search?q=tokens&page=2", "/search?q=tokens&page=2"},
+	}
+
+	for _, test := range tests {
+		span := fixtures.RandomSpan()
+		span.Meta["test.value"] = test.in
+		o.Obfuscate(&span)
+		assert.Equal(t, test.out, span.Meta["test.value"], test.in)
+	}
+}
+
+func TestObfuscateRules(t *testing.T) {
+	assert := assert.New(t)
+
+	o := newTestObfuscator(
+		config.ObfuscationRule{Name: "emails", Key: "*", Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`, Replacement: "?"},
+		config.ObfuscationRule{Name: "users", Key: "http.url", Pattern: `/users/(\d+)`, Replacement: "/users/?"},
+		config.ObfuscationRule{Name: "invalid", Key: "*", Pattern: `(`, Replacement: "?"},
+	)
+	assert.Len(o.rules, 5) // built-in detectors, and the valid user rules
+
+	span := fixtures.RandomSpan()
+	span.Meta["http.url"] = "/users/42/mail?to=jane.doe@example.com"
+	span.Meta["path"] = "/users/42"
+	span.Meta["http.request.headers.cookie"] = "session=abc"
+	span.Meta["user.email"] = "jane.doe@example.com"
+	o.Obfuscate(&span)
+
+	assert.Equal("/users/?/mail?to=?", span.Meta["http.url"])
+	assert.Equal("/users/42", span.Meta["path"]) // not the key of the rule
+	assert.Equal("?", span.Meta["user.email"])
+	_, ok := span.Meta["http.request.headers.cookie"]
+	assert.False(ok)
+
+	counts := make(map[string]int64)
+	for _, r := range append(o.rules, &o.removed) {
+		counts[r.name] = r.count
+	}
+	assert.Equal(map[string]int64{
+		"credit_card":  0,
+		"bearer_token": 0,
+		"query_secret": 0,
+		"emails":       2,
+		"users":        1,
+		"remove_key":   1,
+	}, counts)
+}
+
+func TestObfuscateFixtures(t *testing.T) {
+	o := newTestObfuscator()
+
+	// random spans hold no sensitive data and are left untouched
+	for i := 0; i < 1000; i++ {
+		span := fixtures.RandomSpan()
+		meta := make(map[string]string, len(span.Meta))
+		for k, v := range span.Meta {
+			meta[k] = v
+		}
+		o.Obfuscate(&span)
+		assert.Equal(t, meta, span.Meta)
+	}
+
+	// the built-in detectors can be disabled
+	c := config.NewDefaultAgentConfig()
+	c.ObfuscationCreditCards = false
+	c.ObfuscationBearerTokens = false
+	c.ObfuscationQuerySecrets = nil
+	o = NewObfuscator(c)
+	span := fixtures.TestSpan()
+	span.Meta["http.url"] = "/pay?card=4111111111111111&token=abc"
+	o.Obfuscate(&span)
+	assert.Equal(t, "/pay?card=4111111111111111&token=abc", span.Meta["http.url"])
+}
+
+func BenchmarkObfuscate(b *testing.B) {
+	o := newTestObfuscator()
+	span := fixtures.TestSpan()
+	span.Meta["http.url"] = "/login?user=bob&password=hunter2&next=/"
+	span.Meta["sql.query"] = "SELECT * FROM users WHERE card = ?"
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		o.Obfuscate(&span)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"sort"
 )
 
+//go:generate msgp -marshal=false
+
 // SliceSummary is a GK-summary with a slice backend
 type SliceSummary struct {
 	Entries []Entry
@@ -0,0 +1,89 @@
+package quantile
+
+// NOTE: THIS FILE WAS PRODUCED BY THE
+// MSGP CODE GENERATION TOOL (github.com/tinylib/msgp)
+// DO NOT EDIT
+
+import (
+	"github.com/tinylib/msgp/msgp"
+)
+
+// DecodeMsg implements msgp.Decodable
+func (z *Entry) DecodeMsg(dc *msgp.Reader) (err error) {
+	var field []byte
+	_ = field
+	var zb0001 uint32
+	zb0001, err = dc.ReadMapHeader()
+	if err != nil {
+		return
+	}
+	for zb0001 > 0 {
+		zb0001--
+		field, err = dc.ReadMapKeyPtr()
+		if err != nil {
+			return
+		}
+		switch msgp.UnsafeString(field) {
+		case "v":
+			z.V, err = dc.ReadFloat64()
+			if err != nil {
+				return
+			}
+		case "g":
+			z.G, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		case "delta":
+			z.Delta, err = dc.ReadInt()
+			if err != nil {
+				return
+			}
+		default:
+			err = dc.Skip()
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// EncodeMsg implements msgp.Encodable
+func (z Entry) EncodeMsg(en *msgp.Writer) (err error) {
+	// map header, size 3
+	// write "v"
+	err = en.Append(0x83, 0xa1, 0x76)
+	if err != nil {
+		return
+	}
+	err = en.WriteFloat64(z.V)
+	if err != nil {
+		return
+	}
+	// write "g"
+	err = en.Append(0xa1, 0x67)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.G)
+	if err != nil {
+		return
+	}
+	// write "delta"
+	err = en.Append(0xa5, 0x64, 0x65, 0x6c, 0x74, 0x61)
+	if err != nil {
+		return
+	}
+	err = en.WriteInt(z.Delta)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z Entry) Msgsize() (s int) {
+	s = 1 + 2 + msgp.Float64Size + 2 + msgp.IntSize + 6 + msgp.IntSize
+	return
+}
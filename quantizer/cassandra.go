@@ -0,0 +1,110 @@
+package quantizer
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// cassandraBatchRegexp matches CQL batches and captures their type, their
+// USING clause and their statements
+var cassandraBatchRegexp = regexp.MustCompile(`(?is)^\s*BEGIN\s+(?:(UNLOGGED|COUNTER)\s+)?BATCH\s+(USING\s+TIMESTAMP\s+\S+\s+)?(.*?);?\s*APPLY\s+BATCH\s*;?\s*$`)
+
+// QuantizeCassandra generates resource and sql.query meta for Cassandra
+// spans. Batches are reduced to their distinct statements, once quantized,
+// other queries are quantized as SQL.
+func QuantizeCassandra(span model.Span) model.Span {
+	m := cassandraBatchRegexp.FindStringSubmatch(span.Resource)
+	if m == nil {
+		return QuantizeSQL(span)
+	}
+
+	var resource bytes.Buffer
+	resource.WriteString("BEGIN ")
+	if m[1] != "" {
+		resource.WriteString(strings.ToUpper(m[1]) + " ")
+	}
+	resource.WriteString("BATCH ")
+	if m[2] != "" {
+		resource.WriteString("USING TIMESTAMP ? ")
+	}
+
+	var tables []string
+	seen := make(map[string]struct{})
+	for _, stmt := range splitStatements(m[3]) {
+		quantized, err := tokenQuantizer.Process(stmt)
+		stmtTables, _ := tableFilter.Results()
+		tables = appendDistinct(tables, stmtTables...)
+		if err != nil || quantized == "" {
+			// let QuantizeSQL report the error
+			return QuantizeSQL(span)
+		}
+		if _, ok := seen[quantized]; ok {
+			continue
+		}
+		seen[quantized] = struct{}{}
+		resource.WriteString(quantized)
+		resource.WriteString("; ")
+	}
+	resource.WriteString("APPLY BATCH")
+	span.Resource = resource.String()
+	span = setSQLTables(span, tables, "BATCH")
+
+	// as QuantizeSQL, sql.query is only set if users did not set it
+	if span.Meta == nil {
+		span.Meta = make(map[string]string)
+	}
+	if span.Meta[sqlQueryTag] == "" {
+		span.Meta[sqlQueryTag] = span.Resource
+	}
+	return span
+}
+
+// appendDistinct appends to s the values it does not hold yet
+func appendDistinct(s []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, x := range s {
+			if x == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+// splitStatements splits the statements of a batch on the semicolons which
+// are not part of a quoted string or identifier
+func splitStatements(batch string) []string {
+	var stmts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(batch); i++ {
+		c := batch[i]
+		switch {
+		case quote != 0:
+			// quotes are escaped by doubling them, which this handles as
+			// two consecutive strings
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			if stmt := strings.TrimSpace(batch[start:i]); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			start = i + 1
+		}
+	}
+	if stmt := strings.TrimSpace(batch[start:]); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
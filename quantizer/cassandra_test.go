@@ -55,3 +55,39 @@ func TestCassQuantizer(t *testing.T) {
 		assert.Equal(testCase.expected, Quantize(CassSpan(testCase.in)).Resource)
 	}
 }
+
+func TestCassBatchQuantizer(t *testing.T) {
+	assert := assert.New(t)
+
+	queryToExpected := []struct{ in, expected string }{
+		// statements of the same shape are kept once
+		{
+			"BEGIN BATCH INSERT INTO users (id, name) VALUES (1, 'bob'); INSERT INTO users (id, name) VALUES (2, 'alice'); APPLY BATCH",
+			"BEGIN BATCH INSERT INTO users ( id, name ) VALUES ( ? ); APPLY BATCH",
+		},
+		{
+			"begin unlogged batch using timestamp 1481124356754405\n  insert into users (id, name) values (%s, %s);\n  update counts set n = %s where id = %s;\n  insert into users (id, name) values (%s, %s);\napply batch;",
+			"BEGIN UNLOGGED BATCH USING TIMESTAMP ? insert into users ( id, name ) values ( ? ); update counts set n = ? where id = ?; APPLY BATCH",
+		},
+		// semicolons in strings do not split statements
+		{
+			"BEGIN COUNTER BATCH UPDATE stats SET n = n + 1 WHERE k = 'a;b' APPLY BATCH",
+			"BEGIN COUNTER BATCH UPDATE stats SET n = n + ? WHERE k = ?; APPLY BATCH",
+		},
+		// not a batch
+		{
+			"SELECT * FROM batches WHERE id = 'BEGIN BATCH'",
+			"SELECT * FROM batches WHERE id = ?",
+		},
+	}
+
+	for _, testCase := range queryToExpected {
+		span := Quantize(CassSpan(testCase.in))
+		assert.Equal(testCase.expected, span.Resource)
+		assert.Equal(testCase.expected, span.Meta["sql.query"])
+	}
+
+	span := Quantize(CassSpan("BEGIN BATCH INSERT INTO users (id) VALUES (1); UPDATE counts SET n = 2; INSERT INTO users (id) VALUES (3); APPLY BATCH"))
+	assert.Equal("users,counts", span.Meta["sql.tables"])
+	assert.Equal("BATCH", span.Meta["sql.operation"])
+}
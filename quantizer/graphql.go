@@ -0,0 +1,183 @@
+package quantizer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// graphqlQueryTag is the meta holding the GraphQL document of spans, with
+// its literals obfuscated
+const graphqlQueryTag = "graphql.query"
+
+var errGraphQLString = errors.New("unterminated GraphQL string")
+
+// QuantizeGraphQL generates resource and graphql.query meta for GraphQL
+// spans. The resource, a GraphQL document, is reduced to the type and name
+// of its operations, like "query GetUser", and its literals are replaced by
+// "?" in graphql.query.
+func QuantizeGraphQL(span model.Span) model.Span {
+	if span.Resource == "" {
+		return span
+	}
+
+	ops, obfuscated, err := parseGraphQL(span.Resource)
+	if err != nil {
+		log.Debugf("Error parsing the query: `%s`", span.Resource)
+		if span.Meta == nil {
+			span.Meta = make(map[string]string)
+		}
+		span.Meta[sqlQuantizeError] = "Query not parsed"
+		if _, ok := span.Meta[graphqlQueryTag]; !ok {
+			span.Meta[graphqlQueryTag] = span.Resource
+		}
+		span.Resource = "Non-parsable GraphQL query"
+		return span
+	}
+	if len(ops) == 0 {
+		// only fragments, or not a document
+		return span
+	}
+
+	// as QuantizeSQL, graphql.query is only set if users did not set it
+	if span.Meta == nil {
+		span.Meta = make(map[string]string)
+	}
+	if span.Meta[graphqlQueryTag] == "" {
+		span.Meta[graphqlQueryTag] = obfuscated
+	}
+	span.Resource = strings.Join(ops, ", ")
+	return span
+}
+
+// parseGraphQL returns the operations of the GraphQL document doc, as their
+// type and name, and doc with its string and number literals replaced by "?",
+// its comments removed and its spaces compacted.
+func parseGraphQL(doc string) (ops []string, obfuscated string, err error) {
+	var out bytes.Buffer
+	depth, parens := 0, 0 // nesting of braces and parentheses
+	definition := ""      // the keyword of the definition being read
+	expectName := false   // true if the next name is the one of an operation
+	for i := 0; i < len(doc); i++ {
+		c := doc[i]
+		switch {
+		case c == '#':
+			// comments run to the end of the line
+			if j := strings.IndexByte(doc[i:], '\n'); j >= 0 {
+				i += j - 1
+			} else {
+				i = len(doc)
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if out.Len() > 0 && out.Bytes()[out.Len()-1] != ' ' {
+				out.WriteByte(' ')
+			}
+		case c == '"':
+			end, err := graphqlStringEnd(doc, i)
+			if err != nil {
+				return nil, "", err
+			}
+			out.WriteByte('?')
+			i = end
+		case (c >= '0' && c <= '9') || (c == '-' && i+1 < len(doc) && doc[i+1] >= '0' && doc[i+1] <= '9'):
+			for i+1 < len(doc) && isGraphQLNumberChar(doc[i+1]) {
+				i++
+			}
+			out.WriteByte('?')
+		case isGraphQLNameStart(c):
+			start := i
+			for i+1 < len(doc) && isGraphQLNameChar(doc[i+1]) {
+				i++
+			}
+			name := doc[start : i+1]
+			out.WriteString(name)
+			if depth > 0 || parens > 0 {
+				continue
+			}
+			switch {
+			case expectName:
+				ops = append(ops, definition+" "+name)
+				expectName = false
+			case name == "query" || name == "mutation" || name == "subscription":
+				definition, expectName = name, true
+			case name == "fragment":
+				definition, expectName = name, false
+			}
+		default:
+			out.WriteByte(c)
+			switch c {
+			case '(':
+				parens++
+			case ')':
+				parens--
+			case '{':
+				if depth == 0 && parens == 0 {
+					switch {
+					case definition == "":
+						// the query shorthand
+						ops = append(ops, "query")
+					case expectName:
+						// an anonymous operation
+						ops = append(ops, definition)
+					}
+					definition, expectName = "", false
+				}
+				depth++
+			case '}':
+				depth--
+			}
+			if depth < 0 || parens < 0 {
+				return nil, "", errors.New("unbalanced GraphQL document")
+			}
+		}
+	}
+	if depth != 0 || parens != 0 {
+		return nil, "", errors.New("unbalanced GraphQL document")
+	}
+	return ops, strings.TrimSpace(out.String()), nil
+}
+
+// graphqlStringEnd returns the index of the closing quote of the string or
+// block string starting at index i of doc
+func graphqlStringEnd(doc string, i int) (int, error) {
+	if strings.HasPrefix(doc[i:], `"""`) {
+		for j := i + 3; j+2 < len(doc); j++ {
+			if doc[j] == '\\' {
+				j++
+				continue
+			}
+			if doc[j:j+3] == `"""` {
+				return j + 2, nil
+			}
+		}
+		return 0, errGraphQLString
+	}
+
+	for j := i + 1; j < len(doc); j++ {
+		switch doc[j] {
+		case '\\':
+			j++
+		case '"':
+			return j, nil
+		case '\n':
+			return 0, errGraphQLString
+		}
+	}
+	return 0, errGraphQLString
+}
+
+func isGraphQLNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return isGraphQLNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isGraphQLNumberChar(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+}
@@ -0,0 +1,94 @@
+package quantizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+type graphqlTestCase struct {
+	query            string
+	expectedResource string
+	expectedQuery    string
+}
+
+func GraphQLSpan(query string) model.Span {
+	return model.Span{
+		Resource: query,
+		Type:     "graphql",
+	}
+}
+
+func TestGraphQLQuantizer(t *testing.T) {
+	assert := assert.New(t)
+
+	queryToExpected := []graphqlTestCase{
+		{`query GetUser($id: ID!) { user(id: $id) { name email } }`,
+			"query GetUser",
+			`query GetUser($id: ID!) { user(id: $id) { name email } }`},
+
+		{`{ user(id: 42, name: "bob") { friends(first: -10, score: 1.5e3) { name } } }`,
+			"query",
+			`{ user(id: ?, name: ?) { friends(first: ?, score: ?) { name } } }`},
+
+		{"mutation {\n  createUser(input: {name: \"bob\", age: 42, admin: true}) {\n    id # the new id\n  }\n}",
+			"mutation",
+			`mutation { createUser(input: {name: ?, age: ?, admin: true}) { id } }`},
+
+		{`subscription OnComment($post: ID = "p1") { comment(post: $post) { body } }`,
+			"subscription OnComment",
+			`subscription OnComment($post: ID = ?) { comment(post: $post) { body } }`},
+
+		{`query Search { search(text: """multi "line" text""") { ...Result } } fragment Result on Item { id }`,
+			"query Search",
+			`query Search { search(text: ?) { ...Result } } fragment Result on Item { id }`},
+
+		{`query A { a } mutation B($f: In = {x: 1}) { b(f: $f) }`,
+			"query A, mutation B",
+			`query A { a } mutation B($f: In = {x: ?}) { b(f: $f) }`},
+
+		{`query user2 { user2(name: "a\"b") { id } }`,
+			"query user2",
+			`query user2 { user2(name: ?) { id } }`},
+	}
+
+	for _, testCase := range queryToExpected {
+		span := Quantize(GraphQLSpan(testCase.query))
+		assert.Equal(testCase.expectedResource, span.Resource, testCase.query)
+		assert.Equal(testCase.expectedQuery, span.Meta["graphql.query"], testCase.query)
+	}
+}
+
+func TestGraphQLQuantizerNoOperation(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, query := range []string{"", "fragment F on User { id }", "graphql.execute"} {
+		span := Quantize(GraphQLSpan(query))
+		assert.Equal(query, span.Resource)
+		assert.NotContains(span.Meta, "graphql.query")
+	}
+
+	// users tags are kept as is
+	span := GraphQLSpan(`query Q { user(id: 1) { id } }`)
+	span.Meta = map[string]string{"graphql.query": "custom"}
+	assert.Equal("custom", Quantize(span).Meta["graphql.query"])
+}
+
+func TestGraphQLQuantizerError(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []string{
+		`query Q { user(name: "bob) { id } }`,
+		`query Q { user { id }`,
+		`query Q { user { id } } }`,
+		`query Q { search(text: """open) { id } }`,
+	}
+
+	for _, query := range queries {
+		span := Quantize(GraphQLSpan(query))
+		assert.Equal("Non-parsable GraphQL query", span.Resource, query)
+		assert.Equal("Query not parsed", span.Meta["agent.parse.error"], query)
+	}
+}
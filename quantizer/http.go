@@ -0,0 +1,180 @@
+package quantizer
+
+import (
+	"regexp"
+	"strings"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// httpRawResourceKey is the meta key holding the resource of http spans
+// before normalization
+const httpRawResourceKey = "http.raw_resource"
+
+// minHashLen is the minimum length of the hexadecimal path segments considered
+// as hashes, shorter ones are likely to be words
+const minHashLen = 16
+
+// placeholders of the path segments replaced by default
+const (
+	idPlaceholder   = "{id}"
+	uuidPlaceholder = "{uuid}"
+	hashPlaceholder = "{hash}"
+)
+
+// segmentPattern replaces the path segments matching re by placeholder
+type segmentPattern struct {
+	re          *regexp.Regexp
+	placeholder string
+}
+
+// httpQuantizer normalizes the resources of http and web spans, which are
+// usually the method and the raw path of requests, so that requests on the
+// same route share the same resource
+type httpQuantizer struct {
+	services map[string]struct{} // the services which opted in
+	patterns []segmentPattern    // custom segments, tried before the default ones
+}
+
+// newHTTPQuantizer returns a quantizer for the http resources of the services
+// of conf, or nil if none of them opted in. Invalid patterns are logged and
+// ignored.
+func newHTTPQuantizer(conf *config.AgentConfig) *httpQuantizer {
+	if len(conf.HTTPQuantizerServices) == 0 {
+		return nil
+	}
+
+	q := &httpQuantizer{services: make(map[string]struct{}, len(conf.HTTPQuantizerServices))}
+	for _, s := range conf.HTTPQuantizerServices {
+		q.services[strings.TrimSpace(s)] = struct{}{}
+	}
+	for _, p := range conf.HTTPQuantizerPatterns {
+		// patterns match whole segments
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			log.Errorf("invalid http quantizer pattern %s: %v", p.Name, err)
+			continue
+		}
+		q.patterns = append(q.patterns, segmentPattern{re: re, placeholder: "{" + p.Name + "}"})
+	}
+	return q
+}
+
+// quantize normalizes the resource of span if its service opted in, the
+// original resource is kept in the http.raw_resource meta
+func (q *httpQuantizer) quantize(span model.Span) model.Span {
+	if _, ok := q.services[span.Service]; !ok {
+		return span
+	}
+
+	resource := q.normalize(span.Resource)
+	if resource == span.Resource {
+		return span
+	}
+	if span.Meta == nil {
+		span.Meta = make(map[string]string)
+	}
+	span.Meta[httpRawResourceKey] = span.Resource
+	span.Resource = resource
+	return span
+}
+
+// normalize returns resource, made of an optional method and of a path or an
+// absolute URL, without query string and with the variable segments of the
+// path replaced by placeholders. Resources holding no path, like the names of
+// controllers, are returned as is.
+func (q *httpQuantizer) normalize(resource string) string {
+	method, path := "", resource
+	if i := strings.IndexByte(resource, ' '); i > 0 {
+		method, path = resource[:i+1], strings.TrimLeft(resource[i+1:], " ")
+	}
+
+	prefix := ""
+	if i := strings.Index(path, "://"); i > 0 {
+		// keep the scheme and the host of absolute URLs
+		j := strings.IndexAny(path[i+3:], "/?#")
+		if j < 0 {
+			return resource
+		}
+		prefix, path = path[:i+3+j], path[i+3+j:]
+	} else if !strings.HasPrefix(path, "/") {
+		return resource
+	}
+
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if s != "" {
+			segments[i] = q.placeholder(s)
+		}
+	}
+	return method + prefix + strings.Join(segments, "/")
+}
+
+// placeholder returns the placeholder replacing the path segment s, or s if
+// it should be kept
+func (q *httpQuantizer) placeholder(s string) string {
+	for _, p := range q.patterns {
+		if p.re.MatchString(s) {
+			return p.placeholder
+		}
+	}
+
+	switch {
+	case isUUID(s):
+		return uuidPlaceholder
+	case isDigits(s):
+		return idPlaceholder
+	case len(s) >= minHashLen && isHex(s):
+		return hashPlaceholder
+	}
+	return s
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexChar(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHexChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID tells if s is a UUID in its canonical 8-4-4-4-12 form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHexChar(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
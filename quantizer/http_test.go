@@ -0,0 +1,115 @@
+package quantizer
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type httpTestCase struct {
+	resource string
+	expected string
+}
+
+func HTTPSpan(service, resource string) model.Span {
+	return model.Span{
+		Service:  service,
+		Resource: resource,
+		Type:     "http",
+	}
+}
+
+func newTestQuantizer(services ...string) *Quantizer {
+	conf := config.NewDefaultAgentConfig()
+	conf.HTTPQuantizerServices = services
+	conf.HTTPQuantizerPatterns = []config.HTTPQuantizerPattern{
+		{Name: "sku", Pattern: `[A-Z]{3}-\d+`},
+		{Name: "invalid", Pattern: `(`},
+	}
+	return NewQuantizer(conf)
+}
+
+func TestHTTPQuantizer(t *testing.T) {
+	assert := assert.New(t)
+	q := newTestQuantizer("web")
+
+	queryToExpected := []httpTestCase{
+		{"GET /users/81723/orders/9b2f7c1e-4a5d-4c3b-8e6f-0a1b2c3d4e5f",
+			"GET /users/{id}/orders/{uuid}"},
+
+		{"GET /users/81723/orders/9b2f7c1e-4a5d-4c3b-8e6f-0a1b2c3d4e5f?expand=items&page=2",
+			"GET /users/{id}/orders/{uuid}"},
+
+		{"POST /files/d41d8cd98f00b204e9800998ecf8427e/",
+			"POST /files/{hash}/"},
+
+		{"GET /products/ABC-1234/reviews#top",
+			"GET /products/{sku}/reviews"},
+
+		{"/v1/cafe/42",
+			"/v1/cafe/{id}"},
+
+		{"GET https://api.example.com:8443/accounts/42?token=abc",
+			"GET https://api.example.com:8443/accounts/{id}"},
+
+		{"GET http://api.example.com?id=42",
+			"GET http://api.example.com"},
+
+		// short hexadecimal segments are likely words
+		{"GET /decade/facade",
+			"GET /decade/facade"},
+
+		// resources which are not paths are left untouched
+		{"UsersController#show",
+			"UsersController#show"},
+
+		{"GET 200",
+			"GET 200"},
+
+		{"",
+			""},
+	}
+
+	for _, testCase := range queryToExpected {
+		span := q.Quantize(HTTPSpan("web", testCase.resource))
+		assert.Equal(testCase.expected, span.Resource, testCase.resource)
+		if testCase.expected != testCase.resource {
+			assert.Equal(testCase.resource, span.Meta["http.raw_resource"], testCase.resource)
+		} else {
+			assert.NotContains(span.Meta, "http.raw_resource", testCase.resource)
+		}
+	}
+}
+
+func TestHTTPQuantizerOptIn(t *testing.T) {
+	assert := assert.New(t)
+	resource := "GET /users/81723"
+
+	// only the services which opted in are quantized
+	q := newTestQuantizer("web")
+	assert.Equal("GET /users/{id}", q.Quantize(HTTPSpan("web", resource)).Resource)
+	assert.Equal(resource, q.Quantize(HTTPSpan("api", resource)).Resource)
+
+	span := HTTPSpan("web", resource)
+	span.Type = "web"
+	assert.Equal("GET /users/{id}", q.Quantize(span).Resource)
+
+	q = newTestQuantizer()
+	assert.Equal(resource, q.Quantize(HTTPSpan("web", resource)).Resource)
+
+	// other types are quantized as usual
+	assert.Equal("SELECT * FROM users WHERE id = ?", q.Quantize(SQLSpan("SELECT * FROM users WHERE id = 42")).Resource)
+}
+
+func BenchmarkHTTPQuantizer(b *testing.B) {
+	q := newTestQuantizer("web")
+	span := HTTPSpan("web", "GET /users/81723/orders/9b2f7c1e-4a5d-4c3b-8e6f-0a1b2c3d4e5f?expand=items")
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		q.Quantize(span)
+	}
+}
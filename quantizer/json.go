@@ -0,0 +1,164 @@
+package quantizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// jsonMaxLen is the maximum length of quantized JSON documents, longer ones
+// are truncated and end with jsonTruncationMark
+const jsonMaxLen = 1000
+
+const jsonTruncationMark = "..."
+
+// jsonQueryTags are the meta holding the JSON query of spans, by span type
+var jsonQueryTags = map[string]string{
+	mongoType:         "mongodb.query",
+	elasticsearchType: "elasticsearch.body",
+}
+
+var errInvalidJSON = errors.New("invalid JSON document")
+
+// QuantizeJSON generates resource and query meta for the spans of JSON based
+// data stores: every literal value of the JSON document found in the resource
+// or in the query meta is replaced by "?", and arrays are reduced to their
+// distinct elements, while keys and structure are kept.
+func QuantizeJSON(span model.Span) model.Span {
+	tag := jsonQueryTags[span.Type]
+
+	if i := jsonStart(span.Resource); i >= 0 {
+		quantized, err := quantizeJSON(span.Resource[i:])
+		if err != nil {
+			log.Debugf("Error parsing the query: `%s`", span.Resource)
+			if span.Meta == nil {
+				span.Meta = make(map[string]string)
+			}
+			span.Meta[sqlQuantizeError] = "Query not parsed"
+			if _, ok := span.Meta[tag]; !ok {
+				span.Meta[tag] = span.Resource
+			}
+			span.Resource = "Non-parsable JSON query"
+			return span
+		}
+		span.Resource = span.Resource[:i] + quantized
+	}
+
+	if query := span.Meta[tag]; query != "" {
+		quantized, err := quantizeJSON(query)
+		if err != nil {
+			log.Debugf("Error parsing the query: `%s`", query)
+			span.Meta[sqlQuantizeError] = "Query not parsed"
+			return span
+		}
+		span.Meta[tag] = quantized
+	}
+	return span
+}
+
+// jsonStart returns the index of the JSON document of resource, which starts
+// it or follows a space, like in `find users {"name": "bob"}`, or -1
+func jsonStart(resource string) int {
+	for i := 0; i < len(resource); i++ {
+		if (resource[i] == '{' || resource[i] == '[') && (i == 0 || resource[i-1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// quantizeJSON returns the quantized form of the JSON document doc
+func quantizeJSON(doc string) (string, error) {
+	var buf bytes.Buffer
+	dec := json.NewDecoder(strings.NewReader(doc))
+	if err := quantizeJSONValue(dec, &buf); err != nil {
+		return "", err
+	}
+	// nothing but spaces may follow the document
+	if _, err := dec.Token(); err != io.EOF {
+		return "", errInvalidJSON
+	}
+
+	if buf.Len() > jsonMaxLen {
+		// do not cut a multi-byte character in the middle
+		n := jsonMaxLen
+		for n > 0 && !utf8.RuneStart(buf.Bytes()[n]) {
+			n--
+		}
+		buf.Truncate(n)
+		buf.WriteString(jsonTruncationMark)
+	}
+	return buf.String(), nil
+}
+
+// quantizeJSONValue reads the next value of dec and writes its quantized form
+// to buf
+func quantizeJSONValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		buf.WriteString(`"?"`)
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		buf.WriteByte('{')
+		for n := 0; dec.More(); n++ {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			k, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := quantizeJSONValue(dec, buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case '[':
+		// once quantized, many elements are the same, only the distinct
+		// ones are kept so that the length of arrays does not matter
+		buf.WriteByte('[')
+		seen := make(map[string]struct{})
+		for dec.More() {
+			var elem bytes.Buffer
+			if err := quantizeJSONValue(dec, &elem); err != nil {
+				return err
+			}
+			if _, ok := seen[elem.String()]; ok {
+				continue
+			}
+			if len(seen) > 0 {
+				buf.WriteByte(',')
+			}
+			seen[elem.String()] = struct{}{}
+			buf.Write(elem.Bytes())
+		}
+		buf.WriteByte(']')
+	default:
+		return errInvalidJSON
+	}
+
+	// the closing delimiter
+	_, err = dec.Token()
+	return err
+}
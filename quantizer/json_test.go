@@ -0,0 +1,145 @@
+package quantizer
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonTestCase struct {
+	query    string
+	expected string
+}
+
+func MongoSpan(query string) model.Span {
+	return model.Span{
+		Resource: query,
+		Type:     "mongodb",
+	}
+}
+
+func ElasticsearchSpan(body string) model.Span {
+	return model.Span{
+		Resource: "GET /logs/_search",
+		Type:     "elasticsearch",
+		Meta: map[string]string{
+			"elasticsearch.body": body,
+		},
+	}
+}
+
+func TestJSONQuantizer(t *testing.T) {
+	assert := assert.New(t)
+
+	queryToExpected := []jsonTestCase{
+		{`{"name": "bob", "age": 42}`,
+			`{"name":"?","age":"?"}`},
+
+		{`find users {"name": "bob", "admin": true, "manager": null}`,
+			`find users {"name":"?","admin":"?","manager":"?"}`},
+
+		{`{"age": {"$gt": 18, "$lt": 65.5}}`,
+			`{"age":{"$gt":"?","$lt":"?"}}`},
+
+		{`{"_id": {"$in": [1, 2, 3, 4, 5, 6]}}`,
+			`{"_id":{"$in":["?"]}}`},
+
+		{`{"_id": {"$in": [1]}}`,
+			`{"_id":{"$in":["?"]}}`},
+
+		{`{"$or": [{"a": 1}, {"b": "x"}, {"a": 2}]}`,
+			`{"$or":[{"a":"?"},{"b":"?"}]}`},
+
+		{`aggregate orders [{"$match": {"status": "A"}}, {"$group": {"_id": "$cust_id", "total": {"$sum": "$amount"}}}]`,
+			`aggregate orders [{"$match":{"status":"?"}},{"$group":{"_id":"?","total":{"$sum":"?"}}}]`},
+
+		{`{"tags": [], "nested": {}}`,
+			`{"tags":[],"nested":{}}`},
+
+		{`  {"a" : [ [1, 2], [3] ] }  `,
+			`  {"a":[["?"]]}`},
+
+		// no JSON document
+		{`drop users`,
+			`drop users`},
+
+		{`count users_{id}`,
+			`count users_{id}`},
+	}
+
+	for _, testCase := range queryToExpected {
+		span := QuantizeJSON(MongoSpan(testCase.query))
+		assert.Equal(testCase.expected, span.Resource, testCase.query)
+		assert.NotContains(span.Meta, "agent.parse.error", testCase.query)
+	}
+}
+
+func TestJSONQuantizerMeta(t *testing.T) {
+	assert := assert.New(t)
+
+	span := Quantize(ElasticsearchSpan(`{"query": {"match": {"user": "kimchy"}}, "size": 10}`))
+	assert.Equal("GET /logs/_search", span.Resource)
+	assert.Equal(`{"query":{"match":{"user":"?"}},"size":"?"}`, span.Meta["elasticsearch.body"])
+
+	span = Quantize(ElasticsearchSpan(`{"query": `))
+	assert.Equal("GET /logs/_search", span.Resource)
+	assert.Equal("Query not parsed", span.Meta["agent.parse.error"])
+}
+
+func TestJSONQuantizerError(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []string{
+		`{"name": "bob"`,
+		`{"name": "bob"}}`,
+		`find users {"name": "bob"} limit 1`,
+		`{name: "bob"}`,
+		`[1, 2,]`,
+	}
+
+	for _, query := range queries {
+		span := Quantize(MongoSpan(query))
+		assert.Equal("Non-parsable JSON query", span.Resource, query)
+		assert.Equal("Query not parsed", span.Meta["agent.parse.error"], query)
+		assert.Equal(query, span.Meta["mongodb.query"], query)
+	}
+}
+
+func TestJSONQuantizerMaxLen(t *testing.T) {
+	assert := assert.New(t)
+
+	var keys []string
+	for i := 0; i < 500; i++ {
+		keys = append(keys, `"key`+strings.Repeat("x", i%10)+string('a'+rune(i%26))+`": 1`)
+	}
+	span := Quantize(MongoSpan("{" + strings.Join(keys, ",") + "}"))
+	assert.Len(span.Resource, jsonMaxLen+len(jsonTruncationMark))
+	assert.True(strings.HasSuffix(span.Resource, "..."))
+}
+
+func TestJSONQuantizerMaxLenUTF8(t *testing.T) {
+	assert := assert.New(t)
+
+	// shift the keys so that the truncation falls on every byte of a rune
+	for shift := 0; shift < 4; shift++ {
+		key := strings.Repeat("x", shift) + strings.Repeat("日本語", 500)
+		span := Quantize(MongoSpan(`{"` + key + `": 1}`))
+		assert.True(utf8.ValidString(span.Resource), "shift %d", shift)
+		assert.True(len(span.Resource) <= jsonMaxLen+len(jsonTruncationMark), "shift %d", shift)
+		assert.True(len(span.Resource) > jsonMaxLen-utf8.UTFMax+len(jsonTruncationMark), "shift %d", shift)
+		assert.True(strings.HasSuffix(span.Resource, jsonTruncationMark), "shift %d", shift)
+	}
+}
+
+func BenchmarkJSONQuantizer(b *testing.B) {
+	span := MongoSpan(`find users {"$or": [{"age": {"$gt": 18}}, {"name": {"$in": ["bob", "alice", "carol"]}}]}`)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		QuantizeJSON(span)
+	}
+}
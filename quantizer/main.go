@@ -3,16 +3,23 @@ package quantizer
 import (
 	"regexp"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 )
 
 const (
-	sqlType       = "sql"
-	redisType     = "redis"
-	cassandraType = "cassandra"
-	tabCode       = uint8(9)
-	newLineCode   = uint8(10)
-	spaceCode     = uint8(32)
+	sqlType           = "sql"
+	redisType         = "redis"
+	cassandraType     = "cassandra"
+	httpType          = "http"
+	webType           = "web"
+	mongoType         = "mongodb"
+	elasticsearchType = "elasticsearch"
+	memcachedType     = "memcached"
+	graphqlType       = "graphql"
+	tabCode           = uint8(9)
+	newLineCode       = uint8(10)
+	spaceCode         = uint8(32)
 )
 
 var nonUniformSpacesRegexp = regexp.MustCompile("\\s+")
@@ -26,14 +33,55 @@ func Quantize(span model.Span) model.Span {
 	case sqlType:
 		return QuantizeSQL(span)
 	case cassandraType:
-		return QuantizeSQL(span)
+		return QuantizeCassandra(span)
 	case redisType:
 		return QuantizeRedis(span)
+	case mongoType, elasticsearchType:
+		return QuantizeJSON(span)
+	case memcachedType:
+		return QuantizeMemcached(span)
+	case graphqlType:
+		return QuantizeGraphQL(span)
 	default:
 		return span
 	}
 }
 
+// Quantizer generates resources for spans like Quantize does, plus the
+// quantizations which depend on the configuration of the agent
+type Quantizer struct {
+	http               *httpQuantizer // nil if no service opted in
+	redisObfuscateArgs bool
+}
+
+// NewQuantizer returns a Quantizer configured by conf
+func NewQuantizer(conf *config.AgentConfig) *Quantizer {
+	return &Quantizer{
+		http:               newHTTPQuantizer(conf),
+		redisObfuscateArgs: conf.RedisObfuscateArgs,
+	}
+}
+
+// Quantize generates meaningful resource for a span, depending on its type
+// and on the configuration
+func (q *Quantizer) Quantize(span model.Span) model.Span {
+	switch span.Type {
+	case httpType, webType:
+		if q.http != nil {
+			return q.http.quantize(span)
+		}
+		return span
+	case redisType:
+		span = QuantizeRedis(span)
+		if q.redisObfuscateArgs {
+			span = ObfuscateRedisRawCommand(span)
+		}
+		return span
+	default:
+		return Quantize(span)
+	}
+}
+
 func isGenericSpace(char uint8) bool {
 	return char == spaceCode || char == tabCode || char == newLineCode
 }
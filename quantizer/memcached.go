@@ -0,0 +1,22 @@
+package quantizer
+
+import (
+	"strings"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// QuantizeMemcached generates resource for memcached spans: only the command
+// is kept, keys and values are dropped
+func QuantizeMemcached(span model.Span) model.Span {
+	command := strings.TrimSpace(span.Resource)
+	if i := strings.IndexAny(command, " \t\r\n"); i >= 0 {
+		command = command[:i]
+	}
+	if command == "" {
+		return span
+	}
+
+	span.Resource = strings.ToLower(command)
+	return span
+}
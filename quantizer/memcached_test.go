@@ -0,0 +1,52 @@
+package quantizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+type memcachedTestCase struct {
+	query            string
+	expectedResource string
+}
+
+func MemcachedSpan(query string) model.Span {
+	return model.Span{
+		Resource: query,
+		Type:     "memcached",
+	}
+}
+
+func TestMemcachedQuantizer(t *testing.T) {
+	assert := assert.New(t)
+
+	queryToExpected := []memcachedTestCase{
+		{"get user:42",
+			"get"},
+
+		{"gets user:42 user:43 user:44",
+			"gets"},
+
+		{"set session:abc 0 3600 5\r\nhello",
+			"set"},
+
+		{"  INCR counter 1",
+			"incr"},
+
+		{"flush_all",
+			"flush_all"},
+
+		{"\n\t  ",
+			"\n\t  "},
+
+		{"",
+			""},
+	}
+
+	for _, testCase := range queryToExpected {
+		assert.Equal(testCase.expectedResource, Quantize(MemcachedSpan(testCase.query)).Resource)
+	}
+}
@@ -78,3 +78,144 @@ func QuantizeRedis(span model.Span) model.Span {
 
 	return span
 }
+
+// redisRawCommandTag is the meta holding the commands of redis spans, as sent
+const redisRawCommandTag = "redis.raw_command"
+
+// redisArgsRule replaces by "?" the arguments of a redis command which are
+// not keys, args being the arguments following the command
+type redisArgsRule func(args []string)
+
+// redisArgsRules are the rules of the commands whose arguments are not a key
+// followed by values
+var redisArgsRules = map[string]redisArgsRule{
+	// AUTH [username] password
+	"AUTH": obfuscateRedisArgs(0),
+	// MSET key value [key value ...]
+	"MSET":   obfuscateRedisPairs(0),
+	"MSETNX": obfuscateRedisPairs(0),
+	// HSET key field value [field value ...]
+	"HSET":   obfuscateRedisPairs(1),
+	"HSETNX": obfuscateRedisPairs(1),
+	"HMSET":  obfuscateRedisPairs(1),
+	// SET key value [EX seconds|PX milliseconds|NX|XX|KEEPTTL|GET]
+	"SET": obfuscateRedisSet,
+}
+
+// redisSetOptions are the options of SET, which are not obfuscated
+var redisSetOptions = map[string]bool{
+	"EX": true, "PX": true, "EXAT": true, "PXAT": true, "NX": true, "XX": true, "KEEPTTL": true, "GET": true}
+
+// ObfuscateRedisRawCommand replaces by "?" the arguments of the commands of
+// the redis.raw_command meta of span, except their keys
+func ObfuscateRedisRawCommand(span model.Span) model.Span {
+	raw, ok := span.Meta[redisRawCommandTag]
+	if !ok {
+		return span
+	}
+
+	lines := strings.Split(raw, "\n")
+	for i, line := range lines {
+		lines[i] = obfuscateRedisCommand(line)
+	}
+	span.Meta[redisRawCommandTag] = strings.Join(lines, "\n")
+	return span
+}
+
+// obfuscateRedisCommand obfuscates the arguments of a single command, the
+// arguments which are truncated keep the truncation mark
+func obfuscateRedisCommand(line string) string {
+	args := splitRedisArgs(line)
+	if len(args) == 0 {
+		return strings.TrimSpace(line)
+	}
+
+	command := strings.ToUpper(args[0])
+	args = args[1:]
+	if redisCompoundCommandSet[command] && len(args) > 0 {
+		command += " " + strings.ToUpper(args[0])
+		args = args[1:]
+	}
+
+	// keep the original arguments to restore their truncation marks
+	orig := make([]string, len(args))
+	copy(orig, args)
+	if rule, ok := redisArgsRules[command]; ok {
+		rule(args)
+	} else {
+		// a key followed by values
+		obfuscateRedisArgs(1)(args)
+	}
+	for i := range args {
+		if args[i] == "?" && strings.HasSuffix(orig[i], redisTruncationMark) {
+			args[i] += redisTruncationMark
+		}
+	}
+
+	if len(args) == 0 {
+		return command
+	}
+	return command + " " + strings.Join(args, " ")
+}
+
+// obfuscateRedisArgs returns a rule keeping the first n arguments
+func obfuscateRedisArgs(n int) redisArgsRule {
+	return func(args []string) {
+		for i := n; i < len(args); i++ {
+			args[i] = "?"
+		}
+	}
+}
+
+// obfuscateRedisPairs returns a rule keeping the first n arguments, then the
+// first of each pair of arguments
+func obfuscateRedisPairs(n int) redisArgsRule {
+	return func(args []string) {
+		for i := n + 1; i < len(args); i += 2 {
+			args[i] = "?"
+		}
+	}
+}
+
+// obfuscateRedisSet keeps the key and the options of SET
+func obfuscateRedisSet(args []string) {
+	for i := 1; i < len(args); i++ {
+		if i > 1 && redisSetOptions[strings.ToUpper(args[i])] {
+			continue
+		}
+		args[i] = "?"
+	}
+}
+
+// splitRedisArgs splits a command on spaces, except the spaces of double
+// quoted arguments
+func splitRedisArgs(line string) []string {
+	var args []string
+	var arg bytes.Buffer
+	inArg, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			arg.WriteByte(c)
+			arg.WriteByte(line[i+1])
+			i++
+			continue
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\r'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		}
+		arg.WriteByte(c)
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 )
 
@@ -85,6 +86,80 @@ func TestRedisQuantizer(t *testing.T) {
 
 }
 
+func TestRedisObfuscateArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	queryToExpected := []redisTestCase{
+		{"AUTH my-secret-password",
+			"AUTH ?"},
+
+		{"AUTH admin my-secret-password",
+			"AUTH ? ?"},
+
+		{"SET session:42 eyJhbGciOiJIUzI1NiJ9 EX 3600 NX",
+			"SET session:42 ? EX ? NX"},
+
+		{"set k1 \"hello world\"",
+			"SET k1 ?"},
+
+		{"GET session:42",
+			"GET session:42"},
+
+		{"MSET k1 v1 k2 v2 k3",
+			"MSET k1 ? k2 ? k3"},
+
+		{"HSET user:1 name bob email bob@example.com",
+			"HSET user:1 name ? email ?"},
+
+		{"HMSET k1 \"a\" 1 \"b\" 2",
+			"HMSET k1 \"a\" ? \"b\" ?"},
+
+		{"LPUSH queue job1 job2",
+			"LPUSH queue ? ?"},
+
+		{"CONFIG SET requirepass secret",
+			"CONFIG SET requirepass ?"},
+
+		{"PING",
+			"PING"},
+
+		{"SET k1 v1\nEXPIRE k1 15\nAUTH pass",
+			"SET k1 ?\nEXPIRE k1 ?\nAUTH ?"},
+
+		// truncated commands keep their truncation mark
+		{"SET k1 some-long-tok...",
+			"SET k1 ?..."},
+
+		{"SET k1 \"some long tok...",
+			"SET k1 ?..."},
+
+		{"GET k...",
+			"GET k..."},
+
+		{"SET k1 v1\nGE...",
+			"SET k1 ?\nGE..."},
+	}
+
+	conf := config.NewDefaultAgentConfig()
+	conf.RedisObfuscateArgs = true
+	q := NewQuantizer(conf)
+
+	for _, testCase := range queryToExpected {
+		span := RedisSpan(testCase.query)
+		span.Meta = map[string]string{"redis.raw_command": testCase.query}
+		span = q.Quantize(span)
+		assert.Equal(testCase.expectedResource, span.Meta["redis.raw_command"], testCase.query)
+		assert.Equal(Quantize(RedisSpan(testCase.query)).Resource, span.Resource, testCase.query)
+	}
+
+	// without the mode, the raw command is kept as is
+	span := RedisSpan("AUTH my-secret-password")
+	span.Meta = map[string]string{"redis.raw_command": "AUTH my-secret-password"}
+	span = NewQuantizer(config.NewDefaultAgentConfig()).Quantize(span)
+	assert.Equal("AUTH", span.Resource)
+	assert.Equal("AUTH my-secret-password", span.Meta["redis.raw_command"])
+}
+
 func BenchmarkTestRedisQuantizer(b *testing.B) {
 	b.ReportAllocs()
 
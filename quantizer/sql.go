@@ -3,6 +3,7 @@ package quantizer
 import (
 	"bytes"
 	"errors"
+	"strings"
 
 	"github.com/DataDog/datadog-trace-agent/model"
 	log "github.com/cihub/seelog"
@@ -10,6 +11,9 @@ import (
 
 const (
 	sqlQueryTag      = "sql.query"
+	sqlTablesTag     = "sql.tables"
+	sqlOperationTag  = "sql.operation"
+	dbTypeTag        = "db.type"
 	sqlQuantizeError = "agent.parse.error"
 )
 
@@ -125,6 +129,115 @@ func (f *GroupingFilter) Reset() {
 	f.groupMulti = 0
 }
 
+// sqlOperations are the keywords starting the statements reported as
+// sql.operation
+var sqlOperations = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true, "MERGE": true,
+	"UPSERT": true, "CREATE": true, "DROP": true, "ALTER": true, "TRUNCATE": true, "CALL": true,
+	"EXEC": true, "EXECUTE": true, "BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SHOW": true,
+	"GRANT": true, "REVOKE": true, "USE": true}
+
+// tableKeywords are the keywords followed by a table name
+var tableKeywords = map[string]bool{
+	"FROM": true, "JOIN": true, "INTO": true, "UPDATE": true, "TABLE": true}
+
+// fromListEnd are the keywords ending the list of tables following a FROM
+var fromListEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "ORDER": true, "HAVING": true, "UNION": true, "ON": true, "USING": true,
+	"INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true, "CROSS": true, "NATURAL": true,
+	"JOIN": true, "WINDOW": true, "FOR": true, "RETURNING": true, "SET": true, "VALUES": true}
+
+// TableFilter implements the TokenFilter interface to find the tables a
+// query operates on and its operation. It never changes the tokens.
+type TableFilter struct {
+	depth      int    // nesting of parentheses
+	expect     bool   // true if the next identifier is a table, or part of it
+	inFromList bool   // true while reading the comma separated tables of a FROM
+	lastWord   string // the last identifier, upper cased
+	pending    string // the table being read, which may be qualified
+
+	operation string
+	tables    []string
+	seen      map[string]struct{}
+}
+
+// Filter records the identifiers following the table keywords as tables, and
+// the first operation keyword out of parentheses as the operation
+func (f *TableFilter) Filter(token, lastToken int, buffer []byte) (int, []byte) {
+	if f.pending != "" && token != '.' && !(token == ID && f.expect) {
+		f.flush()
+	}
+
+	switch token {
+	case '.':
+		// quoted identifiers of qualified tables are separate tokens
+		if f.pending != "" {
+			f.pending += "."
+			f.expect = true
+		}
+	case '(':
+		f.depth++
+		f.expect, f.inFromList = false, false
+	case ')':
+		f.depth--
+	case ',':
+		f.expect = f.inFromList
+	case ID:
+		word := string(bytes.ToUpper(buffer))
+		switch {
+		case f.expect:
+			f.pending += string(buffer)
+			f.expect = false
+		case tableKeywords[word] && f.lastWord != "KEY":
+			// except the UPDATE of ON DUPLICATE KEY UPDATE
+			f.expect = true
+			f.inFromList = word == "FROM"
+		case fromListEnd[word]:
+			f.inFromList = false
+		}
+		if f.operation == "" && f.depth == 0 && sqlOperations[word] {
+			f.operation = word
+		}
+		f.lastWord = word
+	case Limit:
+		f.inFromList = false
+	}
+	return token, buffer
+}
+
+// flush records the table being read
+func (f *TableFilter) flush() {
+	table := f.pending
+	f.pending = ""
+	if f.seen == nil {
+		f.seen = make(map[string]struct{})
+	}
+	if _, ok := f.seen[table]; ok {
+		return
+	}
+	f.seen[table] = struct{}{}
+	f.tables = append(f.tables, table)
+}
+
+// Results returns the tables and the operation of the queries processed
+// since the last call, and forgets them
+func (f *TableFilter) Results() (tables []string, operation string) {
+	tables, operation = f.tables, f.operation
+	f.tables, f.operation, f.seen = nil, "", nil
+	return tables, operation
+}
+
+// Reset in a TableFilter restores the parsing state, the results are kept
+// until they are read with Results
+func (f *TableFilter) Reset() {
+	if f.pending != "" {
+		f.flush()
+	}
+	f.depth = 0
+	f.expect, f.inFromList = false, false
+	f.lastWord = ""
+}
+
 // TokenConsumer is a Tokenizer consumer. It calls the Tokenizer Scan() function until tokens
 // are available or if a LEX_ERROR is raised. After retrieving a token, it is sent in the
 // TokenFilter chains so that the token is discarded or replaced.
@@ -138,8 +251,14 @@ type TokenConsumer struct {
 // function is generic and the behavior changes according to chosen TokenFilter implementations.
 // The process calls all filters inside the []TokenFilter.
 func (t *TokenConsumer) Process(in string) (string, error) {
+	return t.ProcessDialect(in, DialectGeneric)
+}
+
+// ProcessDialect is the same as Process, for strings of the given dialect
+func (t *TokenConsumer) ProcessDialect(in string, dialect Dialect) (string, error) {
 	out := &bytes.Buffer{}
 	t.tokenizer.InStream.Reset(in)
+	t.tokenizer.Dialect = dialect
 
 	token, buff := t.tokenizer.Scan()
 	for ; token != EOFChar; token, buff = t.tokenizer.Scan() {
@@ -160,7 +279,7 @@ func (t *TokenConsumer) Process(in string) (string, error) {
 		if buff != nil {
 			// ensure that whitespaces properly separate
 			// received tokens
-			if out.Len() != 0 && token != ',' {
+			if out.Len() != 0 && token != ',' && token != ColonCast && t.lastToken != ColonCast {
 				out.WriteRune(' ')
 			}
 
@@ -191,23 +310,30 @@ func NewTokenConsumer(filters []TokenFilter) *TokenConsumer {
 	}
 }
 
+// tableFilter finds the tables and operation of the queries processed by
+// tokenQuantizer
+var tableFilter = &TableFilter{}
+
 // token consumer that will quantize the query with
 // the given filters; this quantizer is used only
 // for SQL and CQL strings
 var tokenQuantizer = NewTokenConsumer(
 	[]TokenFilter{
+		tableFilter,
 		&DiscardFilter{},
 		&ReplaceFilter{},
 		&GroupingFilter{},
 	})
 
-// QuantizeSQL generates resource and sql.query meta for SQL spans
+// QuantizeSQL generates resource and sql.query, sql.tables and sql.operation
+// meta for SQL spans, reading queries in the dialect given by their db.type meta
 func QuantizeSQL(span model.Span) model.Span {
 	if span.Resource == "" {
 		return span
 	}
 
-	quantizedString, err := tokenQuantizer.Process(span.Resource)
+	quantizedString, err := tokenQuantizer.ProcessDialect(span.Resource, DialectFromDBType(span.Meta[dbTypeTag]))
+	tables, operation := tableFilter.Results()
 	if err != nil || quantizedString == "" {
 		// if we have an error, the partially parsed SQL is discarded so that we don't pollute
 		// users resources. Here we provide more details to debug the problem.
@@ -224,6 +350,7 @@ func QuantizeSQL(span model.Span) model.Span {
 	}
 
 	span.Resource = quantizedString
+	span = setSQLTables(span, tables, operation)
 
 	// set the sql.query tag if and only if it's not already set by users. If a users set
 	// this value, we send that value AS IS to the backend. If the value is not set, we
@@ -242,3 +369,21 @@ func QuantizeSQL(span model.Span) model.Span {
 	span.Meta[sqlQueryTag] = quantizedString
 	return span
 }
+
+// setSQLTables sets the sql.tables and sql.operation meta of span, unless
+// users set them
+func setSQLTables(span model.Span, tables []string, operation string) model.Span {
+	if len(tables) == 0 && operation == "" {
+		return span
+	}
+	if span.Meta == nil {
+		span.Meta = make(map[string]string)
+	}
+	if _, ok := span.Meta[sqlTablesTag]; !ok && len(tables) > 0 {
+		span.Meta[sqlTablesTag] = strings.Join(tables, ",")
+	}
+	if _, ok := span.Meta[sqlOperationTag]; !ok && operation != "" {
+		span.Meta[sqlOperationTag] = operation
+	}
+	return span
+}
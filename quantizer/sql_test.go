@@ -264,6 +264,52 @@ func TestSQLQuantizer(t *testing.T) {
 	}
 }
 
+func TestSQLTablesAndOperation(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		query     string
+		tables    string
+		operation string
+	}{
+		{"SELECT * FROM users WHERE id = 42", "users", "SELECT"},
+		{"select u.name from users u, orders as o, `shop`.items i where u.id = o.user_id", "users,orders,shop.items", "SELECT"},
+		{"SELECT clients.* FROM clients INNER JOIN posts ON posts.author_id = author.id LEFT JOIN clients c2 ON c2.id = 1", "clients,posts", "SELECT"},
+		{"INSERT INTO user (id, email, name) VALUES (null, ?, ?)", "user", "INSERT"},
+		{"INSERT INTO counts (k, n) VALUES (1, 1) ON DUPLICATE KEY UPDATE n = n + 1", "counts", "INSERT"},
+		{"UPDATE users SET name = 'bob' WHERE id IN (SELECT user_id FROM orders)", "users,orders", "UPDATE"},
+		{"delete from sessions where expires < 42", "sessions", "DELETE"},
+		{"WITH recent AS (SELECT * FROM orders LIMIT 10) DELETE FROM carts WHERE id IN (SELECT cart_id FROM recent)", "orders,carts,recent", "DELETE"},
+		{"SELECT * FROM a WHERE x = 1 UNION SELECT * FROM b WHERE y = 2", "a,b", "SELECT"},
+		{"TRUNCATE TABLE logs", "logs", "TRUNCATE"},
+		{"SELECT 1", "", "SELECT"},
+		{"SAVEPOINT sp1", "", ""},
+	}
+
+	for _, c := range cases {
+		span := Quantize(SQLSpan(c.query))
+		tables, ok := span.Meta["sql.tables"]
+		assert.Equal(c.tables != "", ok, c.query)
+		assert.Equal(c.tables, tables, c.query)
+		assert.Equal(c.operation, span.Meta["sql.operation"], c.query)
+	}
+
+	// users tags are kept as is
+	span := SQLSpan("SELECT * FROM users")
+	span.Meta["sql.tables"] = "custom"
+	span = Quantize(span)
+	assert.Equal("custom", span.Meta["sql.tables"])
+	assert.Equal("SELECT", span.Meta["sql.operation"])
+
+	// nothing is set for queries which cannot be parsed, nor leaks to the
+	// next one
+	span = Quantize(SQLSpan("SELECT * FROM users WHERE id = '1"))
+	assert.NotContains(span.Meta, "sql.tables")
+	span = Quantize(SQLSpan("ROLLBACK"))
+	assert.NotContains(span.Meta, "sql.tables")
+	assert.Equal("ROLLBACK", span.Meta["sql.operation"])
+}
+
 func TestMultipleProcess(t *testing.T) {
 	assert := assert.New(t)
 
@@ -38,13 +38,48 @@ const (
 	Filtered          = 57364
 	As                = 57365
 	FilteredComma     = 57366
+	ColonCast         = 57367
 )
 
+// Dialect is the SQL dialect of the strings a Tokenizer scans, which tells
+// how strings, comments and identifiers are delimited
+type Dialect int
+
+// list of supported dialects
+const (
+	// DialectGeneric accepts the features common to most dialects
+	DialectGeneric Dialect = iota
+	// DialectPostgres adds dollar-quoted strings, E'' escape strings and
+	// :: casts, backslashes are not escapes in standard strings
+	DialectPostgres
+	// DialectMySQL adds # comments and double-quoted strings
+	DialectMySQL
+	// DialectMSSQL adds [bracketed] identifiers and N'' strings,
+	// backslashes are not escapes in strings
+	DialectMSSQL
+)
+
+// DialectFromDBType returns the dialect of the databases of the given type,
+// as set in the db.type meta of spans
+func DialectFromDBType(dbType string) Dialect {
+	switch strings.ToLower(dbType) {
+	case "postgres", "postgresql":
+		return DialectPostgres
+	case "mysql", "mariadb":
+		return DialectMySQL
+	case "mssql", "sqlserver":
+		return DialectMSSQL
+	default:
+		return DialectGeneric
+	}
+}
+
 // Tokenizer is the struct used to generate SQL
 // tokens for the parser.
 type Tokenizer struct {
 	InStream *strings.Reader
 	Position int
+	Dialect  Dialect
 	lastChar uint16
 }
 
@@ -58,6 +93,7 @@ func NewStringTokenizer(sql string) *Tokenizer {
 func (tkn *Tokenizer) Reset() {
 	tkn.InStream.Reset("")
 	tkn.Position = 0
+	tkn.Dialect = DialectGeneric
 	tkn.lastChar = 0
 }
 
@@ -83,10 +119,23 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 	tkn.skipBlank()
 
 	switch ch := tkn.lastChar; {
+	case ch == '#' && tkn.Dialect == DialectMySQL:
+		tkn.next()
+		return tkn.scanCommentType1("#")
+	case tkn.isStringPrefix(ch):
+		// E'' strings of Postgres have escapes, N'' strings of MSSQL do not
+		escapes := tkn.Dialect == DialectPostgres
+		tkn.next()
+		tkn.next()
+		return tkn.scanString('\'', String, escapes)
 	case isLetter(ch):
 		return tkn.scanIdentifier()
 	case isDigit(ch):
 		return tkn.scanNumber(false)
+	case ch == ':' && tkn.Dialect == DialectPostgres && tkn.peek() == ':':
+		tkn.next()
+		tkn.next()
+		return ColonCast, []byte("::")
 	case ch == ':':
 		return tkn.scanBindVar()
 	default:
@@ -94,7 +143,12 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 		switch ch {
 		case EOFChar:
 			return EOFChar, nil
-		case '=', ',', ';', '(', ')', '+', '*', '&', '|', '^', '~', '[', ']', '?':
+		case '=', ',', ';', '(', ')', '+', '*', '&', '|', '^', '~', ']', '?':
+			return int(ch), []byte{byte(ch)}
+		case '[':
+			if tkn.Dialect == DialectMSSQL {
+				return tkn.scanBracketIdentifier()
+			}
 			return int(ch), []byte{byte(ch)}
 		case '.':
 			if isDigit(tkn.lastChar) {
@@ -148,10 +202,13 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 			}
 			return LexError, []byte("!")
 		case '\'':
-			return tkn.scanString(ch, String)
+			return tkn.scanString(ch, String, tkn.backslashEscapes())
 		case '`':
 			return tkn.scanLiteralIdentifier('`')
 		case '"':
+			if tkn.Dialect == DialectMySQL {
+				return tkn.scanString(ch, String, true)
+			}
 			return tkn.scanLiteralIdentifier('"')
 		case '%':
 			if tkn.lastChar == '(' {
@@ -159,6 +216,9 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 			}
 			return tkn.scanFormatParameter('%')
 		case '$':
+			if tkn.Dialect == DialectPostgres && (tkn.lastChar == '$' || (isTagChar(tkn.lastChar) && !isDigit(tkn.lastChar))) {
+				return tkn.scanDollarQuotedString()
+			}
 			return tkn.scanPreparedStatement('$')
 		case '{':
 			return tkn.scanEscapeSequence('{')
@@ -168,6 +228,25 @@ func (tkn *Tokenizer) Scan() (int, []byte) {
 	}
 }
 
+// isStringPrefix tells if ch is the prefix of a string of the dialect, like
+// E'\n' for Postgres or N'text' for MSSQL
+func (tkn *Tokenizer) isStringPrefix(ch uint16) bool {
+	switch tkn.Dialect {
+	case DialectPostgres:
+		return (ch == 'E' || ch == 'e') && tkn.peek() == '\''
+	case DialectMSSQL:
+		return (ch == 'N' || ch == 'n') && tkn.peek() == '\''
+	default:
+		return false
+	}
+}
+
+// backslashEscapes tells if backslashes escape characters in the standard
+// strings of the dialect
+func (tkn *Tokenizer) backslashEscapes() bool {
+	return tkn.Dialect != DialectPostgres && tkn.Dialect != DialectMSSQL
+}
+
 func (tkn *Tokenizer) skipBlank() {
 	ch := tkn.lastChar
 	for ch == ' ' || ch == '\n' || ch == '\r' || ch == '\t' {
@@ -369,7 +448,7 @@ exit:
 	return Number, buffer.Bytes()
 }
 
-func (tkn *Tokenizer) scanString(delim uint16, typ int) (int, []byte) {
+func (tkn *Tokenizer) scanString(delim uint16, typ int, escapes bool) (int, []byte) {
 	buffer := &bytes.Buffer{}
 	for {
 		ch := tkn.lastChar
@@ -380,7 +459,7 @@ func (tkn *Tokenizer) scanString(delim uint16, typ int) (int, []byte) {
 			} else {
 				break
 			}
-		} else if ch == '\\' {
+		} else if ch == '\\' && escapes {
 			if tkn.lastChar == EOFChar {
 				return LexError, buffer.Bytes()
 			}
@@ -396,6 +475,54 @@ func (tkn *Tokenizer) scanString(delim uint16, typ int) (int, []byte) {
 	return typ, buffer.Bytes()
 }
 
+// scanDollarQuotedString scans the Postgres strings delimited by two dollars
+// enclosing an optional tag, like $$text$$ or $fn$text$fn$, the first dollar
+// being consumed
+func (tkn *Tokenizer) scanDollarQuotedString() (int, []byte) {
+	delim := &bytes.Buffer{}
+	delim.WriteByte('$')
+	for tkn.lastChar != '$' {
+		if !isTagChar(tkn.lastChar) {
+			return LexError, delim.Bytes()
+		}
+		tkn.consumeNext(delim)
+	}
+	tkn.consumeNext(delim)
+
+	buffer := &bytes.Buffer{}
+	for !bytes.HasSuffix(buffer.Bytes(), delim.Bytes()) {
+		if tkn.lastChar == EOFChar {
+			return LexError, buffer.Bytes()
+		}
+		tkn.consumeNext(buffer)
+	}
+	return String, buffer.Bytes()[:buffer.Len()-delim.Len()]
+}
+
+// scanBracketIdentifier scans the MSSQL identifiers enclosed in brackets, in
+// which ]] stands for ], the opening bracket being consumed
+func (tkn *Tokenizer) scanBracketIdentifier() (int, []byte) {
+	buffer := &bytes.Buffer{}
+	for {
+		ch := tkn.lastChar
+		if ch == EOFChar {
+			return LexError, buffer.Bytes()
+		}
+		tkn.next()
+		if ch == ']' {
+			if tkn.lastChar != ']' {
+				break
+			}
+			tkn.next()
+		}
+		buffer.WriteByte(byte(ch))
+	}
+	if buffer.Len() == 0 {
+		return LexError, buffer.Bytes()
+	}
+	return ID, buffer.Bytes()
+}
+
 func (tkn *Tokenizer) scanCommentType1(prefix string) (int, []byte) {
 	buffer := &bytes.Buffer{}
 	buffer.WriteString(prefix)
@@ -448,6 +575,16 @@ func (tkn *Tokenizer) next() {
 	tkn.Position++
 }
 
+// peek returns the character following lastChar without consuming it
+func (tkn *Tokenizer) peek() uint16 {
+	ch, err := tkn.InStream.ReadByte()
+	if err != nil {
+		return EOFChar
+	}
+	tkn.InStream.UnreadByte()
+	return uint16(ch)
+}
+
 func skipNonLiteralIdentifier(ch uint16) bool {
 	return isLetter(ch) || isDigit(ch) || '.' == ch || '-' == ch
 }
@@ -456,6 +593,11 @@ func isLetter(ch uint16) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch == '@' || ch == '#'
 }
 
+// isTagChar tells if ch can be part of the tag of a dollar-quoted string
+func isTagChar(ch uint16) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || isDigit(ch)
+}
+
 func digitVal(ch uint16) int {
 	switch {
 	case '0' <= ch && ch <= '9':
@@ -0,0 +1,147 @@
+package quantizer
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialectFromDBType(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(DialectPostgres, DialectFromDBType("postgresql"))
+	assert.Equal(DialectPostgres, DialectFromDBType("Postgres"))
+	assert.Equal(DialectMySQL, DialectFromDBType("mysql"))
+	assert.Equal(DialectMSSQL, DialectFromDBType("sqlserver"))
+	assert.Equal(DialectGeneric, DialectFromDBType("sqlite"))
+	assert.Equal(DialectGeneric, DialectFromDBType(""))
+}
+
+func TestTokenizerDialects(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		// Postgres
+		{DialectPostgres,
+			"SELECT $$it's a $dollar$ string$$, $fn$body$$ with $fn$ FROM t",
+			"SELECT ? FROM t"},
+		{DialectPostgres,
+			`SELECT * FROM files WHERE path = E'C:\\temp\'s' AND name = 'C:\'`,
+			"SELECT * FROM files WHERE path = ? AND name = ?"},
+		{DialectPostgres,
+			"SELECT created_at::date, '42'::int FROM events WHERE id = $1",
+			"SELECT created_at::date, ?::int FROM events WHERE id = ?"},
+		{DialectPostgres,
+			"SELECT $tag$unterminated",
+			""},
+
+		// MySQL
+		{DialectMySQL,
+			"SELECT * FROM users # the users of 42\nWHERE name = \"bob\" AND bio = 'it\\'s me'",
+			"SELECT * FROM users WHERE name = ? AND bio = ?"},
+
+		// MSSQL
+		{DialectMSSQL,
+			"SELECT [order id], [total] FROM [dbo].[order details] WHERE [note]]s] = N'x' AND path = 'C:\\'",
+			"SELECT order id, total FROM dbo . order details WHERE note]s = ? AND path = ?"},
+		{DialectMSSQL,
+			"SELECT * FROM #temp WHERE [id = 1",
+			""},
+	}
+
+	for _, c := range cases {
+		consumer := NewTokenConsumer([]TokenFilter{&DiscardFilter{}, &ReplaceFilter{}, &GroupingFilter{}})
+		output, err := consumer.ProcessDialect(c.query, c.dialect)
+		assert.Equal(c.expected, output, c.query)
+		assert.Equal(c.expected == "", err != nil, c.query)
+	}
+}
+
+func TestQuantizeSQLDialect(t *testing.T) {
+	assert := assert.New(t)
+
+	span := SQLSpan("SELECT * FROM users WHERE name = 'C:\\' AND id = $1")
+	span.Meta["db.type"] = "postgresql"
+	span = Quantize(span)
+	assert.Equal("SELECT * FROM users WHERE name = ? AND id = ?", span.Resource)
+	assert.NotContains(span.Meta, "agent.parse.error")
+
+	// backslashes escape quotes without a dialect
+	span = Quantize(SQLSpan("SELECT * FROM users WHERE name = 'C:\\' AND id = $1"))
+	assert.Equal("Non-parsable SQL query", span.Resource)
+}
+
+// tokenizerCorpus holds queries exercising the features of all dialects,
+// which are mutated to make sure that the tokenizer never panics nor loops
+var tokenizerCorpus = []string{
+	"SELECT * FROM users WHERE id = 42",
+	"SELECT $$text$$, $a$x$a$, E'\\n', 'a''b', x::int FROM t WHERE y = $1",
+	"SELECT * FROM t # comment\n WHERE a = \"b\" AND c = 'd\\'e'",
+	"SELECT [a]]b], N'x' FROM [dbo].[t] WHERE #tmp.id = @id",
+	"SELECT * FROM t /* comment */ -- other\n WHERE a IN (%s, %(name)s, :name, ::list, ?) LIMIT 10",
+	"INSERT INTO t (a) VALUES ({ts '2017-01-01'}, 0x1F, 1.5e10, .5, 08)",
+	"SELECT `a`.`b` FROM `c` WHERE d <=> e AND f != g AND h <> i AND j >= k",
+}
+
+func TestTokenizerNeverPanics(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	alphabet := []byte("$'\"`[]#:\\/*-E N(){}%@.0x9 \n\t;=<>!?")
+	dialects := []Dialect{DialectGeneric, DialectPostgres, DialectMySQL, DialectMSSQL}
+
+	queries := append([]string{}, tokenizerCorpus...)
+	for _, q := range tokenizerCorpus {
+		// every prefix, as queries are often truncated
+		for i := 0; i < len(q); i++ {
+			queries = append(queries, q[:i])
+		}
+		// random mutations
+		for i := 0; i < 200; i++ {
+			b := []byte(q)
+			for j := 0; j < 1+r.Intn(4); j++ {
+				pos := r.Intn(len(b))
+				switch r.Intn(3) {
+				case 0:
+					b[pos] = alphabet[r.Intn(len(alphabet))]
+				case 1:
+					b = append(b[:pos], b[pos+1:]...)
+				default:
+					b = append(b[:pos], append([]byte{alphabet[r.Intn(len(alphabet))]}, b[pos:]...)...)
+				}
+			}
+			queries = append(queries, string(b))
+		}
+	}
+	// random bytes
+	for i := 0; i < 500; i++ {
+		b := make([]byte, r.Intn(64))
+		r.Read(b)
+		queries = append(queries, string(b))
+	}
+
+	for _, dialect := range dialects {
+		for _, q := range queries {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						t.Fatalf("tokenizer panicked on %q (dialect %d): %v", q, dialect, err)
+					}
+				}()
+				tkn := NewStringTokenizer(q)
+				tkn.Dialect = dialect
+				// each token consumes at least a character, or ends the scan
+				for n := 0; n <= len(q)+1; n++ {
+					token, _ := tkn.Scan()
+					if token == EOFChar || token == LexError {
+						return
+					}
+				}
+				t.Fatalf("tokenizer did not terminate on %q (dialect %d)", q, dialect)
+			}()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sampler
+
+import (
+	"sync"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/quantile"
+)
+
+const (
+	// latencyQuantile is the quantile of the root durations of a signature
+	// above which traces are considered slow
+	latencyQuantile = 0.99
+	// latencyWindow is the number of durations after which the quantile of a
+	// signature is computed, and a new window started
+	latencyWindow = 1000
+	// minLatencySamples is the number of durations required before the first
+	// window is complete to tell slow traces apart
+	minLatencySamples = 100
+)
+
+// signatureLatency tracks the root durations of a signature
+type signatureLatency struct {
+	// summary holds the durations of the current window
+	summary *quantile.SliceSummary
+	// threshold is the latencyQuantile of the previous window, 0 until the
+	// first window is complete
+	threshold float64
+}
+
+// latencyTracker computes a running quantile of the root durations of each
+// signature, in windows of latencyWindow traces so that it follows changes
+// of latency and its memory stays bounded.
+type latencyTracker struct {
+	mu         sync.Mutex
+	signatures map[Signature]*signatureLatency
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{signatures: make(map[Signature]*signatureLatency)}
+}
+
+// count adds the root duration of a trace to the summary of its signature
+func (l *latencyTracker) count(signature Signature, root *model.Span) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sl, ok := l.signatures[signature]
+	if !ok {
+		sl = &signatureLatency{summary: quantile.NewSliceSummary()}
+		l.signatures[signature] = sl
+	}
+	sl.summary.Insert(float64(root.Duration), root.SpanID)
+	if sl.summary.N >= latencyWindow {
+		sl.threshold = sl.summary.Quantile(latencyQuantile)
+		sl.summary = quantile.NewSliceSummary()
+	}
+}
+
+// isSlow tells if the root of a trace lasted longer than the latencyQuantile
+// of its signature, which needs enough samples to be known
+func (l *latencyTracker) isSlow(signature Signature, root *model.Span) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sl, ok := l.signatures[signature]
+	if !ok {
+		return false
+	}
+	threshold := sl.threshold
+	if threshold == 0 {
+		if sl.summary.N < minLatencySamples {
+			return false
+		}
+		threshold = sl.summary.Quantile(latencyQuantile)
+	}
+	return float64(root.Duration) > threshold
+}
+
+// prune forgets the signatures the backend does not score anymore
+func (l *latencyTracker) prune(b *Backend) {
+	scores := b.GetAllSignatureScores()
+
+	l.mu.Lock()
+	for signature := range l.signatures {
+		if _, ok := scores[signature]; !ok {
+			delete(l.signatures, signature)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// hasError tells if any span of the trace is an error
+func hasError(trace model.Trace) bool {
+	for i := range trace {
+		if trace[i].Error != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBoost returns the factor by which the sample rate of a trace is
+// multiplied because it contains errors or because its root is slower than
+// the latencyQuantile of its signature. Both boosts combine.
+func (s *Sampler) GetBoost(trace model.Trace, root *model.Span, signature Signature) float64 {
+	boost := 1.0
+	if s.errorBoost > 1 && hasError(trace) {
+		boost *= s.errorBoost
+	}
+	if s.latencyBoost > 1 && s.latencies.isSlow(signature, root) {
+		boost *= s.latencyBoost
+	}
+	return boost
+}
+
+// CountLatency counts the root duration of a trace, if the latency boost is
+// enabled
+func (s *Sampler) CountLatency(signature Signature, root *model.Span) {
+	if s.latencyBoost > 1 {
+		s.latencies.count(signature, root)
+	}
+}
@@ -0,0 +1,143 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/DataDog/datadog-trace-agent/fixtures"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestBoostTrace returns a trace made of fixtures spans, the root lasting
+// duration and the child span being an error if isError
+func getTestBoostTrace(duration int64, isError bool) (model.Trace, *model.Span) {
+	root := fixtures.TestSpan()
+	root.TraceID = randomTraceID()
+	root.SpanID = fixtures.RandomSpanID()
+	root.ParentID = 0
+	root.Duration = duration
+	root.Metrics = nil
+
+	child := fixtures.TestSpan()
+	child.TraceID = root.TraceID
+	child.SpanID = fixtures.RandomSpanID()
+	child.ParentID = root.SpanID
+	child.Duration = duration / 2
+	if isError {
+		child.Error = 1
+	}
+
+	trace := model.Trace{root, child}
+	return trace, &trace[0]
+}
+
+func TestErrorBoost(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.Sampler.UpdateBoosts(4, 1)
+
+	trace, root := getTestBoostTrace(fixtures.RandomSpanDuration(), false)
+	errorTrace, errorRoot := getTestBoostTrace(root.Duration, true)
+	signature := computeSignatureWithRootAndEnv(trace, root, defaultEnv)
+
+	// Feed the sampler so that the signature has a < 1 sample rate
+	for i := 0; i < int(1e5); i++ {
+		s.Sample(trace, root, defaultEnv)
+	}
+
+	rate := s.Sampler.GetSampleRate(trace, root, signature)
+	assert.True(rate < 0.25)
+	assert.InDelta(4*rate, s.Sampler.GetSampleRate(errorTrace, errorRoot, signature), 1e-9)
+
+	// the boosted rate never goes above 1
+	s.Sampler.UpdateBoosts(1e9, 1)
+	assert.Equal(1.0, s.Sampler.GetSampleRate(errorTrace, errorRoot, signature))
+}
+
+func TestLatencyBoost(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.Sampler.UpdateBoosts(1, 3)
+
+	trace, root := getTestBoostTrace(0, false)
+	signature := computeSignatureWithRootAndEnv(trace, root, defaultEnv)
+
+	// not enough samples to know the p99 yet
+	root.Duration = 1e9
+	assert.Equal(s.Sampler.GetSignatureSampleRate(signature), s.Sampler.GetSampleRate(trace, root, signature))
+
+	// durations spread uniformly from 1ms to 100ms
+	for i := 0; i < 3*latencyWindow; i++ {
+		root.Duration = int64(1+i%100) * 1e6
+		s.Sample(trace, root, defaultEnv)
+	}
+
+	fast, fastRoot := getTestBoostTrace(50*1e6, false)
+	slow, slowRoot := getTestBoostTrace(150*1e6, false)
+	rate := s.Sampler.GetSampleRate(fast, fastRoot, signature)
+	assert.True(rate < 1.0/3)
+	assert.InDelta(3*rate, s.Sampler.GetSampleRate(slow, slowRoot, signature), 1e-9)
+}
+
+func TestLatencyTrackerWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	l := newLatencyTracker()
+	root := fixtures.TestSpan()
+	signature := Signature(42)
+
+	for i := 0; i < latencyWindow; i++ {
+		root.Duration = int64(i)
+		l.count(signature, &root)
+	}
+	sl := l.signatures[signature]
+	assert.InDelta(latencyQuantile*latencyWindow, sl.threshold, 0.02*latencyWindow)
+	assert.Equal(0, sl.summary.N)
+
+	// the threshold of the previous window holds while the next one fills
+	root.Duration = 1
+	l.count(signature, &root)
+	root.Duration = latencyWindow
+	assert.True(l.isSlow(signature, &root))
+	root.Duration = latencyWindow / 2
+	assert.False(l.isSlow(signature, &root))
+
+	// signatures the backend forgot are pruned
+	l.prune(NewBackend(defaultDecayPeriod))
+	assert.Empty(l.signatures)
+}
+
+func TestBoostMaxTPS(t *testing.T) {
+	// Boosted traces are still bounded by the maxTPS limit
+	assert := assert.New(t)
+	s := getTestScoreEngine()
+	s.Sampler.UpdateBoosts(100, 100)
+
+	maxTPS := 5.0
+	tps := 100.0
+	initPeriods := 20
+	periods := 50
+
+	s.Sampler.maxTPS = maxTPS
+	periodSeconds := s.Sampler.Backend.decayPeriod.Seconds()
+	tracesPerPeriod := tps * periodSeconds
+	s.Sampler.signatureScoreOffset = 0.1
+	s.Sampler.signatureScoreFactor = math.Pow(s.Sampler.signatureScoreSlope, math.Log10(s.Sampler.signatureScoreOffset))
+
+	sampledCount := 0
+	for period := 0; period < initPeriods+periods; period++ {
+		s.Sampler.Backend.DecayScore()
+		for i := 0; i < int(tracesPerPeriod); i++ {
+			trace, root := getTestBoostTrace(fixtures.RandomSpanDuration(), i%2 == 0)
+			sampled := s.Sample(trace, root, defaultEnv)
+			if period > initPeriods && sampled {
+				sampledCount++
+			}
+		}
+	}
+
+	assert.True(maxTPS*s.Sampler.Backend.decayFactor >= float64(sampledCount)/(float64(periods)*periodSeconds))
+}
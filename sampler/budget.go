@@ -0,0 +1,170 @@
+package sampler
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// tpsBudget is the budget applying to a trace
+type tpsBudget struct {
+	key    string // "env:<env>" or "service:<service>"
+	maxTPS float64
+}
+
+// BudgetStats is the utilization of a TPS budget
+type BudgetStats struct {
+	Key    string
+	MaxTPS float64
+	// TPS is the number of traces per second recently kept by the sampler
+	// for the budget, before being limited by it
+	TPS float64
+	// Utilization is TPS over MaxTPS
+	Utilization float64
+}
+
+// tpsBudgets limit the number of traces kept per second by env and by
+// service. Traces are limited by the budgets of both their env and their
+// service, each env without a budget getting the default one.
+type tpsBudgets struct {
+	conf config.TPSBudgets
+
+	// backend counts the traces kept for each budget, the signatures being
+	// the hashes of the budget keys
+	backend *Backend
+
+	mu   sync.Mutex
+	seen map[string]float64 // the budgets which applied to traces, by key
+}
+
+// newTPSBudgets returns the configured budgets, or nil if there are none
+func newTPSBudgets(conf config.TPSBudgets) *tpsBudgets {
+	if conf.Default <= 0 && len(conf.Envs) == 0 && len(conf.Services) == 0 {
+		return nil
+	}
+	return &tpsBudgets{
+		conf:    conf,
+		backend: NewBackend(defaultDecayPeriod),
+		seen:    make(map[string]float64),
+	}
+}
+
+func budgetSignature(key string) Signature {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return Signature(h.Sum64())
+}
+
+// get returns the budgets applying to the traces of service in env
+func (b *tpsBudgets) get(service, env string) []tpsBudget {
+	var budgets []tpsBudget
+	if tps, ok := b.conf.Envs[env]; ok {
+		if tps > 0 {
+			budgets = append(budgets, tpsBudget{key: "env:" + env, maxTPS: tps})
+		}
+	} else if b.conf.Default > 0 {
+		budgets = append(budgets, tpsBudget{key: "env:" + env, maxTPS: b.conf.Default})
+	}
+	if tps := b.conf.Services[service]; tps > 0 {
+		budgets = append(budgets, tpsBudget{key: "service:" + service, maxTPS: tps})
+	}
+	return budgets
+}
+
+// count counts a trace of service in env kept by the sampler
+func (b *tpsBudgets) count(service, env string) {
+	budgets := b.get(service, env)
+	for _, budget := range budgets {
+		b.backend.CountSignature(budgetSignature(budget.key))
+	}
+
+	b.mu.Lock()
+	for _, budget := range budgets {
+		b.seen[budget.key] = budget.maxTPS
+	}
+	b.mu.Unlock()
+}
+
+// getSampleRate returns an extra sample rate to apply to the traces of
+// service in env if their budgets are exceeded.
+func (b *tpsBudgets) getSampleRate(service, env string) float64 {
+	rate := 1.0
+	for _, budget := range b.get(service, env) {
+		// as for maxTPS, overestimate the current TPS with the backend bias
+		currentTPS := b.backend.GetSignatureScore(budgetSignature(budget.key)) * b.backend.decayFactor
+		if currentTPS > budget.maxTPS && budget.maxTPS/currentTPS < rate {
+			rate = budget.maxTPS / currentTPS
+		}
+	}
+	return rate
+}
+
+// limitRates lowers the rates by service so that the traces clients keep
+// with them fit in the budgets. tps are the numbers of traces received per
+// second for each service key of rates.
+func (b *tpsBudgets) limitRates(rates map[string]float64, tps map[string]float64) {
+	// the number of traces expected to be kept for each budget
+	kept := make(map[string]float64)
+	budgets := make(map[string][]tpsBudget, len(rates))
+	for key, rate := range rates {
+		service, env, ok := splitServiceKey(key)
+		if !ok || key == defaultServiceRateKey {
+			continue
+		}
+		budgets[key] = b.get(service, env)
+		for _, budget := range budgets[key] {
+			kept[budget.key] += tps[key] * rate
+		}
+	}
+
+	for key := range budgets {
+		factor := 1.0
+		for _, budget := range budgets[key] {
+			if kept[budget.key] > budget.maxTPS && budget.maxTPS/kept[budget.key] < factor {
+				factor = budget.maxTPS / kept[budget.key]
+			}
+		}
+		rates[key] *= factor
+	}
+}
+
+// splitServiceKey returns the service and env of a key built by byServiceKey
+func splitServiceKey(key string) (service, env string, ok bool) {
+	i := strings.LastIndex(key, ",env:")
+	if !strings.HasPrefix(key, "service:") || i < 0 {
+		return "", "", false
+	}
+	return key[len("service:"):i], key[i+len(",env:"):], true
+}
+
+// stats returns the utilization of the budgets which applied to traces,
+// sorted by key
+func (b *tpsBudgets) stats() []BudgetStats {
+	b.mu.Lock()
+	stats := make([]BudgetStats, 0, len(b.seen))
+	for key, maxTPS := range b.seen {
+		tps := b.backend.GetSignatureScore(budgetSignature(key))
+		stats = append(stats, BudgetStats{Key: key, MaxTPS: maxTPS, TPS: tps, Utilization: tps / maxTPS})
+	}
+	b.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+// run decays the counters of the budgets until they are stopped
+func (b *tpsBudgets) run() {
+	go func() {
+		defer watchdog.LogOnPanic()
+		b.backend.Run()
+	}()
+}
+
+// stop stops the decay of the counters of the budgets
+func (b *tpsBudgets) stop() {
+	b.backend.Stop()
+}
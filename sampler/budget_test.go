@@ -0,0 +1,184 @@
+package sampler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTPSBudgetsGet(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newTPSBudgets(config.TPSBudgets{}))
+
+	b := newTPSBudgets(config.TPSBudgets{
+		Default:  10,
+		Envs:     map[string]float64{"prod": 50, "dev": 0},
+		Services: map[string]float64{"web": 5},
+	})
+	assert.Equal([]tpsBudget{{key: "env:prod", maxTPS: 50}}, b.get("db", "prod"))
+	assert.Equal([]tpsBudget{{key: "env:staging", maxTPS: 10}}, b.get("db", "staging"))
+	assert.Equal([]tpsBudget{{key: "service:web", maxTPS: 5}}, b.get("web", "dev"))
+	assert.Equal([]tpsBudget{{key: "env:prod", maxTPS: 50}, {key: "service:web", maxTPS: 5}}, b.get("web", "prod"))
+
+	// without a default, envs have no budget
+	b = newTPSBudgets(config.TPSBudgets{Services: map[string]float64{"web": 5}})
+	assert.Len(b.get("db", "prod"), 0)
+}
+
+func TestTPSBudgetsSampleRate(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newTPSBudgets(config.TPSBudgets{Envs: map[string]float64{"prod": 10}, Services: map[string]float64{"web": 1}})
+	// without decay, the scores are the counts over countScaleFactor
+	for i := 0; i < int(20*b.backend.countScaleFactor); i++ {
+		b.count("db", "prod")
+	}
+	assert.Equal(1.0, b.getSampleRate("db", "staging"))
+	assert.InDelta(10/(20*b.backend.decayFactor), b.getSampleRate("db", "prod"), 1e-9)
+
+	// the lowest rate applies
+	for i := 0; i < int(5*b.backend.countScaleFactor); i++ {
+		b.count("web", "prod")
+	}
+	assert.InDelta(1/(5*b.backend.decayFactor), b.getSampleRate("web", "prod"), 1e-9)
+
+	stats := b.stats()
+	assert.Len(stats, 2)
+	assert.Equal("env:prod", stats[0].Key)
+	assert.InDelta(25, stats[0].TPS, 1e-9)
+	assert.InDelta(2.5, stats[0].Utilization, 1e-9)
+	assert.Equal("service:web", stats[1].Key)
+	assert.InDelta(5, stats[1].Utilization, 1e-9)
+}
+
+func TestTPSBudgetsLimitRates(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newTPSBudgets(config.TPSBudgets{Envs: map[string]float64{"prod": 10}, Services: map[string]float64{"web": 2}})
+	rates := map[string]float64{
+		byServiceKey("web", "prod"): 1,
+		byServiceKey("db", "prod"):  0.5,
+		byServiceKey("db", "dev"):   1,
+		defaultServiceRateKey:       1,
+	}
+	tps := map[string]float64{
+		byServiceKey("web", "prod"): 4,
+		byServiceKey("db", "prod"):  32,
+		byServiceKey("db", "dev"):   100,
+	}
+	b.limitRates(rates, tps)
+
+	// 4+16 traces per second expected in prod, for a budget of 10
+	assert.InDelta(0.5*0.5, rates[byServiceKey("db", "prod")], 1e-9)
+	// 4 traces per second expected for web, for a budget of 2
+	assert.InDelta(0.5, rates[byServiceKey("web", "prod")], 1e-9)
+	// no budget
+	assert.Equal(1.0, rates[byServiceKey("db", "dev")])
+	assert.Equal(1.0, rates[defaultServiceRateKey])
+}
+
+func TestSplitServiceKey(t *testing.T) {
+	assert := assert.New(t)
+
+	service, env, ok := splitServiceKey(byServiceKey("web", "prod"))
+	assert.True(ok)
+	assert.Equal("web", service)
+	assert.Equal("prod", env)
+
+	_, _, ok = splitServiceKey("web")
+	assert.False(ok)
+}
+
+func TestScoreEngineTPSBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.EnableTPSBudgets(config.TPSBudgets{Envs: map[string]float64{"prod": 10}})
+
+	tps := 100.0
+	periodSeconds := s.Sampler.Backend.decayPeriod.Seconds()
+	tracesPerPeriod := tps * periodSeconds
+	// make the signature sampler keep every trace
+	s.Sampler.SetSignatureCoefficients(2*tps, defaultSignatureScoreSlope)
+
+	var kept, other int
+	for period := 0; period < 60; period++ {
+		s.budgets.backend.DecayScore()
+		s.Sampler.Backend.DecayScore()
+		for i := 0; i < int(tracesPerPeriod); i++ {
+			trace, root := getTestTrace()
+			if s.Sample(trace, root, "prod") && period >= 30 {
+				kept++
+			}
+			trace, root = getTestTrace()
+			if s.Sample(trace, root, "dev") && period >= 30 {
+				other++
+			}
+		}
+	}
+
+	assert.InEpsilon(10*30*periodSeconds, kept, 0.2)
+	assert.Equal(int(30*tracesPerPeriod), other)
+
+	state := s.GetState().(InternalState)
+	assert.Len(state.Budgets, 1)
+	assert.Equal("env:prod", state.Budgets[0].Key)
+}
+
+func TestScoreEngineTPSBudgetRareSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.EnableTPSBudgets(config.TPSBudgets{Envs: map[string]float64{"staging": 5}})
+	s.EnableRareSampler(time.Hour, 1e6)
+	// drop everything, but the rare traces
+	s.Sampler.extraRate = 0
+
+	tps := 100.0
+	periodSeconds := s.Sampler.Backend.decayPeriod.Seconds()
+	tracesPerPeriod := tps * periodSeconds
+
+	// every trace has a new signature, all of them are rare
+	var kept, n int
+	for period := 0; period < 60; period++ {
+		s.budgets.backend.DecayScore()
+		s.Sampler.Backend.DecayScore()
+		for i := 0; i < int(tracesPerPeriod); i++ {
+			trace, root := getTestTrace()
+			trace[0].Resource = fmt.Sprintf("resource-%d", n)
+			n++
+			if s.Sample(trace, root, "staging") && period >= 30 {
+				kept++
+			}
+		}
+	}
+
+	// the rare sampler respects the budget, and is accounted for in it
+	assert.True(kept > 0)
+	assert.True(float64(kept)/(30*periodSeconds) <= 5*1.1, "kept %d traces", kept)
+	state := s.GetState().(InternalState)
+	assert.Len(state.Budgets, 1)
+	assert.InEpsilon(5, state.Budgets[0].TPS, 0.5)
+}
+
+func TestPriorityEngineTPSBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestPriorityEngine()
+	s.EnableTPSBudgets(config.TPSBudgets{Services: map[string]float64{testServiceA: 0.1}})
+
+	for i := 0; i < 1000; i++ {
+		trace, root := getTestTraceWithService(t, testServiceA, s)
+		s.Sample(trace, root, defaultEnv)
+		trace, root = getTestTraceWithService(t, testServiceB, s)
+		s.Sample(trace, root, defaultEnv)
+	}
+
+	rates := s.getRateByService()
+	assert.True(rates[byServiceKey(testServiceA, defaultEnv)] < rates[byServiceKey(testServiceB, defaultEnv)])
+	assert.Len(s.GetState().(InternalState).Budgets, 1)
+}
@@ -40,6 +40,12 @@ type Engine interface {
 	Sample(trace model.Trace, root *model.Span, env string) bool
 	// GetState returns information about the sampler.
 	GetState() interface{}
+	// Snapshot returns the state of the sampler, to be restored later.
+	Snapshot() Snapshot
+	// Restore restores the state of the sampler from a snapshot taken some time ago.
+	Restore(snapshot Snapshot, elapsed time.Duration)
+	// SetExplainer makes the sampler record its decisions in explainer.
+	SetExplainer(explainer *Explainer)
 }
 
 // Sampler is the main component of the sampling logic
@@ -60,6 +66,12 @@ type Sampler struct {
 	// signatureScoreFactor = math.Pow(signatureScoreSlope, math.Log10(scoreSamplingOffset))
 	signatureScoreFactor float64
 
+	// Factors applied to the sample rate of traces with errors, and of traces slower than most of their
+	// signature, tracked by latencies. A factor of 1 disables the boost.
+	errorBoost   float64
+	latencyBoost float64
+	latencies    *latencyTracker
+
 	exit chan struct{}
 }
 
@@ -70,6 +82,10 @@ func newSampler(extraRate float64, maxTPS float64) *Sampler {
 		extraRate: extraRate,
 		maxTPS:    maxTPS,
 
+		errorBoost:   1,
+		latencyBoost: 1,
+		latencies:    newLatencyTracker(),
+
 		exit: make(chan struct{}),
 	}
 
@@ -95,6 +111,12 @@ func (s *Sampler) UpdateMaxTPS(maxTPS float64) {
 	s.maxTPS = maxTPS
 }
 
+// UpdateBoosts updates the factors applied to the sample rate of traces with errors and of slow traces
+func (s *Sampler) UpdateBoosts(errorBoost float64, latencyBoost float64) {
+	s.errorBoost = errorBoost
+	s.latencyBoost = latencyBoost
+}
+
 // Run runs and block on the Sampler main loop
 func (s *Sampler) Run() {
 	go func() {
@@ -119,6 +141,7 @@ func (s *Sampler) RunAdjustScoring() {
 		select {
 		case <-t.C:
 			s.AdjustScoring()
+			s.latencies.prune(s.Backend)
 		case <-s.exit:
 			return
 		}
@@ -129,6 +152,9 @@ func (s *Sampler) RunAdjustScoring() {
 func (s *Sampler) GetSampleRate(trace model.Trace, root *model.Span, signature Signature) float64 {
 	sampleRate := s.GetSignatureSampleRate(signature) * s.extraRate
 
+	// Boosted traces are counted as any sampled trace, so that the maxTPS limit still applies.
+	sampleRate = capTo1(sampleRate * s.GetBoost(trace, root, signature))
+
 	return sampleRate
 }
 
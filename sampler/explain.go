@@ -0,0 +1,222 @@
+package sampler
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// The reasons of the sampling decisions
+const (
+	// reasonSignature is a decision taken with the sample rate of the signature
+	reasonSignature = "signature"
+	// reasonRulePrefix prefixes the name of the sampling rule which took the decision
+	reasonRulePrefix = "rule:"
+	// reasonMaxTPS is a trace dropped to respect the maxTPS limit
+	reasonMaxTPS = "max_tps"
+	// reasonTPSBudget is a trace dropped to respect the TPS budget of its env or service
+	reasonTPSBudget = "tps_budget"
+	// reasonRare is a trace kept by the rare sampler
+	reasonRare = "rare"
+	// reasonPriorityPrefix prefixes the sampling priority set by the client
+	reasonPriorityPrefix = "priority:"
+)
+
+// Decision is the sampling decision taken for a trace, and the reason for it
+type Decision struct {
+	TraceID uint64
+	Time    time.Time
+	// Engine is the engine which took the decision, "score" or "priority"
+	Engine    string
+	Signature Signature
+	Service   string
+	Name      string
+	Resource  string
+	Env       string
+	// Score is the score of the signature when the trace was sampled
+	Score float64
+	// SampleRate is the sample rate applied to the trace
+	SampleRate float64
+	Sampled    bool
+	// Reason tells which step of the engine took the decision
+	Reason string
+}
+
+// SignatureInfo sums up the decisions taken for the traces of a signature
+type SignatureInfo struct {
+	Signature Signature
+	Engine    string
+	Service   string
+	Name      string
+	Resource  string
+	Env       string
+	// Score and SampleRate are those of the last decision
+	Score      float64
+	SampleRate float64
+	Kept       int64
+	Seen       int64
+}
+
+// Explainer records the recent sampling decisions, so that one can tell why
+// a trace was kept or not. It keeps the last decisions in a ring buffer, and
+// sums them up by signature over a window.
+type Explainer struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	decisions []Decision // ring buffer of the last decisions
+	next      int        // the index of the next decision in decisions
+
+	// signatures sums up the decisions since windowStart, previous those of
+	// the window before
+	signatures  map[Signature]*SignatureInfo
+	previous    map[Signature]*SignatureInfo
+	windowStart time.Time
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewExplainer returns an Explainer keeping the last capacity decisions, and
+// summing them up by signature over window.
+func NewExplainer(capacity int, window time.Duration) *Explainer {
+	return &Explainer{
+		window:      window,
+		decisions:   make([]Decision, 0, capacity),
+		signatures:  make(map[Signature]*SignatureInfo),
+		previous:    make(map[Signature]*SignatureInfo),
+		windowStart: time.Now(),
+		now:         time.Now,
+	}
+}
+
+// rotate starts a new window if the current one is over
+func (e *Explainer) rotate(now time.Time) {
+	elapsed := now.Sub(e.windowStart)
+	if elapsed < e.window {
+		return
+	}
+	if elapsed < 2*e.window {
+		e.previous = e.signatures
+	} else {
+		e.previous = make(map[Signature]*SignatureInfo)
+	}
+	e.signatures = make(map[Signature]*SignatureInfo)
+	e.windowStart = now
+}
+
+// record records a decision
+func (e *Explainer) record(d Decision) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	d.Time = e.now()
+	e.rotate(d.Time)
+
+	if cap(e.decisions) > 0 {
+		if len(e.decisions) < cap(e.decisions) {
+			e.decisions = append(e.decisions, d)
+		} else {
+			e.decisions[e.next] = d
+		}
+		e.next = (e.next + 1) % cap(e.decisions)
+	}
+
+	info, ok := e.signatures[d.Signature]
+	if !ok {
+		info = &SignatureInfo{
+			Signature: d.Signature,
+			Engine:    d.Engine,
+			Service:   d.Service,
+			Name:      d.Name,
+			Resource:  d.Resource,
+			Env:       d.Env,
+		}
+		e.signatures[d.Signature] = info
+	}
+	info.Score = d.Score
+	info.SampleRate = d.SampleRate
+	info.Seen++
+	if d.Sampled {
+		info.Kept++
+	}
+}
+
+// explain records the decision taken by engine for the trace of root
+func (e *Explainer) explain(engine string, root *model.Span, env string, signature Signature, score float64, sampled bool, reason string) {
+	e.record(Decision{
+		TraceID:    root.TraceID,
+		Engine:     engine,
+		Signature:  signature,
+		Service:    root.Service,
+		Name:       root.Name,
+		Resource:   root.Resource,
+		Env:        env,
+		Score:      score,
+		SampleRate: GetTraceAppliedSampleRate(root),
+		Sampled:    sampled,
+		Reason:     reason,
+	})
+}
+
+// Lookup returns the recorded decisions taken for the traces of traceID, the
+// most recent first. There can be several of them for traces sent in parts.
+func (e *Explainer) Lookup(traceID uint64) []Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var decisions []Decision
+	for i := 1; i <= len(e.decisions); i++ {
+		// walk the ring buffer backwards from the last decision
+		d := e.decisions[(e.next-i+len(e.decisions))%len(e.decisions)]
+		if d.TraceID == traceID {
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions
+}
+
+// Signatures returns the signatures seen recently, between one and two
+// windows, the most seen first.
+func (e *Explainer) Signatures() []SignatureInfo {
+	e.mu.Lock()
+	e.rotate(e.now())
+
+	merged := make(map[Signature]SignatureInfo, len(e.previous)+len(e.signatures))
+	for sig, info := range e.previous {
+		merged[sig] = *info
+	}
+	for sig, info := range e.signatures {
+		if m, ok := merged[sig]; ok {
+			m.Score = info.Score
+			m.SampleRate = info.SampleRate
+			m.Kept += info.Kept
+			m.Seen += info.Seen
+			merged[sig] = m
+		} else {
+			merged[sig] = *info
+		}
+	}
+	e.mu.Unlock()
+
+	infos := make([]SignatureInfo, 0, len(merged))
+	for _, info := range merged {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Seen != infos[j].Seen {
+			return infos[i].Seen > infos[j].Seen
+		}
+		return infos[i].Signature < infos[j].Signature
+	})
+	return infos
+}
+
+// priorityReason returns the reason of the decisions taken with the sampling
+// priority set by clients
+func priorityReason(root *model.Span) string {
+	return reasonPriorityPrefix + strconv.FormatFloat(root.Metrics[samplingPriorityKey], 'f', -1, 64)
+}
@@ -0,0 +1,112 @@
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainerLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	e := NewExplainer(3, time.Minute)
+	for id := uint64(1); id <= 4; id++ {
+		e.record(Decision{TraceID: id, Reason: reasonSignature})
+	}
+	e.record(Decision{TraceID: 4, Reason: reasonMaxTPS})
+
+	// the oldest decisions were overwritten
+	assert.Len(e.Lookup(1), 0)
+	assert.Len(e.Lookup(2), 0)
+	assert.Len(e.Lookup(3), 1)
+
+	decisions := e.Lookup(4)
+	assert.Len(decisions, 2)
+	assert.Equal(reasonMaxTPS, decisions[0].Reason)
+	assert.Equal(reasonSignature, decisions[1].Reason)
+}
+
+func TestExplainerSignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	e := NewExplainer(10, time.Minute)
+	e.now = func() time.Time { return now }
+	e.windowStart = now
+
+	e.record(Decision{Signature: 1, Service: "web", Score: 1, Sampled: true})
+	e.record(Decision{Signature: 2, Service: "db"})
+	e.record(Decision{Signature: 2, Service: "db", Score: 2, SampleRate: 0.5})
+
+	infos := e.Signatures()
+	assert.Len(infos, 2)
+	assert.Equal(SignatureInfo{Signature: 2, Service: "db", Score: 2, SampleRate: 0.5, Seen: 2}, infos[0])
+	assert.Equal(SignatureInfo{Signature: 1, Service: "web", Score: 1, Kept: 1, Seen: 1}, infos[1])
+
+	// the previous window is still listed
+	now = now.Add(time.Minute)
+	e.record(Decision{Signature: 1, Service: "web", Score: 3, Sampled: true})
+	infos = e.Signatures()
+	assert.Len(infos, 2)
+	assert.Equal(SignatureInfo{Signature: 1, Service: "web", Score: 3, Kept: 2, Seen: 2}, infos[0])
+
+	// but not older ones
+	now = now.Add(time.Minute)
+	assert.Len(e.Signatures(), 1)
+	now = now.Add(2 * time.Minute)
+	assert.Len(e.Signatures(), 0)
+}
+
+func TestScoreEngineExplain(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewScoreEngine(1, 0, []config.SamplingRule{{Name: "drop", Conditions: []string{"resource:^drop$"}, Rate: 0}})
+	e := NewExplainer(10, time.Minute)
+	s.SetExplainer(e)
+
+	trace, root := getTestTrace()
+	assert.True(s.Sample(trace, root, defaultEnv))
+	decisions := e.Lookup(root.TraceID)
+	assert.Len(decisions, 1)
+	assert.Equal("score", decisions[0].Engine)
+	assert.Equal("mcnulty", decisions[0].Service)
+	assert.Equal(defaultEnv, decisions[0].Env)
+	assert.Equal(computeSignatureWithRootAndEnv(trace, root, defaultEnv), decisions[0].Signature)
+	assert.True(decisions[0].Sampled)
+	assert.Equal(reasonSignature, decisions[0].Reason)
+
+	trace, root = getTestTrace()
+	root.Resource = "drop"
+	assert.False(s.Sample(trace, root, defaultEnv))
+	decisions = e.Lookup(root.TraceID)
+	assert.False(decisions[0].Sampled)
+	assert.Equal("rule:drop", decisions[0].Reason)
+
+	// the maxTPS limit drops everything once reached
+	s = getTestScoreEngine()
+	s.Sampler.maxTPS = 1e-9
+	s.Sampler.Backend.CountSample()
+	s.SetExplainer(e)
+	trace, root = getTestTrace()
+	assert.False(s.Sample(trace, root, defaultEnv))
+	assert.Equal(reasonMaxTPS, e.Lookup(root.TraceID)[0].Reason)
+}
+
+func TestPriorityEngineExplain(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestPriorityEngine()
+	e := NewExplainer(10, time.Minute)
+	s.SetExplainer(e)
+
+	trace, root := getTestTraceWithService(t, testServiceA, s)
+	root.Metrics[samplingPriorityKey] = -1
+	assert.False(s.Sample(trace, root, defaultEnv))
+
+	decisions := e.Lookup(root.TraceID)
+	assert.Len(decisions, 1)
+	assert.Equal("priority", decisions[0].Engine)
+	assert.Equal("priority:-1", decisions[0].Reason)
+}
@@ -35,6 +35,12 @@ type PriorityEngine struct {
 	catalog       serviceKeyCatalog
 	catalogMu     sync.Mutex
 
+	// budgets limit the rates by service of env and services, nil if none
+	budgets *tpsBudgets
+
+	// explainer records the decisions of the engine, nil if disabled
+	explainer *Explainer
+
 	exit chan struct{}
 }
 
@@ -50,8 +56,24 @@ func NewPriorityEngine(extraRate float64, maxTPS float64, rateByService *config.
 	return s
 }
 
+// EnableTPSBudgets makes the engine lower the rates by service of the envs and services exceeding
+// their budget of traces per second. It has to be called before running the engine.
+func (s *PriorityEngine) EnableTPSBudgets(budgets config.TPSBudgets) {
+	s.budgets = newTPSBudgets(budgets)
+}
+
+// SetExplainer makes the engine record its decisions in explainer.
+// It has to be called before running the engine.
+func (s *PriorityEngine) SetExplainer(explainer *Explainer) {
+	s.explainer = explainer
+}
+
 // Run runs and block on the Sampler main loop
 func (s *PriorityEngine) Run() {
+	if s.budgets != nil {
+		s.budgets.run()
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -80,6 +102,9 @@ func (s *PriorityEngine) Run() {
 
 // Stop stops the main Run loop
 func (s *PriorityEngine) Stop() {
+	if s.budgets != nil {
+		s.budgets.stop()
+	}
 	s.Sampler.Stop()
 	close(s.exit)
 }
@@ -108,6 +133,14 @@ func (s *PriorityEngine) Sample(trace model.Trace, root *model.Span, env string)
 		// Count the trace to allow us to check for the maxTPS limit.
 		// It has to happen before the maxTPS sampling.
 		s.Sampler.Backend.CountSample()
+
+		if s.budgets != nil {
+			s.budgets.count(root.Service, env)
+		}
+	}
+
+	if s.explainer != nil {
+		s.explainer.explain("priority", root, env, signature, s.Sampler.Backend.GetSignatureScore(signature), sampled, priorityReason(root))
 	}
 
 	return sampled
@@ -116,7 +149,11 @@ func (s *PriorityEngine) Sample(trace model.Trace, root *model.Span, env string)
 // GetState collects and return internal statistics and coefficients for indication purposes
 // It returns an interface{}, as other samplers might return other informations.
 func (s *PriorityEngine) GetState() interface{} {
-	return s.Sampler.GetState()
+	state := s.Sampler.GetState()
+	if s.budgets != nil {
+		state.Budgets = s.budgets.stats()
+	}
+	return state
 }
 
 // getRateByService returns all rates by service, this information is useful for
@@ -124,5 +161,14 @@ func (s *PriorityEngine) GetState() interface{} {
 func (s *PriorityEngine) getRateByService() map[string]float64 {
 	defer s.catalogMu.Unlock()
 	s.catalogMu.Lock()
-	return s.catalog.getRateByService(s.Sampler.GetAllSignatureSampleRates(), s.Sampler.GetDefaultSampleRate())
+	rates := s.catalog.getRateByService(s.Sampler.GetAllSignatureSampleRates(), s.Sampler.GetDefaultSampleRate())
+
+	if s.budgets != nil {
+		tps := make(map[string]float64, len(s.catalog))
+		for key, sig := range s.catalog {
+			tps[key] = s.Sampler.Backend.GetSignatureScore(sig)
+		}
+		s.budgets.limitRates(rates, tps)
+	}
+	return rates
 }
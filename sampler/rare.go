@@ -0,0 +1,97 @@
+package sampler
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/model"
+)
+
+// rareSampledKey is the metric set on the root of the traces kept by the rare
+// sampler only
+const rareSampledKey = "_sampling_rare"
+
+// rareSignature is the last time a trace of a signature was kept
+type rareSignature struct {
+	signature Signature
+	lastKept  time.Time
+}
+
+// rareSampler keeps at least one trace per signature and per window, so that
+// low-volume signatures are not starved by the score sampler. It tracks at
+// most capacity signatures, forgetting the least recently seen first.
+type rareSampler struct {
+	window   time.Duration
+	capacity int
+
+	mu         sync.Mutex
+	lru        *list.List // of *rareSignature, most recently seen first
+	signatures map[Signature]*list.Element
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+}
+
+func newRareSampler(window time.Duration, capacity int) *rareSampler {
+	return &rareSampler{
+		window:     window,
+		capacity:   capacity,
+		lru:        list.New(),
+		signatures: make(map[Signature]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// get returns the tracked entry of signature, tracking it if needed, and
+// marks it as the most recently seen
+func (r *rareSampler) get(signature Signature) *rareSignature {
+	if e, ok := r.signatures[signature]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*rareSignature)
+	}
+
+	rs := &rareSignature{signature: signature}
+	r.signatures[signature] = r.lru.PushFront(rs)
+	for r.lru.Len() > r.capacity {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.signatures, oldest.Value.(*rareSignature).signature)
+	}
+	return rs
+}
+
+// sample tells if a trace of signature, which was not sampled otherwise, has
+// to be kept because no trace of its signature was kept within the window
+func (r *rareSampler) sample(signature Signature) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	rs := r.get(signature)
+	if !rs.lastKept.IsZero() && now.Sub(rs.lastKept) < r.window {
+		return false
+	}
+	rs.lastKept = now
+	return true
+}
+
+// record records that a trace of signature was kept
+func (r *rareSampler) record(signature Signature) {
+	r.mu.Lock()
+	r.get(signature).lastKept = r.now()
+	r.mu.Unlock()
+}
+
+// setRareSampled marks the trace as kept by the rare sampler
+func setRareSampled(root *model.Span) {
+	if root.Metrics == nil {
+		root.Metrics = make(map[string]float64)
+	}
+	root.Metrics[rareSampledKey] = 1
+}
+
+// IsRareSampled tells if the trace was kept by the rare sampler only
+func IsRareSampled(root *model.Span) bool {
+	return root.Metrics[rareSampledKey] == 1
+}
@@ -0,0 +1,143 @@
+package sampler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRareSamplerWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	r := newRareSampler(time.Minute, 10)
+	r.now = func() time.Time { return now }
+
+	// a new signature is kept once per window
+	assert.True(r.sample(1))
+	assert.False(r.sample(1))
+	now = now.Add(59 * time.Second)
+	assert.False(r.sample(1))
+	now = now.Add(time.Second)
+	assert.True(r.sample(1))
+
+	// a signature kept otherwise is not kept again within the window
+	r.record(2)
+	assert.False(r.sample(2))
+	now = now.Add(time.Minute)
+	assert.True(r.sample(2))
+}
+
+func TestRareSamplerLRU(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newRareSampler(time.Hour, 3)
+	for sig := Signature(1); sig <= 3; sig++ {
+		assert.True(r.sample(sig))
+	}
+	// seeing 1 again makes 2 the least recently seen signature
+	assert.False(r.sample(1))
+	assert.True(r.sample(4))
+
+	assert.Len(r.signatures, 3)
+	assert.Equal(3, r.lru.Len())
+	assert.NotContains(r.signatures, Signature(2))
+
+	// forgotten signatures are new again
+	assert.True(r.sample(2))
+	assert.NotContains(r.signatures, Signature(3))
+}
+
+func TestScoreEngineRareSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.EnableRareSampler(time.Hour, 100)
+	// drop everything, but the rare traces
+	s.Sampler.extraRate = 0
+
+	trace, root := getTestTrace()
+	assert.True(s.Sample(trace, root, defaultEnv))
+	assert.True(IsRareSampled(root))
+	// the rates applied by the other steps are not kept
+	assert.Equal(1.0, GetTraceAppliedSampleRate(root))
+
+	for i := 0; i < 100; i++ {
+		trace, root := getTestTrace()
+		assert.False(s.Sample(trace, root, defaultEnv))
+		assert.False(IsRareSampled(root))
+	}
+
+	// another signature
+	trace, root = getTestTrace()
+	trace[1].Service = "rare"
+	assert.True(s.Sample(trace, root, defaultEnv))
+	assert.True(IsRareSampled(root))
+
+	// rare traces are counted as samples
+	assert.InDelta(2, s.Sampler.Backend.GetSampledScore()*s.Sampler.Backend.countScaleFactor, 1e-9)
+	assert.Equal(int64(2), s.GetState().(InternalState).RareSampled)
+}
+
+func TestScoreEngineRareSamplerRules(t *testing.T) {
+	assert := assert.New(t)
+
+	// traces matching rules are left to them
+	s := NewScoreEngine(1, 0, []config.SamplingRule{{Name: "drop", Conditions: []string{"service:mcnulty"}, Rate: 0}})
+	s.EnableRareSampler(time.Hour, 100)
+
+	trace, root := getTestTrace()
+	assert.False(s.Sample(trace, root, defaultEnv))
+	assert.False(IsRareSampled(root))
+}
+
+func TestScoreEngineRareSamplerMaxTPS(t *testing.T) {
+	// Every trace has a new signature, all of them are rare
+	assert := assert.New(t)
+	s := getTestScoreEngine()
+	s.EnableRareSampler(time.Hour, 1e6)
+	// drop everything, but the rare traces
+	s.Sampler.extraRate = 0
+
+	maxTPS := 5.0
+	tps := 100.0
+	s.Sampler.maxTPS = maxTPS
+	backend := s.Sampler.Backend
+	periodSeconds := backend.decayPeriod.Seconds()
+	tracesPerPeriod := tps * periodSeconds
+
+	// the sampled score expected from the traces kept, before scaling
+	var expected float64
+	var kept, n int
+	for period := 0; period < 60; period++ {
+		backend.DecayScore()
+		expected /= backend.decayFactor
+		for i := 0; i < int(tracesPerPeriod); i++ {
+			trace, root := getTestTrace()
+			trace[0].Resource = fmt.Sprintf("resource-%d", n)
+			n++
+			if s.Sample(trace, root, defaultEnv) {
+				assert.True(IsRareSampled(root))
+				expected++
+				if period >= 30 {
+					kept++
+				}
+			}
+		}
+
+		// rare traces are counted in the sampled score
+		assert.InDelta(expected/backend.countScaleFactor, backend.GetSampledScore(), 1e-9, "period %d", period)
+		// which stops the rare sampler at the maxTPS limit
+		assert.True(backend.GetUpperSampledScore() < maxTPS+backend.decayFactor/backend.countScaleFactor, "period %d", period)
+	}
+
+	// in the steady state, the upper sampled score is kept right under maxTPS
+	assert.InEpsilon(maxTPS/backend.decayFactor, float64(kept)/(30*periodSeconds), 0.05)
+	// and the maxTPS sampling accounts for the rare traces
+	upper := expected / backend.countScaleFactor * backend.decayFactor
+	assert.True(upper > maxTPS)
+	assert.InDelta(maxTPS/upper, s.Sampler.GetMaxTPSSampleRate(), 1e-9)
+}
@@ -0,0 +1,222 @@
+package sampler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/DataDog/datadog-trace-agent/watchdog"
+)
+
+// ruleCondition tells if the root span of a trace, in the given env, matches
+// one of the conditions of a rule
+type ruleCondition func(root *model.Span, env string) bool
+
+// samplingRule is a user-defined rule giving the sample rate of the traces it
+// matches, instead of the one of their signature
+type samplingRule struct {
+	name       string
+	conditions []ruleCondition
+
+	// rate is the sample rate applied to matching traces
+	rate float64
+	// maxTPS is the maximum number of matching traces kept per second, 0 for
+	// no limit, enforced with backend which counts the kept traces
+	maxTPS  float64
+	backend *Backend
+
+	kept    int64 // the number of traces kept by this rule, atomic
+	dropped int64 // the number of traces dropped by this rule, atomic
+}
+
+// RuleStats holds the counts of traces a sampling rule kept and dropped since
+// the agent started
+type RuleStats struct {
+	Name    string
+	Rate    float64
+	MaxTPS  float64
+	Kept    int64
+	Dropped int64
+}
+
+// match returns true if the root span matches all the conditions of the rule
+func (r *samplingRule) match(root *model.Span, env string) bool {
+	for _, c := range r.conditions {
+		if !c(root, env) {
+			return false
+		}
+	}
+	return true
+}
+
+// getMaxTPSSampleRate returns an extra sample rate to apply if the rule kept
+// more than maxTPS traces per second recently
+func (r *samplingRule) getMaxTPSSampleRate() float64 {
+	if r.maxTPS <= 0 {
+		return 1
+	}
+	currentTPS := r.backend.GetUpperSampledScore()
+	if currentTPS > r.maxTPS {
+		return r.maxTPS / currentTPS
+	}
+	return 1
+}
+
+// sample tells if a trace matching the rule is kept, updating its applied
+// sample rate with the rate of the rule combined to extraRate
+func (r *samplingRule) sample(root *model.Span, extraRate float64) bool {
+	sampled := applySampleRate(root, r.rate*extraRate)
+	if sampled && r.maxTPS > 0 {
+		// as for the sampler maxTPS, count the trace before the extra sampling
+		r.backend.CountSample()
+		if rate := r.getMaxTPSSampleRate(); rate < 1 {
+			sampled = applySampleRate(root, rate)
+		}
+	}
+	return sampled
+}
+
+// count records the final decision taken for a trace matching the rule
+func (r *samplingRule) count(sampled bool) {
+	if sampled {
+		atomic.AddInt64(&r.kept, 1)
+	} else {
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+// samplingRules are sampling rules applied in order, the first one matching
+// a trace gives its sample rate
+type samplingRules []*samplingRule
+
+// newSamplingRules compiles the configured rules, logging and skipping the
+// invalid ones
+func newSamplingRules(conf []config.SamplingRule) samplingRules {
+	var rules samplingRules
+	for _, sr := range conf {
+		r, err := compileSamplingRule(sr)
+		if err != nil {
+			log.Errorf("invalid sampling rule %s: %v", sr.Name, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) > 0 {
+		log.Infof("sampling traces with %d sampling rules", len(rules))
+	}
+	return rules
+}
+
+// match returns the first rule matching the trace, or nil
+func (rules samplingRules) match(root *model.Span, env string) *samplingRule {
+	for _, r := range rules {
+		if r.match(root, env) {
+			return r
+		}
+	}
+	return nil
+}
+
+// run decays the counters of the rules limited by a maxTPS until they are
+// stopped
+func (rules samplingRules) run() {
+	for _, r := range rules {
+		if r.backend != nil {
+			go func(b *Backend) {
+				defer watchdog.LogOnPanic()
+				b.Run()
+			}(r.backend)
+		}
+	}
+}
+
+// stop stops the decay of the counters of the rules
+func (rules samplingRules) stop() {
+	for _, r := range rules {
+		if r.backend != nil {
+			r.backend.Stop()
+		}
+	}
+}
+
+// stats returns the counts of traces each rule kept and dropped
+func (rules samplingRules) stats() []RuleStats {
+	if len(rules) == 0 {
+		return nil
+	}
+	stats := make([]RuleStats, 0, len(rules))
+	for _, r := range rules {
+		stats = append(stats, RuleStats{
+			Name:    r.name,
+			Rate:    r.rate,
+			MaxTPS:  r.maxTPS,
+			Kept:    atomic.LoadInt64(&r.kept),
+			Dropped: atomic.LoadInt64(&r.dropped),
+		})
+	}
+	return stats
+}
+
+// compileSamplingRule compiles the conditions of a configured rule.
+// Conditions are:
+//   - service:<regexp>, name:<regexp>, resource:<regexp>, env:<regexp>
+//   - meta.<key>:<regexp>, the tag must be set and match
+func compileSamplingRule(sr config.SamplingRule) (*samplingRule, error) {
+	if len(sr.Conditions) == 0 {
+		return nil, fmt.Errorf("no conditions")
+	}
+	if sr.Rate < 0 || sr.Rate > 1 {
+		return nil, fmt.Errorf("rate %v is not between 0 and 1", sr.Rate)
+	}
+
+	r := &samplingRule{name: sr.Name, rate: sr.Rate}
+	if sr.MaxTPS > 0 {
+		r.maxTPS = sr.MaxTPS
+		r.backend = NewBackend(defaultDecayPeriod)
+	}
+	for _, expr := range sr.Conditions {
+		c, err := compileRuleCondition(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, err
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	return r, nil
+}
+
+func compileRuleCondition(expr string) (ruleCondition, error) {
+	parts := strings.SplitN(expr, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid condition %q", expr)
+	}
+	field, value := parts[0], parts[1]
+
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp in %q: %v", expr, err)
+	}
+
+	switch field {
+	case "service":
+		return func(s *model.Span, env string) bool { return re.MatchString(s.Service) }, nil
+	case "name":
+		return func(s *model.Span, env string) bool { return re.MatchString(s.Name) }, nil
+	case "resource":
+		return func(s *model.Span, env string) bool { return re.MatchString(s.Resource) }, nil
+	case "env":
+		return func(s *model.Span, env string) bool { return re.MatchString(env) }, nil
+	}
+
+	if key := strings.TrimPrefix(field, "meta."); key != field && key != "" {
+		return func(s *model.Span, env string) bool {
+			v, ok := s.Meta[key]
+			return ok && re.MatchString(v)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
@@ -0,0 +1,141 @@
+package sampler
+
+import (
+	"testing"
+
+	log "github.com/cihub/seelog"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/DataDog/datadog-trace-agent/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestRulesScoreEngine(rules ...config.SamplingRule) *ScoreEngine {
+	// Disable debug logs in these tests
+	log.UseLogger(log.Disabled)
+
+	return NewScoreEngine(1.0, 0.0, rules)
+}
+
+func TestSamplingRulesMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	root := &model.Span{
+		Service:  "checkout",
+		Name:     "http.request",
+		Resource: "POST /orders",
+		Meta:     map[string]string{"customer.tier": "gold"},
+	}
+
+	for _, tc := range []struct {
+		conditions []string
+		env        string
+		match      bool
+	}{
+		{[]string{"service:^checkout$"}, "prod", true},
+		{[]string{"service:^check$"}, "prod", false},
+		{[]string{"name:http", "resource:^POST "}, "prod", true},
+		{[]string{"name:http", "resource:^GET "}, "prod", false},
+		{[]string{"env:^prod$"}, "prod", true},
+		{[]string{"env:^prod$"}, "staging", false},
+		{[]string{"meta.customer.tier:gold|platinum"}, "prod", true},
+		{[]string{"meta.customer.id:.*"}, "prod", false},
+	} {
+		rules := newSamplingRules([]config.SamplingRule{{Name: "test", Conditions: tc.conditions, Rate: 1}})
+		assert.Len(rules, 1)
+		assert.Equal(tc.match, rules.match(root, tc.env) != nil, "%v", tc.conditions)
+	}
+}
+
+func TestSamplingRulesInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := newSamplingRules([]config.SamplingRule{
+		{Name: "no_conditions", Rate: 1},
+		{Name: "bad_rate", Conditions: []string{"service:web"}, Rate: 2},
+		{Name: "bad_regexp", Conditions: []string{"service:("}, Rate: 1},
+		{Name: "bad_field", Conditions: []string{"type:web"}, Rate: 1},
+		{Name: "valid", Conditions: []string{"service:web"}, Rate: 1},
+	})
+	assert.Len(rules, 1)
+	assert.Equal("valid", rules[0].name)
+}
+
+func TestSamplingRulesRate(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestRulesScoreEngine(
+		config.SamplingRule{Name: "drop", Conditions: []string{"env:^none$"}, Rate: 0},
+		config.SamplingRule{Name: "keep", Conditions: []string{"service:mcnulty"}, Rate: 1},
+	)
+
+	// the first matching rule applies
+	for i := 0; i < 100; i++ {
+		trace, root := getTestTrace()
+		assert.False(s.Sample(trace, root, defaultEnv))
+	}
+
+	// the rule overrides the signature score, which would drop most of
+	// these traces
+	for i := 0; i < 1000; i++ {
+		trace, root := getTestTrace()
+		assert.True(s.Sample(trace, root, "prod"))
+		assert.Equal(1.0, GetTraceAppliedSampleRate(root))
+	}
+
+	state := s.GetState().(InternalState)
+	assert.Equal([]RuleStats{
+		{Name: "drop", Rate: 0, Kept: 0, Dropped: 100},
+		{Name: "keep", Rate: 1, Kept: 1000, Dropped: 0},
+	}, state.Rules)
+
+	// the signatures are still scored
+	assert.True(s.Sampler.Backend.GetTotalScore() > 0)
+}
+
+func TestSamplingRulesChainedSampling(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestRulesScoreEngine(config.SamplingRule{Name: "half", Conditions: []string{"service:mcnulty"}, Rate: 0.5})
+	s.Sampler.extraRate = 0.8
+
+	trace, root := getTestTrace()
+	SetTraceAppliedSampleRate(root, 0.5)
+	s.Sample(trace, root, defaultEnv)
+	assert.InDelta(0.2, GetTraceAppliedSampleRate(root), 1e-9)
+}
+
+func TestSamplingRulesMaxTPS(t *testing.T) {
+	assert := assert.New(t)
+
+	maxTPS := 5.0
+	tps := 100.0
+	initPeriods := 20
+	periods := 50
+
+	s := getTestRulesScoreEngine(config.SamplingRule{Name: "capped", Conditions: []string{"service:mcnulty"}, Rate: 1, MaxTPS: maxTPS})
+	r := s.rules[0]
+	periodSeconds := r.backend.decayPeriod.Seconds()
+	tracesPerPeriod := tps * periodSeconds
+
+	sampledCount := 0
+	for period := 0; period < initPeriods+periods; period++ {
+		r.backend.DecayScore()
+		for i := 0; i < int(tracesPerPeriod); i++ {
+			trace, root := getTestTrace()
+			sampled := s.Sample(trace, root, defaultEnv)
+			if period > initPeriods && sampled {
+				sampledCount++
+				// the applied sample rate reflects the extra sampling
+				assert.True(GetTraceAppliedSampleRate(root) < 1)
+			}
+		}
+	}
+
+	// We should have a throughput of sampled traces around maxTPS
+	assert.InEpsilon(maxTPS, float64(sampledCount)/(float64(periods)*periodSeconds),
+		0.01+r.backend.decayFactor-1)
+
+	stats := s.rules.stats()
+	assert.Equal(int64((initPeriods+periods)*int(tracesPerPeriod)), stats[0].Kept+stats[0].Dropped)
+}
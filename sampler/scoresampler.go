@@ -14,6 +14,10 @@
 package sampler
 
 import (
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
 	"github.com/DataDog/datadog-trace-agent/model"
 )
 
@@ -21,24 +25,64 @@ import (
 type ScoreEngine struct {
 	// Sampler is the underlying sampler used by this engine, sharing logic among various engines.
 	Sampler *Sampler
+
+	// rules override the signature sample rate of the traces they match
+	rules samplingRules
+
+	// rare keeps the traces of signatures which were not kept recently, nil if disabled
+	rare        *rareSampler
+	rareSampled int64 // the number of traces kept by the rare sampler, atomic
+
+	// budgets limit the traces kept by env and by service, nil if none
+	budgets *tpsBudgets
+
+	// explainer records the decisions of the engine, nil if disabled
+	explainer *Explainer
 }
 
 // NewScoreEngine returns an initialized Sampler
-func NewScoreEngine(extraRate float64, maxTPS float64) *ScoreEngine {
+func NewScoreEngine(extraRate float64, maxTPS float64, rules []config.SamplingRule) *ScoreEngine {
 	s := &ScoreEngine{
 		Sampler: newSampler(extraRate, maxTPS),
+		rules:   newSamplingRules(rules),
 	}
 
 	return s
 }
 
+// EnableRareSampler makes the engine keep at least a trace per signature every window, for at most
+// capacity signatures. It has to be called before running the engine.
+func (s *ScoreEngine) EnableRareSampler(window time.Duration, capacity int) {
+	s.rare = newRareSampler(window, capacity)
+}
+
+// EnableTPSBudgets makes the engine limit the number of traces kept per second by env and by service.
+// It has to be called before running the engine.
+func (s *ScoreEngine) EnableTPSBudgets(budgets config.TPSBudgets) {
+	s.budgets = newTPSBudgets(budgets)
+}
+
+// SetExplainer makes the engine record its decisions in explainer.
+// It has to be called before running the engine.
+func (s *ScoreEngine) SetExplainer(explainer *Explainer) {
+	s.explainer = explainer
+}
+
 // Run runs and block on the Sampler main loop
 func (s *ScoreEngine) Run() {
+	s.rules.run()
+	if s.budgets != nil {
+		s.budgets.run()
+	}
 	s.Sampler.Run()
 }
 
 // Stop stops the main Run loop
 func (s *ScoreEngine) Stop() {
+	s.rules.stop()
+	if s.budgets != nil {
+		s.budgets.stop()
+	}
 	s.Sampler.Stop()
 }
 
@@ -60,14 +104,30 @@ func (s *ScoreEngine) Sample(trace model.Trace, root *model.Span, env string) bo
 	}
 
 	signature := computeSignatureWithRootAndEnv(trace, root, env)
+	// the rate applied before this engine, restored on the traces kept by the rare sampler
+	initialRate := GetTraceAppliedSampleRate(root)
 
 	// Update sampler state by counting this trace
 	s.Sampler.Backend.CountSignature(signature)
+	s.Sampler.CountLatency(signature, root)
+
+	// A matching rule gives the sample rate instead of the signature score,
+	// the extra sample rate and the maxTPS limit still apply, but not the rare sampler.
+	rule := s.rules.match(root, env)
+
+	var sampled bool
+	var reason string
+	if rule != nil {
+		sampled = rule.sample(root, s.Sampler.extraRate)
+		reason = reasonRulePrefix + rule.name
+	} else {
+		sampleRate := s.Sampler.GetSampleRate(trace, root, signature)
+		sampled = applySampleRate(root, sampleRate)
+		reason = reasonSignature
+	}
 
-	sampleRate := s.Sampler.GetSampleRate(trace, root, signature)
-
-	sampled := applySampleRate(root, sampleRate)
-
+	// Traces dropped by the maxTPS sampling are counted as samples anyway.
+	counted := sampled
 	if sampled {
 		// Count the trace to allow us to check for the maxTPS limit.
 		// It has to happen before the maxTPS sampling.
@@ -77,15 +137,70 @@ func (s *ScoreEngine) Sample(trace model.Trace, root *model.Span, env string) bo
 		// No need to check if we already decided not to keep the trace.
 		maxTPSrate := s.Sampler.GetMaxTPSSampleRate()
 		if maxTPSrate < 1 {
-			sampled = applySampleRate(root, maxTPSrate)
+			if sampled = applySampleRate(root, maxTPSrate); !sampled {
+				reason = reasonMaxTPS
+			}
+		}
+	}
+
+	budgetCounted := sampled && s.budgets != nil
+	if budgetCounted {
+		// As for the maxTPS limit, count the trace before the extra sampling of exceeded budgets.
+		s.budgets.count(root.Service, env)
+		if budgetRate := s.budgets.getSampleRate(root.Service, env); budgetRate < 1 {
+			if sampled = applySampleRate(root, budgetRate); !sampled {
+				reason = reasonTPSBudget
+			}
+		}
+	}
+
+	if rule != nil {
+		rule.count(sampled)
+	} else if s.rare != nil {
+		if sampled {
+			s.rare.record(signature)
+		} else if s.underMaxTPS() && s.underBudgets(root.Service, env) && s.rare.sample(signature) {
+			// Rare traces are counted as any sample so that the maxTPS limit and the budgets account for them.
+			if !counted {
+				s.Sampler.Backend.CountSample()
+			}
+			if s.budgets != nil && !budgetCounted {
+				s.budgets.count(root.Service, env)
+			}
+			// A rare trace stands for itself only, not for the traces the other steps dropped.
+			SetTraceAppliedSampleRate(root, initialRate)
+			setRareSampled(root)
+			atomic.AddInt64(&s.rareSampled, 1)
+			sampled = true
+			reason = reasonRare
 		}
 	}
 
+	if s.explainer != nil {
+		s.explainer.explain("score", root, env, signature, s.Sampler.Backend.GetSignatureScore(signature), sampled, reason)
+	}
+
 	return sampled
 }
 
+// underMaxTPS tells if fewer traces than maxTPS are kept, so that the rare sampler can keep more
+func (s *ScoreEngine) underMaxTPS() bool {
+	return s.Sampler.maxTPS <= 0 || s.Sampler.Backend.GetUpperSampledScore() < s.Sampler.maxTPS
+}
+
+// underBudgets tells if the TPS budgets of service in env are not exceeded, so that the rare sampler can keep more
+func (s *ScoreEngine) underBudgets(service, env string) bool {
+	return s.budgets == nil || s.budgets.getSampleRate(service, env) >= 1
+}
+
 // GetState collects and return internal statistics and coefficients for indication purposes
 // It returns an interface{}, as other samplers might return other informations.
 func (s *ScoreEngine) GetState() interface{} {
-	return s.Sampler.GetState()
+	state := s.Sampler.GetState()
+	state.Rules = s.rules.stats()
+	state.RareSampled = atomic.LoadInt64(&s.rareSampled)
+	if s.budgets != nil {
+		state.Budgets = s.budgets.stats()
+	}
+	return state
 }
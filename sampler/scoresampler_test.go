@@ -21,7 +21,7 @@ func getTestScoreEngine() *ScoreEngine {
 	extraRate := 1.0
 	maxTPS := 0.0
 
-	return NewScoreEngine(extraRate, maxTPS)
+	return NewScoreEngine(extraRate, maxTPS, nil)
 }
 
 func getTestTrace() (model.Trace, *model.Span) {
@@ -0,0 +1,129 @@
+package sampler
+
+import (
+	"math"
+	"time"
+)
+
+// Snapshot is the state of a sampler engine, which can be saved and restored
+// so that the agent does not start from scratch after a restart
+type Snapshot struct {
+	// Scores, TotalScore and SampledScore are the raw counters of the backend
+	Scores       map[Signature]float64
+	TotalScore   float64
+	SampledScore float64
+
+	// Offset and Slope are the signature scoring coefficients
+	Offset float64
+	Slope  float64
+
+	// Services maps the service keys of the PriorityEngine to their
+	// signature, from which its rates by service are computed
+	Services map[string]Signature `json:",omitempty"`
+}
+
+// snapshot returns the raw counters of the backend
+func (b *Backend) snapshot() (scores map[Signature]float64, totalScore, sampledScore float64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	scores = make(map[Signature]float64, len(b.scores))
+	for sig, score := range b.scores {
+		scores[sig] = score
+	}
+	return scores, b.totalScore, b.sampledScore
+}
+
+// restore replaces the counters of the backend, decaying them as if elapsed
+// time had passed since they were saved. Invalid counters are ignored.
+func (b *Backend) restore(scores map[Signature]float64, totalScore, sampledScore float64, elapsed time.Duration) {
+	decay := 1.0
+	if elapsed > 0 {
+		decay = math.Pow(b.decayFactor, float64(elapsed/b.decayPeriod))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.scores = make(map[Signature]float64, len(scores))
+	for sig, score := range scores {
+		if !isValidScore(score) {
+			continue
+		}
+		// as DecayScore, drop the entries which do not matter anymore
+		if score /= decay; score > minSignatureScoreOffset {
+			b.scores[sig] = score
+		}
+	}
+	b.totalScore, b.sampledScore = 0, 0
+	if isValidScore(totalScore) {
+		b.totalScore = totalScore / decay
+	}
+	if isValidScore(sampledScore) {
+		b.sampledScore = sampledScore / decay
+	}
+}
+
+func isValidScore(score float64) bool {
+	return score >= 0 && !math.IsInf(score, 0) && !math.IsNaN(score)
+}
+
+// Snapshot returns the state of the sampler
+func (s *Sampler) Snapshot() Snapshot {
+	var snap Snapshot
+	snap.Scores, snap.TotalScore, snap.SampledScore = s.Backend.snapshot()
+	snap.Offset = s.signatureScoreOffset
+	snap.Slope = s.signatureScoreSlope
+	return snap
+}
+
+// Restore restores the state of the sampler from a snapshot taken elapsed
+// time ago. It has to be called before running the sampler.
+func (s *Sampler) Restore(snap Snapshot, elapsed time.Duration) {
+	s.Backend.restore(snap.Scores, snap.TotalScore, snap.SampledScore, elapsed)
+	if snap.Offset >= minSignatureScoreOffset && snap.Slope > 1 && isValidScore(snap.Offset) && isValidScore(snap.Slope) {
+		s.SetSignatureCoefficients(snap.Offset, snap.Slope)
+	}
+}
+
+// Snapshot returns the state of the engine
+func (s *ScoreEngine) Snapshot() Snapshot {
+	return s.Sampler.Snapshot()
+}
+
+// Restore restores the state of the engine from a snapshot taken elapsed
+// time ago. It has to be called before running the engine.
+func (s *ScoreEngine) Restore(snap Snapshot, elapsed time.Duration) {
+	s.Sampler.Restore(snap, elapsed)
+}
+
+// Snapshot returns the state of the engine
+func (s *PriorityEngine) Snapshot() Snapshot {
+	snap := s.Sampler.Snapshot()
+
+	s.catalogMu.Lock()
+	snap.Services = make(map[string]Signature, len(s.catalog))
+	for key, sig := range s.catalog {
+		snap.Services[key] = sig
+	}
+	s.catalogMu.Unlock()
+
+	return snap
+}
+
+// Restore restores the state of the engine from a snapshot taken elapsed
+// time ago, and the rates by service sent to tracers. It has to be called
+// before running the engine.
+func (s *PriorityEngine) Restore(snap Snapshot, elapsed time.Duration) {
+	s.Sampler.Restore(snap, elapsed)
+
+	s.catalogMu.Lock()
+	s.catalog = newServiceKeyCatalog()
+	for key, sig := range snap.Services {
+		s.catalog[key] = sig
+	}
+	s.catalogMu.Unlock()
+
+	// services whose score decayed too much are removed from the catalog
+	s.rateByService.SetAll(s.getRateByService())
+}
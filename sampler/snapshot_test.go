@@ -0,0 +1,83 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-trace-agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScoreEngineSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	trace, root := getTestTrace()
+	signature := computeSignatureWithRootAndEnv(trace, root, defaultEnv)
+	for i := 0; i < 1000; i++ {
+		s.Sample(trace, root, defaultEnv)
+	}
+	s.Sampler.SetSignatureCoefficients(2, 4)
+
+	snap := s.Snapshot()
+	assert.Equal(1000.0, snap.Scores[signature])
+	assert.Equal(1000.0, snap.TotalScore)
+	assert.Equal(2.0, snap.Offset)
+	assert.Equal(4.0, snap.Slope)
+
+	// restored right away, the state is the same
+	restored := getTestScoreEngine()
+	restored.Restore(snap, 0)
+	assert.Equal(s.Sampler.GetSampleRate(trace, root, signature), restored.Sampler.GetSampleRate(trace, root, signature))
+	assert.Equal(s.Sampler.Backend.GetSampledScore(), restored.Sampler.Backend.GetSampledScore())
+
+	// restored later, scores are decayed for the elapsed time
+	restored = getTestScoreEngine()
+	restored.Restore(snap, 3*defaultDecayPeriod+time.Second)
+	decay := math.Pow(restored.Sampler.Backend.decayFactor, 3)
+	assert.InDelta(s.Sampler.Backend.GetSignatureScore(signature)/decay, restored.Sampler.Backend.GetSignatureScore(signature), 1e-9)
+	assert.InDelta(s.Sampler.Backend.GetTotalScore()/decay, restored.Sampler.Backend.GetTotalScore(), 1e-9)
+
+	// restored much later, scores vanished
+	restored = getTestScoreEngine()
+	restored.Restore(snap, time.Hour)
+	assert.Equal(int64(0), restored.Sampler.Backend.GetCardinality())
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.Restore(Snapshot{
+		Scores:       map[Signature]float64{1: -1, 2: math.Inf(1), 3: 5},
+		TotalScore:   math.NaN(),
+		SampledScore: -3,
+		Offset:       0,
+		Slope:        -1,
+	}, 0)
+
+	assert.Equal(map[Signature]float64{3: 5}, s.Snapshot().Scores)
+	assert.Equal(0.0, s.Sampler.Backend.GetTotalScore())
+	assert.Equal(0.0, s.Sampler.Backend.GetSampledScore())
+	assert.Equal(initialSignatureScoreOffset, s.Sampler.signatureScoreOffset)
+	assert.Equal(defaultSignatureScoreSlope, s.Sampler.signatureScoreSlope)
+}
+
+func TestPriorityEngineSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestPriorityEngine()
+	for i := 0; i < 100; i++ {
+		trace, root := getTestTraceWithService(t, testServiceA, s)
+		s.Sample(trace, root, defaultEnv)
+	}
+	snap := s.Snapshot()
+	assert.Len(snap.Services, 1)
+
+	var rbs config.RateByService
+	restored := NewPriorityEngine(1.0, 0.0, &rbs)
+	restored.Restore(snap, 0)
+	assert.Equal(s.getRateByService(), rbs.GetAll())
+	assert.Contains(rbs.GetAll(), byServiceKey(testServiceA, defaultEnv))
+}
@@ -8,6 +8,12 @@ type InternalState struct {
 	InTPS       float64
 	OutTPS      float64
 	MaxTPS      float64
+	// Rules holds the counts of the sampling rules, if any
+	Rules []RuleStats `json:",omitempty"`
+	// RareSampled is the number of traces kept by the rare sampler only
+	RareSampled int64 `json:",omitempty"`
+	// Budgets holds the utilization of the TPS budgets, if any
+	Budgets []BudgetStats `json:",omitempty"`
 }
 
 // GetState collects and return internal statistics and coefficients for indication purposes